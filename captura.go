@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Padrões de captura (coluna 10 da primeira aba do metadado) extraem valores do
+// nome do arquivo, ex.: "chamados abertos - {empresa} - {yyyy}-{mm}*". O padrão
+// vale para o nome inteiro, sem extensão; * aceita qualquer sequência e espaços
+// aceitam qualquer quantidade de espaços. Também aceita re: com grupos
+// nomeados empresa, yyyy, mm e dd; a expressão é mantida como foi escrita e
+// compilada sem diferenciar maiúsculas.
+//
+// Os valores capturados viram colunas da saída depois de idempresa, definem a
+// partição de diretorios.csvparticao e, quando há {yyyy}, o nome do CSV passa a
+// ser o do período, então o reenvio do mesmo período substitui a carga anterior.
+var camposCaptura = []struct {
+	nome   string
+	coluna string
+	expr   string
+}{
+	{"empresa", "arq_empresa", `.+?`},
+	{"yyyy", "arq_ano", `\d{4}`},
+	{"mm", "arq_mes", `\d{1,2}`},
+	{"dd", "arq_dia", `\d{1,2}`},
+}
+
+var espacos = regexp.MustCompile(`\s+`)
+
+func compilarCaptura(valor string) (*regexp.Regexp, error) {
+	valor = strings.TrimSpace(strings.Replace(valor, "–", "-", -1))
+	if strings.HasPrefix(strings.ToLower(valor), "re:") {
+		return regexp.Compile("(?i)" + valor[len("re:"):])
+	}
+	valor = strings.ToLower(valor)
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(valor); {
+		achou := false
+		for _, c := range camposCaptura {
+			if strings.HasPrefix(valor[i:], "{"+c.nome+"}") {
+				b.WriteString(fmt.Sprintf("(?P<%s>%s)", c.nome, c.expr))
+				i += len(c.nome) + 2
+				achou = true
+				break
+			}
+		}
+		if achou {
+			continue
+		}
+		switch {
+		case valor[i] == '*':
+			b.WriteString(".*")
+			i++
+		case espacos.MatchString(valor[i : i+1]):
+			for i < len(valor) && espacos.MatchString(valor[i:i+1]) {
+				i++
+			}
+			b.WriteString(`\s*`)
+		default:
+			j := i + 1
+			for j < len(valor) && !strings.ContainsAny(valor[j:j+1], "{* \t") {
+				j++
+			}
+			b.WriteString(regexp.QuoteMeta(valor[i:j]))
+			i = j
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// capturaNome são os valores extraídos do nome do arquivo, com as colunas que
+// o padrão define (mesmo quando o nome não casou, para o cabeçalho ser estável).
+type capturaNome struct {
+	Colunas []string
+	Valores []string
+	campos  map[string]string
+}
+
+// capturarNomeArquivo aplica o padrão de captura da chave ao nome do arquivo
+// (sem extensão).
+func capturarNomeArquivo(chave string, nome string) capturaNome {
+	c := capturaNome{campos: make(map[string]string)}
+	var re *regexp.Regexp
+	for _, p := range padroesArquivo {
+		if p.Chave == chave && p.captura != nil {
+			re = p.captura
+			break
+		}
+	}
+	if re == nil {
+		return c
+	}
+
+	nome = strings.TrimSpace(strings.Replace(nomeSemExtensao(strings.ToLower(nome)), "–", "-", -1))
+	m := re.FindStringSubmatch(nome)
+	for _, campo := range camposCaptura {
+		i := re.SubexpIndex(campo.nome)
+		if i < 0 {
+			continue
+		}
+		valor := ""
+		if m != nil {
+			valor = normalizarCaptura(campo.nome, strings.TrimSpace(m[i]))
+		}
+		c.Colunas = append(c.Colunas, campo.coluna)
+		c.Valores = append(c.Valores, valor)
+		c.campos[campo.nome] = valor
+	}
+	return c
+}
+
+// normalizarCaptura completa mês e dia com zero à esquerda e descarta datas
+// impossíveis.
+func normalizarCaptura(campo string, valor string) string {
+	limite := map[string]int{"mm": 12, "dd": 31}[campo]
+	if limite == 0 || valor == "" {
+		return valor
+	}
+	n, err := strconv.Atoi(valor)
+	if err != nil || n < 1 || n > limite {
+		return ""
+	}
+	return fmt.Sprintf("%02d", n)
+}
+
+// Periodo devolve yyyy-mm-dd com as partes capturadas, ou "" sem o ano.
+func (c capturaNome) Periodo() string {
+	if c.campos["yyyy"] == "" {
+		return ""
+	}
+	periodo := c.campos["yyyy"]
+	for _, campo := range []string{"mm", "dd"} {
+		if c.campos[campo] == "" {
+			break
+		}
+		periodo = periodo + "-" + c.campos[campo]
+	}
+	return periodo
+}
+
+// Mapa devolve os valores capturados por campo, para o ledger.
+func (c capturaNome) Mapa() map[string]string {
+	m := make(map[string]string)
+	for k, v := range c.campos {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+var caracteresInvalidos = regexp.MustCompile(`[^a-z0-9 _.-]+`)
+
+// destinoCSV monta o caminho do CSV de uma aba. Sem padrão de captura mantém o
+// nome antigo (agrupador_aba_arquivo.csv em CSVGerados).
+func destinoCSV(chave string, nome string, nomeAgrupador string, nomesheet string) string {
+	c := capturarNomeArquivo(chave, nome)
+	dir := config.Configuracao.Diretorios.CSVGerados
+	if p := c.particao(config.Configuracao.Diretorios.CSVParticao); p != "" {
+		dir = dir + "\\" + p
+		os.MkdirAll(dir, os.ModeType)
+	}
+	if periodo := c.Periodo(); periodo != "" {
+		nome = caracteresInvalidos.ReplaceAllString(strings.Join([]string{chave, c.campos["empresa"], periodo}, "_"), "_")
+	}
+	return fmt.Sprintf("%s\\%s_%s%s.csv", dir, nomeAgrupador, nomesheet, nome)
+}
+
+// particao troca {empresa}, {yyyy}, {mm} e {dd} no modelo. Devolve "" quando
+// falta algum valor usado no modelo.
+func (c capturaNome) particao(modelo string) string {
+	if modelo == "" {
+		return ""
+	}
+	for _, campo := range camposCaptura {
+		marca := "{" + campo.nome + "}"
+		if !strings.Contains(modelo, marca) {
+			continue
+		}
+		if c.campos[campo.nome] == "" {
+			return ""
+		}
+		modelo = strings.Replace(modelo, marca, caracteresInvalidos.ReplaceAllString(c.campos[campo.nome], "_"), -1)
+	}
+	return modelo
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func usarCaptura(t *testing.T, chave string, padrao string) {
+	re, err := compilarCaptura(padrao)
+	if err != nil {
+		t.Fatalf("compilarCaptura(%q): %v", padrao, err)
+	}
+	padroesArquivo = []padraoArquivo{{Chave: chave, Texto: chave, captura: re}}
+	t.Cleanup(func() { padroesArquivo = nil })
+}
+
+func TestCapturarNomeArquivo(t *testing.T) {
+	casos := []struct {
+		padrao  string
+		nome    string
+		colunas string
+		valores string
+		periodo string
+	}{
+		{"chamados abertos - {empresa} - {yyyy}-{mm}*", "Chamados Abertos - Stef - 2016-3 final.xlsx", "arq_empresa,arq_ano,arq_mes", "stef,2016,03", "2016-03"},
+		{"chamados   abertos {yyyy}{mm}{dd}", "chamados abertos 20160105.xlsx", "arq_ano,arq_mes,arq_dia", "2016,01,05", "2016-01-05"},
+		{"chamados {yyyy}-{mm}", "chamados 2016-13.xlsx", "arq_ano,arq_mes", "2016,", "2016"},
+		{"chamados {yyyy}", "outro nome.xlsx", "arq_ano", "", ""},
+		{`re:^chamados (?P<yyyy>\d{4})(?P<mm>\d{2})$`, "chamados 201602.xlsm", "arq_ano,arq_mes", "2016,02", "2016-02"},
+		// \D é "não dígito"; o padrão não pode ser normalizado para minúsculas.
+		{`re:^(?P<empresa>\D+) (?P<yyyy>\d{4})$`, "STEF 2016.xlsx", "arq_empresa,arq_ano", "stef,2016", "2016"},
+		{`RE:^Chamados (?P<yyyy>\d{4})$`, "chamados 2016.xlsx", "arq_ano", "2016", "2016"},
+	}
+	for _, c := range casos {
+		usarCaptura(t, "chamados", c.padrao)
+		r := capturarNomeArquivo("chamados", c.nome)
+		if strings.Join(r.Colunas, ",") != c.colunas || strings.Join(r.Valores, ",") != c.valores {
+			t.Errorf("%q em %q: colunas %v, valores %v; esperado %s e %s", c.padrao, c.nome, r.Colunas, r.Valores, c.colunas, c.valores)
+		}
+		if p := r.Periodo(); p != c.periodo {
+			t.Errorf("%q em %q: período %q, esperado %q", c.padrao, c.nome, p, c.periodo)
+		}
+	}
+}
+
+func TestCapturarNomeArquivoSemPadrao(t *testing.T) {
+	padroesArquivo = []padraoArquivo{{Chave: "chamados", Texto: "chamados"}}
+	defer func() { padroesArquivo = nil }()
+	r := capturarNomeArquivo("chamados", "chamados 2016.xlsx")
+	if r.Colunas != nil || r.Mapa() != nil || r.Periodo() != "" {
+		t.Errorf("captura sem padrão = %+v", r)
+	}
+}
+
+func TestCompilarCapturaInvalida(t *testing.T) {
+	if _, err := compilarCaptura("re:(?P<yyyy>"); err == nil {
+		t.Error("expressão inválida não deu erro")
+	}
+}
+
+func TestParticao(t *testing.T) {
+	usarCaptura(t, "chamados", "chamados - {empresa} - {yyyy}-{mm}")
+	r := capturarNomeArquivo("chamados", "chamados - stef/bh - 2016-02.xlsx")
+	casos := []struct {
+		modelo   string
+		esperado string
+	}{
+		{"{empresa}\\{yyyy}-{mm}", "stef_bh\\2016-02"},
+		{"{yyyy}", "2016"},
+		{"{yyyy}\\{dd}", ""},
+		{"", ""},
+	}
+	for _, c := range casos {
+		if p := r.particao(c.modelo); p != c.esperado {
+			t.Errorf("particao(%q) = %q, esperado %q", c.modelo, p, c.esperado)
+		}
+	}
+}
+
+func TestNormalizarCaptura(t *testing.T) {
+	casos := []struct {
+		campo, valor, esperado string
+	}{
+		{"mm", "3", "03"},
+		{"mm", "12", "12"},
+		{"mm", "13", ""},
+		{"dd", "0", ""},
+		{"dd", "31", "31"},
+		{"yyyy", "2016", "2016"},
+		{"empresa", "stef", "stef"},
+	}
+	for _, c := range casos {
+		if v := normalizarCaptura(c.campo, c.valor); v != c.esperado {
+			t.Errorf("normalizarCaptura(%q, %q) = %q, esperado %q", c.campo, c.valor, v, c.esperado)
+		}
+	}
+}
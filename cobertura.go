@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tealeg/xlsx"
+)
+
+// grupoCobertura reúne os arquivos sem metadado que têm o mesmo nome
+// normalizado, com a chave de dicArquivo mais parecida e o rascunho das
+// linhas de metadado montado a partir do primeiro arquivo legível.
+type grupoCobertura struct {
+	Nome      string
+	Arquivos  []string
+	Chave     string
+	Empresa   string
+	Agrupador string
+	Score     float64
+	Abas      []string
+	Rascunho  []linhaEstrutura
+	Erros     []string
+}
+
+var (
+	copiaArquivo = regexp.MustCompile(`\s*\(\d+\)$`)
+	dataNoNome   = regexp.MustCompile(`\d{4}[-_.]?\d{1,2}([-_.]?\d{1,2})?|\d{1,2}[-_.]\d{4}`)
+	separadores  = regexp.MustCompile(`[\s_-]*[-_][\s_-]*|\s+`)
+)
+
+// normalizarNomeArquivo tira extensão, cópias " (1)" e datas, para agrupar os
+// envios do mesmo relatório.
+func normalizarNomeArquivo(nome string) string {
+	nome = strings.Replace(nomeSemExtensao(strings.ToLower(strings.TrimSpace(nome))), "–", "-", -1)
+	nome = copiaArquivo.ReplaceAllString(nome, "")
+	nome = dataNoNome.ReplaceAllString(nome, "")
+	nome = separadores.ReplaceAllStringFunc(nome, func(s string) string {
+		if strings.ContainsAny(s, "-_") {
+			return "-"
+		}
+		return " "
+	})
+	return strings.Trim(nome, " -")
+}
+
+// coberturaMetadado agrupa as planilhas de dir e propõe, para cada grupo, a
+// chave mais parecida e linhas de metadado com as abas e colunas encontradas.
+// Empresa e agrupador só são preenchidos com similaridade >= minimo (%).
+func coberturaMetadado(dir string, minimo float64) ([]*grupoCobertura, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	files, err := d.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	grupos := make(map[string]*grupoCobertura)
+	var nomes []string
+	for _, f := range files {
+		if !f.Mode().IsRegular() || !strings.Contains(strings.ToLower(f.Name()), ".xls") {
+			continue
+		}
+		nome := normalizarNomeArquivo(f.Name())
+		g, ok := grupos[nome]
+		if !ok {
+			g = &grupoCobertura{Nome: nome}
+			g.Empresa, g.Chave, g.Score = adivinharEmpresa(nome)
+			if emp, ok := dic[dicArquivo[g.Chave]]; ok {
+				g.Agrupador = emp[1]
+			}
+			if g.Score < minimo {
+				g.Empresa, g.Agrupador = "", ""
+			}
+			grupos[nome] = g
+			nomes = append(nomes, nome)
+		}
+		g.Arquivos = append(g.Arquivos, f.Name())
+	}
+
+	var lista []*grupoCobertura
+	sort.Strings(nomes)
+	for _, nome := range nomes {
+		g := grupos[nome]
+		sort.Strings(g.Arquivos)
+		for _, arquivo := range g.Arquivos {
+			xlFile, err := abrirPlanilha(dir + "\\" + arquivo)
+			if err != nil {
+				g.Erros = append(g.Erros, fmt.Sprintf("%s: %s", arquivo, err.Error()))
+				continue
+			}
+			if g.Abas == nil {
+				g.Rascunho = rascunhoMetadado(g, xlFile)
+			}
+			for _, sheet := range xlFile.Sheets {
+				if !contem(g.Abas, sheet.Name) {
+					g.Abas = append(g.Abas, sheet.Name)
+				}
+			}
+		}
+		lista = append(lista, g)
+	}
+	sort.SliceStable(lista, func(i, j int) bool { return len(lista[i].Arquivos) > len(lista[j].Arquivos) })
+	return lista, nil
+}
+
+// abrirPlanilha abre a pasta de trabalho sem deixar um arquivo corrompido
+// derrubar o comando.
+func abrirPlanilha(caminho string) (xlFile *xlsx.File, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return xlsx.OpenFile(caminho)
+}
+
+// rascunhoMetadado monta uma linha de estrutura por coluna de cada aba. O
+// Para vem de um mapeamento existente do agrupador com o mesmo De, se houver.
+func rascunhoMetadado(g *grupoCobertura, xlFile *xlsx.File) []linhaEstrutura {
+	var linhas []linhaEstrutura
+	for _, sheet := range xlFile.Sheets {
+		for _, de := range cabecalhoDaAba(sheet) {
+			if strings.TrimSpace(de) == "" {
+				continue
+			}
+			l := linhaEstrutura{Empresa: g.Empresa, Agrupador: g.Agrupador, NomeArquivo: g.Nome, Sheet: strings.ToLower(sheet.Name), De: strings.ToLower(de)}
+			for _, cab := range est[g.Agrupador] {
+				if cab.De == l.De {
+					l.Para, l.Tipo = cab.Para, cab.Tipo
+					break
+				}
+			}
+			linhas = append(linhas, l)
+		}
+	}
+	return linhas
+}
+
+// imprimirCobertura escreve o relatório; as linhas do rascunho são separadas
+// por tabulação na ordem das colunas do metadado, para colar na planilha.
+func imprimirCobertura(w io.Writer, grupos []*grupoCobertura) {
+	total := 0
+	for _, g := range grupos {
+		total += len(g.Arquivos)
+	}
+	fmt.Fprintf(w, "%d arquivo(s) sem metadado em %d grupo(s).\n", total, len(grupos))
+
+	for _, g := range grupos {
+		fmt.Fprintf(w, "\n== %s (%d arquivo(s)) ==\n", g.Nome, len(g.Arquivos))
+		if g.Chave != "" {
+			fmt.Fprintf(w, "Chave mais parecida: %s (%.0f%%)", g.Chave, g.Score)
+			if g.Empresa != "" || g.Agrupador != "" {
+				fmt.Fprintf(w, " - empresa %s, agrupador %s", g.Empresa, g.Agrupador)
+			}
+			fmt.Fprintln(w)
+		}
+		for _, a := range g.Arquivos {
+			fmt.Fprintln(w, "  "+a)
+		}
+		if len(g.Abas) > 0 {
+			fmt.Fprintf(w, "Abas: %s\n", strings.Join(g.Abas, ", "))
+		}
+		for _, e := range g.Erros {
+			fmt.Fprintln(w, "  erro: "+e)
+		}
+		if len(g.Rascunho) > 0 {
+			fmt.Fprintln(w, "Rascunho (empresa, agrupador, nomearquivo, sheet, caminho, de, para, obrigatorio, tipo):")
+			for _, l := range g.Rascunho {
+				fmt.Fprintln(w, strings.Join([]string{l.Empresa, l.Agrupador, l.NomeArquivo, l.Sheet, l.Caminho, l.De, l.Para, l.Obrigatorio, l.Tipo}, "\t"))
+			}
+		}
+	}
+}
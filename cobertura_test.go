@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gravarNaPasta grava o arquivo em dir, onde Readdir o encontra, e em
+// dir\nome, o caminho que os comandos montam (o mesmo arquivo no Windows).
+func gravarNaPasta(t *testing.T, dir string, nome string, gravar func(caminho string) error) {
+	for _, caminho := range []string{filepath.Join(dir, nome), fmt.Sprintf("%s\\%s", dir, nome)} {
+		if err := gravar(caminho); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// usarPastaSemMetadado monta, com o config.json de usarConvert, uma pasta de
+// planilhas sem metadado: dois envios do relatório da stef com data e cópia
+// no nome, um relatório desconhecido, um arquivo corrompido e um que não é
+// planilha.
+func usarPastaSemMetadado(t *testing.T) string {
+	dir, _ := usarConvert(t, [][]string{{"id"}})
+	pasta := filepath.Join(dir, "semmetadado")
+	os.Mkdir(pasta, 0755)
+	planilhas := map[string][]string{
+		"Chamados Stef 2024-01.xlsx":     {"ID", "Descricao"},
+		"chamados stef 2024-02 (1).xlsx": {"id", "descricao", "extra"},
+		"Vendas_Norte.xlsx":              {"Produto"},
+	}
+	for nome, cabecalho := range planilhas {
+		gravarNaPasta(t, pasta, nome, planilhaDe(cabecalho, make([]string, len(cabecalho))).Save)
+	}
+	gravarNaPasta(t, pasta, "corrompido.xlsx", func(c string) error { return ioutil.WriteFile(c, []byte("não é xlsx"), 0644) })
+	gravarNaPasta(t, pasta, "leiame.txt", func(c string) error { return ioutil.WriteFile(c, []byte("texto"), 0644) })
+	return pasta
+}
+
+func TestNormalizarNomeArquivo(t *testing.T) {
+	casos := map[string]string{
+		"Chamados Stef 2024-01.xlsx":     "chamados stef",
+		"chamados stef 2024-02 (1).xlsx": "chamados stef",
+		"chamados_stef_01-2024.xlsm":     "chamados-stef",
+		"Vendas – Norte.xls":             "vendas-norte",
+		"relatorio 20240115.xlsx":        "relatorio",
+	}
+	for nome, esperado := range casos {
+		if r := normalizarNomeArquivo(nome); r != esperado {
+			t.Errorf("normalizarNomeArquivo(%q) = %q, esperado %q", nome, r, esperado)
+		}
+	}
+}
+
+func TestComandoMetadadosCobertura(t *testing.T) {
+	pasta := usarPastaSemMetadado(t)
+	codigo := 0
+	saida, erro := capturarSaidas(t, func() {
+		codigo = executarComando([]string{"metadados", "cobertura", "-dir", pasta})
+	})
+	if codigo != 0 {
+		t.Fatalf("metadados cobertura = %d:\n%s", codigo, erro)
+	}
+	esperado := strings.Join([]string{
+		"4 arquivo(s) sem metadado em 3 grupo(s).",
+		"",
+		"== chamados stef (2 arquivo(s)) ==",
+		"Chave mais parecida: chamados stef (100%) - empresa stef, agrupador chamados",
+		"  Chamados Stef 2024-01.xlsx",
+		"  chamados stef 2024-02 (1).xlsx",
+		"Abas: plan1",
+		"Rascunho (empresa, agrupador, nomearquivo, sheet, caminho, de, para, obrigatorio, tipo):",
+		"stef\tchamados\tchamados stef\tplan1\t\tid\tId\t\t",
+		"stef\tchamados\tchamados stef\tplan1\t\tdescricao\t\t\t",
+		"",
+		"== corrompido (1 arquivo(s)) ==",
+		"Chave mais parecida: chamados stef (15%)",
+		"  corrompido.xlsx",
+		"  erro: corrompido.xlsx: zip: not a valid zip file",
+		"",
+		"== vendas-norte (1 arquivo(s)) ==",
+		"Chave mais parecida: chamados stef (23%)",
+		"  Vendas_Norte.xlsx",
+		"Abas: plan1",
+		"Rascunho (empresa, agrupador, nomearquivo, sheet, caminho, de, para, obrigatorio, tipo):",
+		"\t\tvendas-norte\tplan1\t\tproduto\t\t\t",
+		"",
+	}, "\n")
+	if saida != esperado {
+		t.Errorf("relatório =\n%s\nesperado\n%s", saida, esperado)
+	}
+}
+
+func TestComandoMetadadosCoberturaGravaORascunho(t *testing.T) {
+	pasta := usarPastaSemMetadado(t)
+	rascunho := filepath.Join(t.TempDir(), "rascunho.json")
+	codigo := 0
+	_, erro := capturarSaidas(t, func() {
+		codigo = executarComando([]string{"metadados", "cobertura", "-dir", pasta, "-minimo", "101", "-saida", rascunho})
+	})
+	if codigo != 0 {
+		t.Fatalf("metadados cobertura = %d:\n%s", codigo, erro)
+	}
+	doc, err := lerDocumentoMetadado(rascunho)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var linhas []string
+	for _, l := range doc.Estrutura {
+		linhas = append(linhas, strings.Join([]string{l.Empresa, l.Agrupador, l.NomeArquivo, l.Sheet, l.De, l.Para}, "/"))
+	}
+	// Abaixo do mínimo, empresa e agrupador ficam para o usuário preencher.
+	esperado := []string{"//chamados stef/plan1/id/", "//chamados stef/plan1/descricao/", "//vendas-norte/plan1/produto/"}
+	if strings.Join(linhas, "\n") != strings.Join(esperado, "\n") {
+		t.Errorf("rascunho gravado:\n%s\nesperado\n%s", strings.Join(linhas, "\n"), strings.Join(esperado, "\n"))
+	}
+}
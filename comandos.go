@@ -0,0 +1,564 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kr/fs"
+	"github.com/tealeg/xlsx"
+)
+
+// comando é um subcomando da linha de comando. Os flags globais (-config,
+// -profile, -set, ...) vêm antes do nome do comando.
+type comando struct {
+	Nome     string
+	Args     string
+	Resumo   string
+	Executar func(fs *flag.FlagSet, args []string) int
+	Flags    func(fs *flag.FlagSet)
+}
+
+var comandos []comando
+
+func init() {
+	comandos = []comando{
+		{Nome: "run", Resumo: "processa uma vez os arquivos de PlanilhasAImportar e termina", Executar: comandoRun},
+		{Nome: "once", Resumo: "o mesmo que run", Executar: comandoRun},
+		{Nome: "watch", Resumo: "processa continuamente, a cada tempodeexecucao", Executar: comandoWatch, Flags: flagsWatch},
+		{Nome: "convert", Args: "<arquivo>", Resumo: "converte uma planilha e imprime o CSV na saída padrão, sem mover arquivos", Executar: comandoConvert, Flags: flagsConvert},
+		{Nome: "validate", Resumo: "valida a configuração e o metadado", Executar: comandoValidate},
+		{Nome: "metadados", Args: "<subcomando>", Resumo: "ferramentas do metadado (dump, converter, diff, cobertura, sugerir)", Executar: comandoMetadados},
+		{Nome: "status", Resumo: "mostra o histórico de processamento (Log\\ledger.json)", Executar: comandoStatus, Flags: flagsStatus},
+		{Nome: "replay", Resumo: "devolve as planilhas com erro para PlanilhasAImportar e processa", Executar: comandoReplay, Flags: flagsReplay},
+	}
+	subcomandosMetadados = []comando{
+		{Nome: "dump", Resumo: "gera os arquivos Dicionario*.json a partir do metadado", Executar: comandoMetadadosDump, Flags: flagsMetadadosDump},
+		{Nome: "converter", Args: "<entrada> <saida>", Resumo: "converte o metadado entre xlsx, json e yaml (pela extensão)", Executar: comandoMetadadosConverter},
+		{Nome: "diff", Args: "[<antigo> [<novo>]]", Resumo: "compara dois metadados (caminho, versão, \"ledger\" ou \"atual\"; padrão ledger e atual) e lista as cargas afetadas", Executar: comandoMetadadosDiff},
+		{Nome: "cobertura", Resumo: "agrupa as planilhas sem metadado e sugere a chave mais parecida e um rascunho das linhas", Executar: comandoMetadadosCobertura, Flags: flagsMetadadosCobertura},
+		{Nome: "sugerir", Args: "<amostra>", Resumo: "gera linhas de metadado para uma planilha de exemplo a partir dos mapeamentos do agrupador", Executar: comandoMetadadosSugerir, Flags: flagsMetadadosSugerir},
+	}
+}
+
+// executarComando despacha args (flag.Args()) e devolve o código de saída.
+// Sem comando mantém o comportamento antigo: execucaocontinua decide entre
+// run e watch.
+func executarComando(args []string) int {
+	if len(args) == 0 {
+		carregarConfiguracao()
+		processar(config.Configuracao.ExecucaoContinua)
+		return 0
+	}
+	if args[0] == "help" {
+		flag.Usage()
+		return 0
+	}
+	return despachar(comandos, "", args, flag.Usage)
+}
+
+// despachar executa o comando de lista nomeado em args[0], com os flags
+// próprios dele. prefixo é o caminho do comando pai, para as mensagens de uso.
+func despachar(lista []comando, prefixo string, args []string, uso func()) int {
+	for _, c := range lista {
+		if c.Nome != args[0] {
+			continue
+		}
+		c := c
+		fs := flag.NewFlagSet(prefixo+c.Nome, flag.ContinueOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Uso: %s [opções globais] %s%s [opções] %s\n\n%s.\n", os.Args[0], prefixo, c.Nome, c.Args, c.Resumo)
+			fmt.Fprintln(os.Stderr, "\nOpções:")
+			fs.PrintDefaults()
+		}
+		if c.Flags != nil {
+			c.Flags(fs)
+		}
+		if err := fs.Parse(args[1:]); err == flag.ErrHelp {
+			return 0
+		} else if err != nil {
+			return 2
+		}
+		return c.Executar(fs, fs.Args())
+	}
+	fmt.Fprintf(os.Stderr, "comando desconhecido: %s%s\n\n", prefixo, args[0])
+	uso()
+	return 2
+}
+
+func usoComandos(w io.Writer) {
+	fmt.Fprintf(w, "Uso: %s [opções globais] [comando] [opções do comando]\n\nComandos:\n", os.Args[0])
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range comandos {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.Nome, c.Args, c.Resumo)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nSem comando, execucaocontinua decide entre run e watch.\nUse \"%s <comando> -help\" para as opções de cada comando.\n\nOpções globais:\n", os.Args[0])
+}
+
+// processar carrega o metadado, inicia os workers, varre PlanilhasAImportar e
+// espera as notificações ainda na fila.
+// A configuração já deve estar carregada.
+func processar(continuo bool) {
+	config.Configuracao.ExecucaoContinua = continuo
+	tasks = make(chan tarefa, 50)
+
+	configurarNotificadores()
+	criarFilaProcessamento(4)
+
+	carregarMetadado()
+	carregarArquivoNaFilaWalk()
+	encerrarNotificadores()
+}
+
+func comandoRun(fs *flag.FlagSet, args []string) int {
+	carregarConfiguracao()
+	processar(false)
+	return 0
+}
+
+var watchIntervalo *time.Duration
+
+func flagsWatch(fs *flag.FlagSet) {
+	watchIntervalo = fs.Duration("intervalo", 0, "intervalo entre as varreduras (padrão: tempodeexecucao da configuração)")
+}
+
+func comandoWatch(fs *flag.FlagSet, args []string) int {
+	carregarConfiguracao()
+	if *watchIntervalo > 0 {
+		config.Configuracao.TempoDeExecucao.Duration = *watchIntervalo
+	}
+	processar(true)
+	return 0
+}
+
+var (
+	convertSheet  *string
+	convertDryRun *bool
+	convertLinhas *int
+)
+
+func flagsConvert(fs *flag.FlagSet) {
+	convertSheet = fs.String("sheet", "", "converte só esta aba (padrão: todas as abas mapeadas)")
+	convertDryRun = fs.Bool("dry-run", false, "mostra o mapeamento do cabeçalho e as primeiras linhas em vez do CSV")
+	convertLinhas = fs.Int("linhas", 5, "quantidade de linhas mostradas com -dry-run")
+}
+
+// comandoConvert interpreta uma planilha com o metadado atual e grava o CSV na
+// saída padrão. Problemas vão para a saída de erro; nada é movido nem gravado
+// em disco.
+func comandoConvert(fs *flag.FlagSet, args []string) int {
+	if len(args) != 1 {
+		fs.Usage()
+		return 2
+	}
+	carregarConfiguracaoSomenteLeitura()
+	carregarMetadadoSomenteLeitura()
+
+	nome := filepath.Base(strings.Replace(args[0], "\\", "/", -1))
+	arq, ambiguos := buscarNomeArquivo(strings.ToLower(nome))
+	if ambiguos != nil {
+		fmt.Fprintln(os.Stderr, "O nome do arquivo corresponde a mais de uma entrada do metadado:")
+		for _, c := range descreverCandidatos(ambiguos) {
+			fmt.Fprintln(os.Stderr, "  "+c)
+		}
+		return 1
+	}
+	if motivo, ok := motivoRetencao(arq[2], ""); ok {
+		fmt.Fprintln(os.Stderr, motivo)
+		return 1
+	}
+	xlFile, err := xlsx.OpenFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Erro ao abrir o arquivo [%s]. %s\n", args[0], err.Error())
+		return 1
+	}
+	if *convertDryRun {
+		if !imprimirPrevia(os.Stdout, arq, xlFile, *convertSheet, *convertLinhas) {
+			return 1
+		}
+		return 0
+	}
+
+	var erros bytes.Buffer
+	logger := log.New(&erros, "", 0)
+	relatorio := novoRelatorioErro(nome)
+	convertidas := 0
+	for _, sheet := range xlFile.Sheets {
+		if *convertSheet != "" && !strings.EqualFold(sheet.Name, *convertSheet) {
+			continue
+		}
+		emp, ok := dic[arq[2]+"|"+strings.ToLower(sheet.Name)]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "[plan: %s] sem metadado para %s, ignorada.\n", sheet.Name, arq[2])
+			continue
+		}
+		plan := carregaPlan(logger, relatorio, arq, sheet)
+		if len(plan) == 0 {
+			continue
+		}
+		if err := escreverCSV(os.Stdout, plan, emp[1], emp[0], sheet.Name+"_"); err != nil {
+			fmt.Fprintln(os.Stderr, "Erro ao gravar o CSV - ", err.Error())
+			return 1
+		}
+		convertidas++
+	}
+
+	for _, m := range relatorio.motivos() {
+		fmt.Fprintln(os.Stderr, m)
+	}
+	os.Stderr.Write(erros.Bytes())
+	if erros.Len() > 0 {
+		return 1
+	}
+	if convertidas == 0 {
+		fmt.Fprintf(os.Stderr, "Nenhuma aba de %s corresponde ao metadado.\n", nome)
+		return 1
+	}
+	return 0
+}
+
+func comandoValidate(fs *flag.FlagSet, args []string) int {
+	if !verificarConfiguracao(opcoesDaLinhaDeComando()) {
+		return 1
+	}
+	carregarConfiguracao()
+	ok := true
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Println("Metadado inválido:", r)
+				ok = false
+			}
+		}()
+		ok = len(carregarMetadado()) == 0
+	}()
+	if dic == nil {
+		return 1
+	}
+	fmt.Printf("\nMetadado: %d fonte(s), %d arquivo(s), %d aba(s), %d agrupador(es), %d cadastro(s) de e-mail, %d chave(s) retida(s).\n", len(fontesMetadado()), len(dicArquivo), len(dic), len(est), len(email), len(chavesRetidas))
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+var subcomandosMetadados []comando
+
+func comandoMetadados(fs *flag.FlagSet, args []string) int {
+	if len(args) == 0 || args[0] == "help" {
+		fmt.Fprintf(os.Stderr, "Uso: %s [opções globais] metadados <subcomando> [opções]\n\nSubcomandos:\n", os.Args[0])
+		tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+		for _, c := range subcomandosMetadados {
+			fmt.Fprintf(tw, "  %s %s\t%s\n", c.Nome, c.Args, c.Resumo)
+		}
+		tw.Flush()
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+	return despachar(subcomandosMetadados, "metadados ", args, func() { comandoMetadados(fs, nil) })
+}
+
+var metadadosDir *string
+
+func flagsMetadadosDump(fs *flag.FlagSet) {
+	metadadosDir = fs.String("dir", "", "diretório de destino (padrão: metadados.diretorio)")
+}
+
+func comandoMetadadosDump(fs *flag.FlagSet, args []string) int {
+	carregarConfiguracao()
+	carregarMetadado()
+	dir := *metadadosDir
+	if dir == "" {
+		dir = config.Configuracao.Metadados.Diretorio
+	}
+	if err := exportarDicionario(dir); err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao gerar os dicionários - ", err.Error())
+		return 1
+	}
+	return 0
+}
+
+func comandoMetadadosConverter(fs *flag.FlagSet, args []string) int {
+	if len(args) != 2 {
+		fs.Usage()
+		return 2
+	}
+	if err := converterMetadado(args[0], args[1]); err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao converter o metadado - ", err.Error())
+		return 1
+	}
+	fmt.Println(args[1])
+	return 0
+}
+
+var (
+	coberturaDir    *string
+	coberturaMinimo *float64
+	coberturaSaida  *string
+)
+
+func flagsMetadadosCobertura(fs *flag.FlagSet) {
+	coberturaDir = fs.String("dir", "", "diretório analisado (padrão: diretorios.planilhassemmetadado)")
+	coberturaMinimo = fs.Float64("minimo", 60, "similaridade mínima (%) para preencher empresa e agrupador no rascunho")
+	coberturaSaida = fs.String("saida", "", "grava também o rascunho em um metadado xlsx, json ou yaml")
+}
+
+func comandoMetadadosCobertura(fs *flag.FlagSet, args []string) int {
+	carregarConfiguracao()
+	carregarMetadado()
+	dir := *coberturaDir
+	if dir == "" {
+		dir = config.Configuracao.Diretorios.PlanilhasSemMetaDado
+	}
+	grupos, err := coberturaMetadado(dir, *coberturaMinimo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao ler as planilhas sem metadado - ", err.Error())
+		return 1
+	}
+	imprimirCobertura(os.Stdout, grupos)
+
+	if *coberturaSaida != "" {
+		doc := &documentoMetadado{Versao: versaoMetadado}
+		for _, g := range grupos {
+			doc.Estrutura = append(doc.Estrutura, g.Rascunho...)
+		}
+		if err := gravarDocumentoMetadado(doc, *coberturaSaida); err != nil {
+			fmt.Fprintln(os.Stderr, "Erro ao gravar o rascunho - ", err.Error())
+			return 1
+		}
+		fmt.Println("\nRascunho gravado em", *coberturaSaida)
+	}
+	return 0
+}
+
+var (
+	sugerirAgrupador   *string
+	sugerirEmpresa     *string
+	sugerirNomeArquivo *string
+	sugerirMinimo      *float64
+	sugerirSaida       *string
+)
+
+func flagsMetadadosSugerir(fs *flag.FlagSet) {
+	sugerirAgrupador = fs.String("agrupador", "", "agrupador de destino (obrigatório)")
+	sugerirEmpresa = fs.String("empresa", "", "empresa das linhas geradas")
+	sugerirNomeArquivo = fs.String("nomearquivo", "", "nomearquivo das linhas geradas (padrão: nome normalizado da amostra)")
+	sugerirMinimo = fs.Float64("minimo", 50, "confiança mínima (%) para preencher o Para")
+	sugerirSaida = fs.String("saida", "", "fragmento xlsx gerado (padrão: <amostra>_metadado.xlsx)")
+}
+
+func comandoMetadadosSugerir(fs *flag.FlagSet, args []string) int {
+	if len(args) != 1 || *sugerirAgrupador == "" {
+		fs.Usage()
+		return 2
+	}
+	carregarConfiguracao()
+	carregarMetadado()
+	agrupador := strings.ToLower(*sugerirAgrupador)
+	if _, ok := est[agrupador]; !ok {
+		var agrupadores []string
+		for k := range est {
+			agrupadores = append(agrupadores, k)
+		}
+		sort.Strings(agrupadores)
+		fmt.Fprintf(os.Stderr, "Agrupador %s sem mapeamentos no metadado. Agrupadores: %s\n", agrupador, strings.Join(agrupadores, ", "))
+		return 1
+	}
+	xlFile, err := abrirPlanilha(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao abrir a amostra - ", err.Error())
+		return 1
+	}
+
+	nome := *sugerirNomeArquivo
+	if nome == "" {
+		nome = normalizarNomeArquivo(args[0][strings.LastIndexAny(args[0], "\\/")+1:])
+	}
+	sugestoes := sugerirMapeamento(xlFile, agrupador, strings.ToLower(*sugerirEmpresa), strings.ToLower(nome), *sugerirMinimo)
+	imprimirSugestoes(os.Stdout, sugestoes)
+
+	saida := *sugerirSaida
+	if saida == "" {
+		saida = nomeSemExtensao(args[0]) + "_metadado.xlsx"
+	}
+	if err := gravarSugestoes(sugestoes, saida); err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao gravar o fragmento - ", err.Error())
+		return 1
+	}
+	fmt.Println("Fragmento gravado em", saida)
+	return 0
+}
+
+var (
+	statusQuantidade *int
+	statusEmpresa    *string
+	statusSituacao   *string
+)
+
+func flagsStatus(fs *flag.FlagSet) {
+	statusQuantidade = fs.Int("n", 20, "quantidade de registros mais recentes (0 = todos)")
+	statusEmpresa = fs.String("empresa", "", "filtra pela empresa")
+	statusSituacao = fs.String("status", "", "filtra pela situação: sucesso, falha ou semmetadado")
+}
+
+func comandoStatus(fs *flag.FlagSet, args []string) int {
+	carregarConfiguracao()
+	registros, err := lerLedger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao ler o ledger - ", err.Error())
+		return 1
+	}
+
+	var filtrados []registroLedger
+	for _, r := range registros {
+		if *statusEmpresa != "" && !strings.EqualFold(r.Empresa, *statusEmpresa) {
+			continue
+		}
+		if *statusSituacao != "" && !strings.EqualFold(r.Status, *statusSituacao) {
+			continue
+		}
+		filtrados = append(filtrados, r)
+	}
+	if *statusQuantidade > 0 && len(filtrados) > *statusQuantidade {
+		filtrados = filtrados[len(filtrados)-*statusQuantidade:]
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATA\tSTATUS\tEMPRESA\tARQUIVO\tDETALHE")
+	for _, r := range filtrados {
+		detalhe := ""
+		if len(r.CSVs) > 0 {
+			detalhe = fmt.Sprintf("%d CSV(s)", len(r.CSVs))
+		}
+		if len(r.Motivos) > 0 {
+			detalhe = r.Motivos[0]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Data.Format("2006-01-02 15:04:05"), r.Status, r.Empresa, caminhoNaPasta(r.Pasta, r.Arquivo), detalhe)
+	}
+	tw.Flush()
+	return 0
+}
+
+var (
+	replayArquivo     *string
+	replaySemMetadado *bool
+	replayAmbiguos    *bool
+	replayRetidas     *bool
+	replayQuarentena  *bool
+)
+
+func flagsReplay(fs *flag.FlagSet) {
+	replayArquivo = fs.String("arquivo", "*", "padrão (glob) dos arquivos a reprocessar")
+	replaySemMetadado = fs.Bool("semmetadado", false, "reprocessa PlanilhasSemMetadado em vez de PlanilhasComErro")
+	replayAmbiguos = fs.Bool("ambiguos", false, "reprocessa PlanilhasAmbiguas em vez de PlanilhasComErro")
+	replayRetidas = fs.Bool("retidas", false, "reprocessa PlanilhasRetidas em vez de PlanilhasComErro")
+	replayQuarentena = fs.Bool("quarentena", false, "reprocessa PlanilhasQuarentena em vez de PlanilhasComErro")
+}
+
+// comandoReplay devolve as planilhas para PlanilhasAImportar e faz uma
+// passagem. Logs e relatórios de erro ficam onde estão.
+func comandoReplay(fs *flag.FlagSet, args []string) int {
+	carregarConfiguracao()
+	origem := config.Configuracao.Diretorios.PlanilhasComErro
+	if *replaySemMetadado {
+		origem = config.Configuracao.Diretorios.PlanilhasSemMetaDado
+	}
+	if *replayAmbiguos {
+		origem = config.Configuracao.Diretorios.PlanilhasAmbiguas
+	}
+	if *replayRetidas {
+		origem = config.Configuracao.Diretorios.PlanilhasRetidas
+	}
+	if *replayQuarentena {
+		origem = config.Configuracao.Diretorios.PlanilhasQuarentena
+	}
+
+	arquivos, err := planilhasParaReprocessar(origem, *replayArquivo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao listar", origem, "-", err.Error())
+		return 1
+	}
+	for _, nome := range arquivos {
+		pasta, _ := separarPasta(nome)
+		criarPasta(config.Configuracao.Diretorios.PlanilhasAImportar, pasta)
+		erro := os.Rename(fmt.Sprintf("%s\\%s", origem, nome), fmt.Sprintf("%s\\%s", config.Configuracao.Diretorios.PlanilhasAImportar, nome))
+		if erro != nil {
+			fmt.Println(erro.Error())
+			continue
+		}
+		fmt.Println("replay:", nome)
+	}
+	if len(arquivos) == 0 {
+		fmt.Println("Nenhuma planilha para reprocessar.")
+		return 0
+	}
+	processar(false)
+	return 0
+}
+
+// planilhasParaReprocessar lista as planilhas de dir e subpastas cujo nome
+// casa com padrao, com o caminho relativo a dir.
+func planilhasParaReprocessar(dir string, padrao string) ([]string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	var nomes []string
+	walker := fs.Walk(dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+		f := walker.Stat()
+		nome := f.Name()
+		if !f.Mode().IsRegular() || !strings.Contains(strings.ToLower(nome), ".xls") || strings.HasSuffix(strings.ToLower(nome), "_erro.xlsx") {
+			continue
+		}
+		if ok, _ := filepath.Match(strings.ToLower(padrao), strings.ToLower(nome)); ok {
+			rel, err := filepath.Rel(filepath.Clean(dir), walker.Path())
+			if err != nil {
+				return nil, err
+			}
+			nomes = append(nomes, rel)
+		}
+	}
+	return nomes, nil
+}
+
+func comandoMetadadosDiff(fs *flag.FlagSet, args []string) int {
+	if len(args) > 2 {
+		fs.Usage()
+		return 2
+	}
+	origens := []string{"ledger", "atual"}
+	copy(origens, args)
+	carregarConfiguracao()
+
+	antigo, rotuloAntigo, err := carregarRetrato(origens[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao carregar", origens[0], "-", err.Error())
+		return 1
+	}
+	novo, rotuloNovo, err := carregarRetrato(origens[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao carregar", origens[1], "-", err.Error())
+		return 1
+	}
+	impacto, err := impactoMetadado(novo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao ler o ledger - ", err.Error())
+		return 1
+	}
+	imprimirDiffMetadado(os.Stdout, rotuloAntigo, rotuloNovo, diferencasMetadado(antigo, novo), impacto)
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tealeg/xlsx"
+)
+
+// capturarSaidas executa f com a saída padrão e a de erro redirecionadas e
+// devolve o que foi escrito em cada uma.
+func capturarSaidas(t *testing.T, f func()) (string, string) {
+	ler := func(destino **os.File) (func() string, func()) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := *destino
+		*destino = w
+		texto := make(chan string)
+		go func() {
+			dat, _ := ioutil.ReadAll(r)
+			texto <- string(dat)
+		}()
+		return func() string { w.Close(); return <-texto }, func() { *destino = original }
+	}
+	saida, restaurarSaida := ler(&os.Stdout)
+	erro, restaurarErro := ler(&os.Stderr)
+	func() {
+		defer restaurarSaida()
+		defer restaurarErro()
+		f()
+	}()
+	return saida(), erro()
+}
+
+// usarConvert prepara um config.json com o metadado da stef (coluna id
+// obrigatória) e uma planilha de exemplo; devolve o diretório de trabalho e o caminho da planilha.
+func usarConvert(t *testing.T, linhas [][]string) (string, string) {
+	dir := t.TempDir()
+	meta := filepath.Join(dir, "meta")
+	os.Mkdir(meta, 0755)
+	ioutil.WriteFile(filepath.Join(meta, "stef.json"), []byte(strings.Replace(metadadoStef, `"para": "Id"`, `"para": "Id", "obrigatorio": "s"`, 1)), 0644)
+	// Uma fonte quebrada faz o carregamento reclamar; a reclamação não pode
+	// ir para a saída padrão junto com o CSV.
+	ioutil.WriteFile(filepath.Join(meta, "quebrado.json"), []byte(`{"versao": 1, "estrutura": [`), 0644)
+	cfg := fmt.Sprintf(`{"versao": 2, "configuracao": {"diretorios": {"log": %q}, "metadados": {"diretorio": %q, "nomearquivo": "*.json"}}}`, filepath.Join(dir, "log"), meta)
+	ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(cfg), 0644)
+
+	f := xlsx.NewFile()
+	sheet, _ := f.AddSheet("plan1")
+	for _, l := range linhas {
+		row := sheet.AddRow()
+		for _, v := range l {
+			row.AddCell().SetString(v)
+		}
+	}
+	planilha := filepath.Join(dir, "chamados stef.xlsx")
+	if err := f.Save(planilha); err != nil {
+		t.Fatal(err)
+	}
+
+	anterior := *configFile
+	*configFile = filepath.Join(dir, "config.json")
+	t.Cleanup(func() {
+		*configFile = anterior
+		if convertDryRun != nil {
+			*convertDryRun, *convertSheet = false, ""
+		}
+		config = Config{}
+		dic, est, email, dicArquivo, padroesArquivo, chavesRetidas, retencoesSemIndice = nil, nil, nil, nil, nil, nil, nil
+	})
+	return dir, planilha
+}
+
+// arquivosEm lista os arquivos de dir, recursivamente.
+func arquivosEm(t *testing.T, dir string) []string {
+	var nomes []string
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			nomes = append(nomes, p)
+		}
+		return nil
+	})
+	sort.Strings(nomes)
+	return nomes
+}
+
+func TestConvertImprimeSoOCSV(t *testing.T) {
+	dir, planilha := usarConvert(t, [][]string{{"id", "extra"}, {"1", "x"}, {"2", "y"}})
+	antes := arquivosEm(t, dir)
+
+	codigo := 0
+	saida, erro := capturarSaidas(t, func() { codigo = executarComando([]string{"convert", planilha}) })
+	if codigo != 0 {
+		t.Fatalf("convert = %d, saída de erro:\n%s", codigo, erro)
+	}
+	if esperado := "Id,idempresa\n1,stef\n2,stef\n"; saida != esperado {
+		t.Errorf("saída padrão = %q, esperado só o CSV %q", saida, esperado)
+	}
+	if depois := arquivosEm(t, dir); strings.Join(depois, "\n") != strings.Join(antes, "\n") {
+		t.Errorf("convert gravou em disco:\n%s", strings.Join(depois, "\n"))
+	}
+}
+
+func TestConvertDryRunNaoGrava(t *testing.T) {
+	dir, planilha := usarConvert(t, [][]string{{"id"}, {"1"}})
+	antes := arquivosEm(t, dir)
+	codigo := 0
+	saida, _ := capturarSaidas(t, func() { codigo = executarComando([]string{"convert", "-dry-run", planilha}) })
+	if codigo != 0 || !strings.Contains(saida, "Id") {
+		t.Errorf("convert -dry-run = %d:\n%s", codigo, saida)
+	}
+	if depois := arquivosEm(t, dir); strings.Join(depois, "\n") != strings.Join(antes, "\n") {
+		t.Errorf("convert -dry-run gravou em disco:\n%s", strings.Join(depois, "\n"))
+	}
+}
+
+func TestConvertExplicaAFalha(t *testing.T) {
+	_, planilha := usarConvert(t, [][]string{{"titulo"}, {"impressora"}})
+	codigo := 0
+	saida, erro := capturarSaidas(t, func() { codigo = executarComando([]string{"convert", planilha}) })
+	if codigo != 1 || saida != "" {
+		t.Errorf("convert = %d, saída padrão %q", codigo, saida)
+	}
+	if !strings.Contains(erro, "[id] é obrigatório") {
+		t.Errorf("saída de erro sem o motivo:\n%s", erro)
+	}
+}
+
+func TestUsoDosComandos(t *testing.T) {
+	var chamadas [][]string
+	for _, c := range comandos {
+		chamadas = append(chamadas, []string{c.Nome, "-help"})
+	}
+	for _, c := range subcomandosMetadados {
+		chamadas = append(chamadas, []string{"metadados", c.Nome, "-help"})
+	}
+	chamadas = append(chamadas, []string{"help"}, []string{"metadados", "help"})
+
+	for _, args := range chamadas {
+		codigo := -1
+		saida, erro := capturarSaidas(t, func() { codigo = executarComando(args) })
+		if codigo != 0 || saida != "" || !strings.Contains(erro, "Uso:") {
+			t.Errorf("%s: código %d, saída %q, erro %q", strings.Join(args, " "), codigo, saida, erro)
+		}
+	}
+
+	for _, args := range [][]string{{"desconhecido"}, {"convert"}, {"metadados"}, {"status", "-opcao-invalida"}} {
+		codigo := -1
+		_, erro := capturarSaidas(t, func() { codigo = executarComando(args) })
+		if codigo != 2 || erro == "" {
+			t.Errorf("%s: código %d, esperado 2 com o uso na saída de erro", strings.Join(args, " "), codigo)
+		}
+	}
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// versaoConfig é a versão atual do config.json. Arquivos sem "versao" são da
+// versão 1 (flags "S"/"N", tempos em segundos) e são migrados ao carregar.
+const versaoConfig = 2
+
+type Config struct {
+	Versao       int          `json:"versao"`
+	Perfil       string       `json:"perfil,omitempty"`
+	Configuracao configuracao `json:"configuracao"`
+}
+type configuracao struct {
+	CriarDiretorio   bool                  `json:"criardiretorio"`
+	ExecucaoContinua bool                  `json:"execucaocontinua"`
+	TempoDeExecucao  duracao               `json:"tempodeexecucao"`
+	EnviarEmail      bool                  `json:"enviaremail"`
+	Diretorios       diretorios            `json:"diretorios"`
+	Metadados        metadados             `json:"metadados"`
+	Email            emailconfig           `json:"email"`
+	Webhook          webhookconfig         `json:"webhook"`
+	Resumo           resumoconfig          `json:"resumo"`
+	Reprocessamento  reprocessamentoconfig `json:"reprocessamento"`
+	Entrada          entradaconfig         `json:"entrada"`
+	Delta            deltaconfig           `json:"delta"`
+	Memoria          memoriaconfig         `json:"memoria"`
+	CSV              dialetoCSV            `json:"csv"`
+	Saidas           map[string]dialetoCSV `json:"saidas"`
+	// Duplicidade é a política de chaves repetidas por agrupador.
+	Duplicidade map[string]politicaDuplicidade `json:"duplicidade"`
+}
+
+type diretorios struct {
+	CSVGerados           string `json:"csvgerados"`
+	PlanilhasImportadas  string `json:"planilhasimportadas"`
+	PlanilhasAImportar   string `json:"planilhasaimportar"`
+	PlanilhasComErro     string `json:"planilhascomerro"`
+	PlanilhasSemMetaDado string `json:"planilhassemmetadado"`
+	PlanilhasAmbiguas    string `json:"planilhasambiguas"`
+	PlanilhasRetidas     string `json:"planilhasretidas"`
+	PlanilhasQuarentena  string `json:"planilhasquarentena"`
+	PlanilhasDuplicadas  string `json:"planilhasduplicadas"`
+	Extracao             string `json:"extracao"`
+	Indices              string `json:"indices"`
+	Log                  string `json:"log"`
+	// CSVParticao é um subdiretório de CSVGerados montado com os valores
+	// capturados do nome do arquivo, ex.: "{empresa}\\{yyyy}-{mm}". Vazio não particiona.
+	CSVParticao string `json:"csvparticao"`
+}
+
+type metadados struct {
+	Diretorio   string `json:"diretorio"`
+	NomeArquivo string `json:"nomearquivo"`
+}
+
+type emailconfig struct {
+	NomeRemetente string  `json:"nomeremetente"`
+	Titulo        string  `json:"titulo"`
+	Mensagem      string  `json:"mensagem"`
+	Servidor      string  `json:"servidor"`
+	Porta         int     `json:"porta"`
+	ContaEmail    string  `json:"contaemail"`
+	Senha         string  `json:"senha" segredo:"sim"`
+	CertificadoCA string  `json:"certificadoca"`
+	Tentativas    int     `json:"tentativas"`
+	Intervalo     duracao `json:"intervalo"`
+}
+
+// duracao aceita no JSON o formato do time.ParseDuration ("30s", "5m", "24h").
+type duracao struct {
+	time.Duration
+}
+
+func (d *duracao) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duração inválida %s, use por exemplo \"30s\", \"5m\" ou \"24h\"", string(b))
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("duração inválida %q, use por exemplo \"30s\", \"5m\" ou \"24h\"", s)
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d duracao) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// tamanho aceita no JSON um número de bytes com KB, MB ou GB ("512MB", "1GB").
+type tamanho struct {
+	Bytes int64
+}
+
+var unidadesTamanho = []struct {
+	sufixo string
+	bytes  int64
+}{{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"b", 1}}
+
+func (t *tamanho) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("tamanho inválido %s, use por exemplo \"512MB\" ou \"1GB\"", string(b))
+	}
+	s = strings.ToLower(strings.TrimSpace(s))
+	unidade := int64(1)
+	for _, u := range unidadesTamanho {
+		if strings.HasSuffix(s, u.sufixo) {
+			s, unidade = strings.TrimSpace(strings.TrimSuffix(s, u.sufixo)), u.bytes
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("tamanho inválido %q, use por exemplo \"512MB\" ou \"1GB\"", string(b))
+	}
+	t.Bytes = int64(v * float64(unidade))
+	return nil
+}
+
+func (t tamanho) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t tamanho) String() string {
+	for _, u := range unidadesTamanho {
+		if t.Bytes >= u.bytes && t.Bytes%u.bytes == 0 {
+			return fmt.Sprintf("%d%s", t.Bytes/u.bytes, strings.ToUpper(u.sufixo))
+		}
+	}
+	return "0"
+}
+
+func configPadrao() Config {
+	var c Config
+	c.Versao = versaoConfig
+	c.Configuracao.TempoDeExecucao = duracao{time.Minute}
+	c.Configuracao.Diretorios = diretorios{
+		CSVGerados:           ".\\CSVGerados",
+		PlanilhasImportadas:  ".\\PlanilhasImportadas",
+		PlanilhasAImportar:   ".\\PlanilhasAImportar",
+		PlanilhasComErro:     ".\\PlanilhasComErro",
+		PlanilhasSemMetaDado: ".\\PlanilhasSemMetaDado",
+		PlanilhasAmbiguas:    ".\\PlanilhasAmbiguas",
+		PlanilhasRetidas:     ".\\PlanilhasRetidas",
+		PlanilhasQuarentena:  ".\\PlanilhasQuarentena",
+		PlanilhasDuplicadas:  ".\\PlanilhasDuplicadas",
+		Extracao:             ".\\Extracao",
+		Indices:              ".\\Indices",
+		Log:                  ".\\Log",
+	}
+	c.Configuracao.Metadados.Diretorio = ".\\MetaDados"
+	c.Configuracao.Email = emailconfig{
+		Titulo:     "QLIK - Planilha com erro",
+		Mensagem:   "Existe um erro na estrutura da planilha. <br> Verifique o arquivo de log anexo.",
+		Porta:      587,
+		Tentativas: 3,
+		Intervalo:  duracao{2 * time.Second},
+	}
+	c.Configuracao.Webhook.Tentativas = 3
+	c.Configuracao.Webhook.Intervalo = duracao{2 * time.Second}
+	c.Configuracao.Resumo.Janela = duracao{24 * time.Hour}
+	c.Configuracao.Reprocessamento = reprocessamentoconfig{Ativo: true, Tentativas: 3, Espera: duracao{5 * time.Minute}}
+	c.Configuracao.Entrada.Estabilidade = duracao{5 * time.Second}
+	c.Configuracao.Entrada.Duplicados = true
+	c.Configuracao.Entrada.LimiteMembro = tamanho{512 << 20}
+	c.Configuracao.Entrada.LimitePacote = tamanho{2 << 30}
+	c.Configuracao.Memoria = memoriaconfig{Orcamento: tamanho{1 << 30}, ArquivoGrande: tamanho{10 << 20}}
+	return c
+}
+
+// carregarConfiguracao lê a configuração e, com criardiretorio, cria os
+// diretórios de trabalho.
+func carregarConfiguracao() {
+	carregarConfiguracaoSomenteLeitura()
+	if config.Configuracao.CriarDiretorio {
+		os.MkdirAll(config.Configuracao.Diretorios.CSVGerados, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.Log, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasAImportar, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasComErro, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasImportadas, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasSemMetaDado, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasAmbiguas, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasRetidas, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasQuarentena, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasDuplicadas, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.Extracao, os.ModeType)
+		os.MkdirAll(config.Configuracao.Diretorios.Indices, os.ModeType)
+	}
+}
+
+// carregarConfiguracaoSomenteLeitura lê a configuração e resolve os segredos
+// sem criar nada em disco. As mensagens vão para a saída de erro, que a saída
+// padrão de convert é o CSV.
+func carregarConfiguracaoSomenteLeitura() {
+	var err error
+	config, _, err = lerConfiguracao(opcoesDaLinhaDeComando())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao carregar a configuração.")
+		panic(err.Error())
+	}
+	err = resolverSegredos(&config, *secretKey, secoesInativas(&config)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao resolver os segredos da configuração.")
+		panic(err.Error())
+	}
+}
+
+func opcoesDaLinhaDeComando() opcoesConfig {
+	perfil := *profile
+	if perfil == "" {
+		perfil = os.Getenv("ROBOQLIK_PERFIL")
+	}
+	return opcoesConfig{Arquivo: *configFile, Perfil: perfil, Sets: sets}
+}
+
+// lerConfiguracao carrega, migra, sobrepõe as camadas (veja sobreposicao.go) e
+// valida, sem resolver os segredos. Devolve também a origem de cada valor.
+func lerConfiguracao(op opcoesConfig) (Config, map[string]string, error) {
+	c := configPadrao()
+	origens := make(map[string]string)
+	arquivo := op.Arquivo
+
+	dat, err := ioutil.ReadFile(arquivo)
+	if err != nil {
+		return c, origens, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(dat, &m); err != nil {
+		return c, origens, fmt.Errorf("%s: %s", arquivo, err.Error())
+	}
+
+	alteracoes, err := migrarConfiguracao(m)
+	if err != nil {
+		return c, origens, err
+	}
+	if len(alteracoes) > 0 {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("%s está na versão 1 e foi convertido para a versão %d só em memória (o arquivo não foi alterado):\n  %s", arquivo, versaoConfig, strings.Join(alteracoes, "\n  ")))
+	}
+
+	perfis, _ := m["perfis"].(map[string]interface{})
+	delete(m, "perfis")
+	desconhecidas := chavesDesconhecidas(m, reflect.TypeOf(c), "")
+	for nome, p := range perfis {
+		desconhecidas = append(desconhecidas, chavesDesconhecidas(p, reflect.TypeOf(configuracao{}), "perfis."+nome)...)
+	}
+	if len(desconhecidas) > 0 {
+		return c, origens, fmt.Errorf("%s: chaves desconhecidas: %s", arquivo, strings.Join(desconhecidas, ", "))
+	}
+
+	cfg, _ := m["configuracao"].(map[string]interface{})
+	if cfg == nil {
+		cfg = make(map[string]interface{})
+		m["configuracao"] = cfg
+	}
+	marcarOrigens(cfg, "", arquivo, origens)
+
+	if op.Perfil != "" {
+		m["perfil"] = op.Perfil
+	}
+	if perfil, _ := m["perfil"].(string); perfil != "" {
+		if err := aplicarPerfil(cfg, perfis, perfil, origens); err != nil {
+			return c, origens, fmt.Errorf("%s: %s", arquivo, err.Error())
+		}
+	}
+	if err := aplicarAmbiente(cfg, origens); err != nil {
+		return c, origens, err
+	}
+	if err := aplicarSets(cfg, op.Sets, origens); err != nil {
+		return c, origens, err
+	}
+
+	dat, _ = json.Marshal(m)
+	if err := json.Unmarshal(dat, &c); err != nil {
+		return c, origens, fmt.Errorf("%s: %s", arquivo, err.Error())
+	}
+
+	if problemas := validarConfiguracao(c); len(problemas) > 0 {
+		return c, origens, fmt.Errorf("%s inválido:\n  %s", arquivo, strings.Join(problemas, "\n  "))
+	}
+	return c, origens, nil
+}
+
+// migrarConfiguracao converte um config da versão 1 para a atual: chaves em
+// minúsculas, "S"/"N" para booleanos, segundos/horas para durações (o
+// tempodeexecucao 0 vira o padrão) e a chave nomeremetende para
+// nomeremetente. Devolve o que foi alterado, vazio quando já está na atual.
+func migrarConfiguracao(m map[string]interface{}) ([]string, error) {
+	versao := 1
+	if v, ok := m["versao"]; ok {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, errors.New("versao deve ser um número")
+		}
+		versao = int(f)
+	}
+	if versao == versaoConfig {
+		return nil, nil
+	}
+	if versao != 1 {
+		return nil, fmt.Errorf("versão %d do config não suportada (atual: %d)", versao, versaoConfig)
+	}
+
+	mg := &migracao{}
+	mg.minusculas(m, "")
+	cfg, _ := m["configuracao"].(map[string]interface{})
+	if cfg == nil {
+		return nil, errors.New("configuracao não encontrada")
+	}
+
+	for _, k := range []string{"criardiretorio", "execucaocontinua", "enviaremail"} {
+		mg.paraBooleano(cfg, "configuracao", k)
+	}
+	if f, ok := cfg["tempodeexecucao"].(float64); ok && f <= 0 {
+		padrao := configPadrao().Configuracao.TempoDeExecucao.String()
+		cfg["tempodeexecucao"] = padrao
+		mg.anotar("configuracao.tempodeexecucao", f, padrao+" (padrão)")
+	}
+	mg.paraDuracao(cfg, "configuracao", "tempodeexecucao", time.Second)
+	if email, ok := cfg["email"].(map[string]interface{}); ok {
+		if v, ok := email["nomeremetende"]; ok {
+			email["nomeremetente"] = v
+			delete(email, "nomeremetende")
+			mg.alteracoes = append(mg.alteracoes, "configuracao.email.nomeremetende renomeada para nomeremetente")
+		}
+		mg.paraDuracao(email, "configuracao.email", "intervalo", time.Second)
+	}
+	if webhook, ok := cfg["webhook"].(map[string]interface{}); ok {
+		mg.paraDuracao(webhook, "configuracao.webhook", "intervalo", time.Second)
+	}
+	if resumo, ok := cfg["resumo"].(map[string]interface{}); ok {
+		mg.paraBooleano(resumo, "configuracao.resumo", "ativo")
+		mg.paraDuracao(resumo, "configuracao.resumo", "janela", time.Hour)
+	}
+	if csv, ok := cfg["csv"].(map[string]interface{}); ok {
+		mg.paraBooleano(csv, "configuracao.csv", "somentecabecalho")
+	}
+	if saidas, ok := cfg["saidas"].(map[string]interface{}); ok {
+		for nome, s := range saidas {
+			if saida, ok := s.(map[string]interface{}); ok {
+				mg.paraBooleano(saida, "configuracao.saidas."+nome, "somentecabecalho")
+			}
+		}
+	}
+	m["versao"] = versaoConfig
+	sort.Strings(mg.alteracoes)
+	return append([]string{fmt.Sprintf("versao: 1 -> %d", versaoConfig)}, mg.alteracoes...), nil
+}
+
+// migracao acumula a descrição de cada valor alterado pela migração.
+type migracao struct {
+	alteracoes []string
+}
+
+func (mg *migracao) anotar(caminho string, de, para interface{}) {
+	mg.alteracoes = append(mg.alteracoes, fmt.Sprintf("%s: %v -> %v", caminho, de, para))
+}
+
+func (mg *migracao) minusculas(v interface{}, caminho string) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		if lista, ok := v.([]interface{}); ok {
+			for i, item := range lista {
+				mg.minusculas(item, fmt.Sprintf("%s[%d]", caminho, i))
+			}
+		}
+		return
+	}
+	for k, item := range m {
+		l := strings.ToLower(k)
+		mg.minusculas(item, juntarCaminho(caminho, l))
+		if l != k {
+			delete(m, k)
+			m[l] = item
+			mg.alteracoes = append(mg.alteracoes, fmt.Sprintf("%s renomeada para %s", juntarCaminho(caminho, k), l))
+		}
+	}
+}
+
+func (mg *migracao) paraBooleano(m map[string]interface{}, caminho, chave string) {
+	if s, ok := m[chave].(string); ok {
+		m[chave] = strings.ToUpper(strings.TrimSpace(s)) == "S"
+		mg.anotar(juntarCaminho(caminho, chave), fmt.Sprintf("%q", s), m[chave])
+	}
+}
+
+func (mg *migracao) paraDuracao(m map[string]interface{}, caminho, chave string, unidade time.Duration) {
+	if f, ok := m[chave].(float64); ok {
+		m[chave] = (time.Duration(f) * unidade).String()
+		mg.anotar(juntarCaminho(caminho, chave), f, m[chave])
+	}
+}
+
+// chavesDesconhecidas compara as chaves do JSON, exatamente, com as tags do tipo.
+func chavesDesconhecidas(v interface{}, t reflect.Type, caminho string) []string {
+	var desconhecidas []string
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok || t == reflect.TypeOf(duracao{}) {
+			return nil
+		}
+		campos := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			if nome := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; nome != "" && nome != "-" {
+				campos[nome] = t.Field(i).Type
+			}
+		}
+		for k, item := range m {
+			ft, ok := campos[k]
+			if !ok {
+				desconhecidas = append(desconhecidas, juntarCaminho(caminho, k))
+				continue
+			}
+			desconhecidas = append(desconhecidas, chavesDesconhecidas(item, ft, juntarCaminho(caminho, k))...)
+		}
+	case reflect.Map:
+		if m, ok := v.(map[string]interface{}); ok {
+			for k, item := range m {
+				desconhecidas = append(desconhecidas, chavesDesconhecidas(item, t.Elem(), juntarCaminho(caminho, k))...)
+			}
+		}
+	case reflect.Slice:
+		if lista, ok := v.([]interface{}); ok {
+			for i, item := range lista {
+				desconhecidas = append(desconhecidas, chavesDesconhecidas(item, t.Elem(), fmt.Sprintf("%s[%d]", caminho, i))...)
+			}
+		}
+	}
+	return desconhecidas
+}
+
+func validarConfiguracao(c Config) []string {
+	var p []string
+	cfg := c.Configuracao
+	if cfg.TempoDeExecucao.Duration <= 0 {
+		p = append(p, "tempodeexecucao deve ser maior que zero")
+	}
+	d := cfg.Diretorios
+	for nome, dir := range map[string]string{
+		"diretorios.csvgerados": d.CSVGerados, "diretorios.planilhasimportadas": d.PlanilhasImportadas,
+		"diretorios.planilhasaimportar": d.PlanilhasAImportar, "diretorios.planilhascomerro": d.PlanilhasComErro,
+		"diretorios.planilhassemmetadado": d.PlanilhasSemMetaDado, "diretorios.planilhasambiguas": d.PlanilhasAmbiguas,
+		"diretorios.planilhasretidas": d.PlanilhasRetidas, "diretorios.planilhasquarentena": d.PlanilhasQuarentena,
+		"diretorios.planilhasduplicadas": d.PlanilhasDuplicadas, "diretorios.extracao": d.Extracao, "diretorios.indices": d.Indices, "diretorios.log": d.Log, "metadados.diretorio": cfg.Metadados.Diretorio, "metadados.nomearquivo": cfg.Metadados.NomeArquivo,
+	} {
+		if strings.TrimSpace(dir) == "" {
+			p = append(p, nome+" não pode ser vazio")
+		}
+	}
+	if cfg.EnviarEmail {
+		if cfg.Email.Servidor == "" || cfg.Email.ContaEmail == "" {
+			p = append(p, "email.servidor e email.contaemail são obrigatórios com enviaremail")
+		}
+		if cfg.Email.Porta <= 0 || cfg.Email.Porta > 65535 {
+			p = append(p, "email.porta inválida")
+		}
+		if cfg.Email.CertificadoCA != "" {
+			if _, err := carregarCertificadoCA(cfg.Email.CertificadoCA); err != nil {
+				p = append(p, "email.certificadoca: "+err.Error())
+			}
+		}
+	}
+	if cfg.Email.Tentativas < 1 || cfg.Webhook.Tentativas < 1 {
+		p = append(p, "tentativas deve ser ao menos 1")
+	}
+	if cfg.Resumo.Ativo && cfg.Resumo.Janela.Duration <= 0 {
+		p = append(p, "resumo.janela deve ser maior que zero")
+	}
+	if cfg.Resumo.Ativo && !cfg.EnviarEmail {
+		p = append(p, "resumo.ativo exige enviaremail: o resumo é enviado por e-mail")
+	}
+	for _, padrao := range append(append([]string{}, cfg.Entrada.Incluir...), cfg.Entrada.Excluir...) {
+		if _, err := filepath.Match(padrao, ""); err != nil {
+			p = append(p, fmt.Sprintf("entrada: padrão %q inválido", padrao))
+		}
+	}
+	if cfg.Entrada.LimiteMembro.Bytes <= 0 || cfg.Entrada.LimitePacote.Bytes <= 0 {
+		p = append(p, "entrada.limitemembro e entrada.limitepacote devem ser maiores que zero")
+	}
+	if cfg.Reprocessamento.Ativo && (cfg.Reprocessamento.Tentativas < 1 || cfg.Reprocessamento.Espera.Duration <= 0) {
+		p = append(p, "reprocessamento.tentativas deve ser ao menos 1 e reprocessamento.espera maior que zero")
+	}
+	for agr, pd := range cfg.Duplicidade {
+		if !valorPermitido(strings.ToLower(pd.Politica), politicaPrimeira, politicaUltima, politicaFalhar) {
+			p = append(p, fmt.Sprintf("duplicidade.%s.politica deve ser primeira, ultima ou falhar", agr))
+		} else if strings.ToLower(pd.Politica) == politicaUltima && strings.TrimSpace(pd.Coluna) == "" {
+			p = append(p, fmt.Sprintf("duplicidade.%s.coluna é obrigatória com a política ultima", agr))
+		}
+	}
+	global := dialetoPadrao.sobrepor(cfg.CSV)
+	p = append(p, validarDialeto("csv", cfg.CSV, global)...)
+	for k, s := range cfg.Saidas {
+		efetivo := global
+		for pai, ps := range cfg.Saidas {
+			if strings.HasPrefix(k, pai+"_") {
+				efetivo = efetivo.sobrepor(ps)
+			}
+		}
+		p = append(p, validarDialeto("saidas."+k, s, efetivo.sobrepor(s))...)
+	}
+	for i, w := range cfg.Webhook.Destinos {
+		nome := fmt.Sprintf("webhook.destinos[%d]", i)
+		if w.URL == "" {
+			p = append(p, nome+".url não pode ser vazio")
+		}
+		if !valorPermitido(strings.ToLower(w.Formato), "", "json", "slack", "teams") {
+			p = append(p, nome+".formato deve ser json, slack ou teams")
+		}
+		for _, e := range w.Eventos {
+			if _, ok := titulosEvento[strings.ToLower(e)]; !ok {
+				p = append(p, fmt.Sprintf("%s.eventos: evento %q desconhecido", nome, e))
+			}
+		}
+	}
+	return p
+}
+
+// validarDialeto confere os valores de d e, quando d define o delimitador ou
+// o separador decimal, a combinação efetiva com as camadas de baixo (padrão,
+// csv e a saída do agrupador): com os dois iguais cada decimal viraria dois
+// campos.
+func validarDialeto(nome string, d dialetoCSV, efetivo dialetoCSV) []string {
+	var p []string
+	if len([]rune(d.Delimitador)) > 1 {
+		p = append(p, nome+".delimitador deve ter um caractere")
+	}
+	if !valorPermitido(strings.ToLower(d.Aspas), "", "minimo", "sempre", "nunca") {
+		p = append(p, nome+".aspas deve ser minimo, sempre ou nunca")
+	}
+	if !valorPermitido(strings.ToLower(d.FimDeLinha), "", "lf", "crlf") {
+		p = append(p, nome+".fimdelinha deve ser lf ou crlf")
+	}
+	if !valorPermitido(strings.ToLower(d.Codificacao), "", "utf-8", "utf-8-bom", "windows-1252") {
+		p = append(p, nome+".codificacao deve ser utf-8, utf-8-bom ou windows-1252")
+	}
+	if !valorPermitido(d.SeparadorDecimal, "", ".", ",") {
+		p = append(p, nome+".separadordecimal deve ser . ou ,")
+	}
+	if (d.Delimitador != "" || d.SeparadorDecimal != "") && efetivo.Delimitador == efetivo.SeparadorDecimal {
+		p = append(p, fmt.Sprintf("%s: delimitador e separadordecimal não podem ser ambos %q", nome, efetivo.Delimitador))
+	}
+	return p
+}
+
+func valorPermitido(valor string, permitidos ...string) bool {
+	for _, v := range permitidos {
+		if valor == v {
+			return true
+		}
+	}
+	return false
+}
+
+// verificarConfiguracao implementa -check-config: imprime cada valor efetivo
+// com a camada de onde veio (segredos em texto puro mascarados) e confere se os
+// segredos dos recursos ligados resolvem.
+func verificarConfiguracao(op opcoesConfig) bool {
+	c, origens, err := lerConfiguracao(op)
+	if err != nil {
+		fmt.Println(err.Error())
+		return false
+	}
+	exibir := mascararSegredos(c.Configuracao)
+
+	if c.Perfil != "" {
+		fmt.Println("perfil:", c.Perfil)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, l := range linhasConfig(exibir, origens) {
+		fmt.Fprintf(w, "[%s]\t%s = %s\n", l.Origem, l.Caminho, l.Valor)
+	}
+	w.Flush()
+
+	if err := resolverSegredos(&c, *secretKey, secoesInativas(&c)...); err != nil {
+		fmt.Println("Segredo não resolvido - ", err.Error())
+		return false
+	}
+	return true
+}
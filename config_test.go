@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidarConfiguracaoEventosWebhook(t *testing.T) {
+	c := configPadrao()
+	c.Configuracao.Metadados.NomeArquivo = "MetaDados.xlsx"
+	c.Configuracao.Webhook.Destinos = []destinoWebhook{{
+		URL:     "http://localhost/hook",
+		Eventos: []string{"sucesso", "Falha", "semmetadado", "ambiguo", "retido", "quarentena", "duplicado", "resumo"},
+	}}
+	if p := validarConfiguracao(c); len(p) > 0 {
+		t.Errorf("eventos válidos recusados: %v", p)
+	}
+
+	c.Configuracao.Webhook.Destinos[0].Eventos = []string{"sucesso", "terminado"}
+	p := validarConfiguracao(c)
+	if len(p) != 1 || !strings.Contains(p[0], `"terminado"`) {
+		t.Errorf("validarConfiguracao = %v, esperado só o evento terminado", p)
+	}
+}
+
+func TestLerConfiguracaoVersao1(t *testing.T) {
+	arquivo := filepath.Join(t.TempDir(), "config.json")
+	v1 := `{"Configuracao": {
+		"CriarDiretorio": "S", "ExecucaoContinua": "N", "TempoDeExecucao": 0,
+		"Metadados": {"NomeArquivo": "MetaDados.xlsx"},
+		"Email": {"NomeRemetende": "Robo", "Intervalo": 30}
+	}}`
+	if err := ioutil.WriteFile(arquivo, []byte(v1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := lerConfiguracao(opcoesConfig{Arquivo: arquivo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Configuracao.CriarDiretorio || c.Configuracao.ExecucaoContinua {
+		t.Errorf("flags S/N = %v, %v", c.Configuracao.CriarDiretorio, c.Configuracao.ExecucaoContinua)
+	}
+	if c.Configuracao.TempoDeExecucao != configPadrao().Configuracao.TempoDeExecucao {
+		t.Errorf("tempodeexecucao 0 = %s, esperado o padrão", c.Configuracao.TempoDeExecucao)
+	}
+	if c.Configuracao.Email.NomeRemetente != "Robo" || c.Configuracao.Email.Intervalo.Duration != 30*time.Second {
+		t.Errorf("email = %+v", c.Configuracao.Email)
+	}
+}
+
+func TestMigrarConfiguracaoAlteracoes(t *testing.T) {
+	var m map[string]interface{}
+	json.Unmarshal([]byte(`{"Configuracao": {"ExecucaoContinua": "S", "TempoDeExecucao": 0, "Email": {"NomeRemetende": "Robo"}}}`), &m)
+	alteracoes, err := migrarConfiguracao(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texto := strings.Join(alteracoes, "\n")
+	for _, esperado := range []string{
+		"versao: 1 -> 2",
+		"Configuracao renomeada para configuracao",
+		`configuracao.execucaocontinua: "S" -> true`,
+		"configuracao.tempodeexecucao: 0 -> 1m0s (padrão)",
+		"configuracao.email.nomeremetende renomeada para nomeremetente",
+	} {
+		if !strings.Contains(texto, esperado) {
+			t.Errorf("alterações sem %q:\n%s", esperado, texto)
+		}
+	}
+
+	if alteracoes, _ := migrarConfiguracao(m); len(alteracoes) != 0 {
+		t.Errorf("config já na versão atual migrado de novo: %v", alteracoes)
+	}
+}
+
+func TestValidarConfiguracaoDelimitadorIgualAoDecimal(t *testing.T) {
+	casos := []struct {
+		csv      dialetoCSV
+		saidas   map[string]dialetoCSV
+		invalido string
+	}{
+		{dialetoCSV{Delimitador: ";", SeparadorDecimal: ","}, nil, ""},
+		{dialetoCSV{SeparadorDecimal: ","}, nil, "csv"},
+		{dialetoCSV{Delimitador: ";", SeparadorDecimal: ","}, map[string]dialetoCSV{"chamados": {Delimitador: ","}}, "saidas.chamados"},
+		{dialetoCSV{}, map[string]dialetoCSV{"chamados": {SeparadorDecimal: ","}, "chamados_plan1": {Delimitador: ";"}}, "saidas.chamados:"},
+		{dialetoCSV{Delimitador: ";"}, map[string]dialetoCSV{"chamados": {SeparadorDecimal: ","}, "chamados_plan1": {Delimitador: ","}}, "saidas.chamados_plan1"},
+	}
+	for _, c := range casos {
+		cfg := configPadrao()
+		cfg.Configuracao.Metadados.NomeArquivo = "MetaDados.xlsx"
+		cfg.Configuracao.CSV, cfg.Configuracao.Saidas = c.csv, c.saidas
+		p := validarConfiguracao(cfg)
+		if c.invalido == "" && len(p) > 0 || c.invalido != "" && (len(p) != 1 || !strings.HasPrefix(p[0], c.invalido)) {
+			t.Errorf("csv %+v saidas %+v: %v, esperado problema em %q", c.csv, c.saidas, p, c.invalido)
+		}
+	}
+}
+
+func TestValidarConfiguracaoResumoSemEmail(t *testing.T) {
+	casos := []struct {
+		resumo, email bool
+		invalido      bool
+	}{
+		{false, false, false},
+		{true, true, false},
+		{false, true, false},
+		{true, false, true},
+	}
+	for _, c := range casos {
+		cfg := configPadrao()
+		cfg.Configuracao.Metadados.NomeArquivo = "MetaDados.xlsx"
+		cfg.Configuracao.Email.Servidor, cfg.Configuracao.Email.ContaEmail = "smtp.local", "robo@local"
+		cfg.Configuracao.Resumo.Ativo, cfg.Configuracao.EnviarEmail = c.resumo, c.email
+		p := validarConfiguracao(cfg)
+		if c.invalido != (len(p) == 1 && strings.HasPrefix(p[0], "resumo.ativo")) || !c.invalido && len(p) > 0 {
+			t.Errorf("resumo %v enviaremail %v: %v", c.resumo, c.email, p)
+		}
+	}
+}
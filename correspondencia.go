@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// padraoArquivo é um valor da coluna nomearquivo do metadado, compilado. O
+// nome da planilha (minúsculo, sem extensão) é comparado em qualquer posição:
+//
+//	chamados abertos        texto: o nome contém o texto (como antes)
+//	chamados*abb            glob: * e ? valem qualquer sequência / caractere
+//	re:^chamados .* abb$    expressão regular
+//
+// A chave do arquivo no metadado (dicArquivo, aba de e-mails) é o texto antes
+// do primeiro "*", ou o valor inteiro para re:. A expressão de re: é mantida
+// como foi escrita (\D não é \d) e compilada sem diferenciar maiúsculas.
+type padraoArquivo struct {
+	Chave    string
+	Texto    string
+	re       *regexp.Regexp
+	literais int
+	captura  *regexp.Regexp
+}
+
+var padroesArquivo []padraoArquivo
+
+func novoPadraoArquivo(valor string) (padraoArquivo, error) {
+	valor = strings.TrimSpace(strings.Replace(valor, "–", "-", -1))
+	regex := strings.HasPrefix(strings.ToLower(valor), "re:")
+	if !regex {
+		valor = strings.ToLower(valor)
+	}
+	p := padraoArquivo{Chave: strings.ToLower(valor), Texto: valor}
+	expr := ""
+	switch {
+	case regex:
+		expr = valor[len("re:"):]
+	case strings.ContainsAny(valor, "*?"):
+		p.Chave = strings.Split(valor, "*")[0]
+		var b strings.Builder
+		for _, r := range valor {
+			switch r {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		expr = b.String()
+	default:
+		expr = regexp.QuoteMeta(valor)
+	}
+
+	flags := ""
+	if regex {
+		flags = "(?i)"
+	}
+	re, err := regexp.Compile(flags + expr)
+	if err != nil {
+		return p, fmt.Errorf("padrão de nome de arquivo inválido %q: %s", valor, err.Error())
+	}
+	p.re = re
+	p.literais = contarLiterais(expr)
+	return p, nil
+}
+
+// contarLiterais mede a especificidade do padrão: quantos caracteres ele fixa.
+func contarLiterais(expr string) int {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	var contar func(r *syntax.Regexp) int
+	contar = func(r *syntax.Regexp) int {
+		if r.Op == syntax.OpLiteral {
+			return len(r.Rune)
+		}
+		if r.Op == syntax.OpAlternate {
+			return 0
+		}
+		n := 0
+		for _, sub := range r.Sub {
+			n += contar(sub)
+		}
+		return n
+	}
+	return contar(re)
+}
+
+// candidatosArquivo devolve os padrões que casam com o nome, do mais
+// específico para o menos específico, um por chave.
+func candidatosArquivo(nome string) []padraoArquivo {
+	var candidatos []padraoArquivo
+	vistos := make(map[string]bool)
+	for _, p := range padroesArquivo {
+		if vistos[p.Chave] || !p.re.MatchString(nome) {
+			continue
+		}
+		vistos[p.Chave] = true
+		candidatos = append(candidatos, p)
+	}
+	sort.SliceStable(candidatos, func(i, j int) bool {
+		if candidatos[i].literais != candidatos[j].literais {
+			return candidatos[i].literais > candidatos[j].literais
+		}
+		return candidatos[i].Chave < candidatos[j].Chave
+	})
+	return candidatos
+}
+
+// empatados devolve os candidatos com a mesma especificidade do primeiro;
+// mais de um significa que o arquivo é ambíguo.
+func empatados(candidatos []padraoArquivo) []padraoArquivo {
+	if len(candidatos) < 2 {
+		return nil
+	}
+	n := 1
+	for n < len(candidatos) && candidatos[n].literais == candidatos[0].literais {
+		n++
+	}
+	if n == 1 {
+		return nil
+	}
+	return candidatos[:n]
+}
+
+func descreverCandidatos(candidatos []padraoArquivo) []string {
+	var d []string
+	for _, c := range candidatos {
+		d = append(d, fmt.Sprintf("%s (padrão %q, %d caractere(s) fixos)", c.Chave, c.Texto, c.literais))
+	}
+	return d
+}
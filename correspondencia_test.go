@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNovoPadraoArquivo(t *testing.T) {
+	casos := []struct {
+		valor   string
+		chave   string
+		casa    []string
+		naoCasa []string
+	}{
+		{"Chamados Abertos", "chamados abertos", []string{"chamados abertos", "stef - chamados abertos jan"}, []string{"chamados fechados"}},
+		{"chamados*ABB", "chamados", []string{"chamados 2016 abb", "chamadosabb"}, []string{"chamados 2016 abc"}},
+		{"chamados ?bb", "chamados ?bb", []string{"chamados abb"}, []string{"chamados bb"}},
+		{"re:^chamados \\d{4}$", "re:^chamados \\d{4}$", []string{"chamados 2016"}, []string{"chamados abcd", "x chamados 2016"}},
+		// \D é "não dígito" e não pode virar \d ao normalizar o metadado.
+		{"re:^Chamados \\D+$", "re:^chamados \\d+$", []string{"chamados abb"}, []string{"chamados 2016"}},
+		{"RE:^chamados \\S+$", "re:^chamados \\s+$", []string{"chamados abb"}, []string{"chamados  "}},
+		{"chamados – abb", "chamados - abb", []string{"chamados - abb"}, nil},
+	}
+	for _, c := range casos {
+		p, err := novoPadraoArquivo(c.valor)
+		if err != nil {
+			t.Errorf("novoPadraoArquivo(%q): %v", c.valor, err)
+			continue
+		}
+		if p.Chave != c.chave {
+			t.Errorf("novoPadraoArquivo(%q).Chave = %q, esperado %q", c.valor, p.Chave, c.chave)
+		}
+		for _, nome := range c.casa {
+			if !p.re.MatchString(nome) {
+				t.Errorf("%q não casou com %q", c.valor, nome)
+			}
+		}
+		for _, nome := range c.naoCasa {
+			if p.re.MatchString(nome) {
+				t.Errorf("%q casou com %q", c.valor, nome)
+			}
+		}
+	}
+}
+
+func TestNovoPadraoArquivoInvalido(t *testing.T) {
+	if _, err := novoPadraoArquivo("re:chamados ("); err == nil {
+		t.Error("expressão inválida não deu erro")
+	}
+}
+
+func usarPadroes(t *testing.T, valores ...string) {
+	padroesArquivo = nil
+	dicArquivo = make(map[string]string)
+	for _, v := range valores {
+		p, err := novoPadraoArquivo(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		padroesArquivo = append(padroesArquivo, p)
+		dicArquivo[p.Chave] = p.Chave + "|plan1"
+	}
+	t.Cleanup(func() {
+		padroesArquivo = nil
+		dicArquivo = nil
+	})
+}
+
+func TestBuscarNomeArquivoMaisEspecifico(t *testing.T) {
+	usarPadroes(t, "chamados", "chamados abertos", "chamados*abb")
+	casos := []struct {
+		arquivo string
+		chave   string
+	}{
+		{"chamados abertos.xlsx", "chamados abertos"},
+		{"chamados fechados.xlsx", "chamados"},
+		{"chamados fechados abb.xlsx", "chamados"},
+		{"planilha.xlsx", "planilha.xlsx"},
+	}
+	for _, c := range casos {
+		info, ambiguos := buscarNomeArquivo(c.arquivo)
+		if ambiguos != nil {
+			t.Errorf("%s: ambíguo entre %v", c.arquivo, descreverCandidatos(ambiguos))
+			continue
+		}
+		if info[2] != c.chave {
+			t.Errorf("%s: chave %q, esperado %q", c.arquivo, info[2], c.chave)
+		}
+	}
+}
+
+func TestBuscarNomeArquivoAmbiguo(t *testing.T) {
+	usarPadroes(t, "chamados abertos", "abertos chamados")
+	_, ambiguos := buscarNomeArquivo("abertos chamados abertos.xlsx")
+	if len(ambiguos) != 2 {
+		t.Fatalf("%d candidato(s) empatado(s), esperado 2", len(ambiguos))
+	}
+	if d := strings.Join(descreverCandidatos(ambiguos), "; "); !strings.Contains(d, "16 caractere(s)") {
+		t.Errorf("descrição dos candidatos: %s", d)
+	}
+}
+
+func TestContarLiterais(t *testing.T) {
+	casos := []struct {
+		expr string
+		n    int
+	}{
+		{"chamados", 8},
+		{"chamados.*abb", 11},
+		{"(abc|de)x", 1},
+		{"(", 0},
+	}
+	for _, c := range casos {
+		if n := contarLiterais(c.expr); n != c.n {
+			t.Errorf("contarLiterais(%q) = %d, esperado %d", c.expr, n, c.n)
+		}
+	}
+}
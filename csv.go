@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// dialetoCSV descreve o formato de um CSV gerado. Campos vazios herdam o valor
+// da configuração global (config.csv) e, por fim, o padrão do csv.NewWriter
+// (vírgula, "\n", UTF-8 sem BOM), que é o formato dos CSVs anteriores aos
+// dialetos. O dialeto do Excel pt-BR é opcional: delimitador ";", fimdelinha
+// "crlf", codificacao "utf-8-bom" e separadordecimal ",".
+type dialetoCSV struct {
+	Delimitador      string `json:"delimitador"`
+	Aspas            string `json:"aspas"`            // minimo, sempre, nunca
+	FimDeLinha       string `json:"fimdelinha"`       // lf, crlf
+	Codificacao      string `json:"codificacao"`      // utf-8, utf-8-bom, windows-1252
+	SeparadorDecimal string `json:"separadordecimal"` // . ou ,
+	SomenteCabecalho *bool  `json:"somentecabecalho"` // grava apenas a linha de cabeçalho
+}
+
+var dialetoPadrao = dialetoCSV{
+	Delimitador:      ",",
+	Aspas:            "minimo",
+	FimDeLinha:       "lf",
+	Codificacao:      "utf-8",
+	SeparadorDecimal: ".",
+	SomenteCabecalho: new(bool),
+}
+
+func (d dialetoCSV) sobrepor(o dialetoCSV) dialetoCSV {
+	if o.Delimitador != "" {
+		d.Delimitador = o.Delimitador
+	}
+	if o.Aspas != "" {
+		d.Aspas = strings.ToLower(o.Aspas)
+	}
+	if o.FimDeLinha != "" {
+		d.FimDeLinha = strings.ToLower(o.FimDeLinha)
+	}
+	if o.Codificacao != "" {
+		d.Codificacao = strings.ToLower(o.Codificacao)
+	}
+	if o.SeparadorDecimal != "" {
+		d.SeparadorDecimal = o.SeparadorDecimal
+	}
+	if o.SomenteCabecalho != nil {
+		d.SomenteCabecalho = o.SomenteCabecalho
+	}
+	return d
+}
+
+// dialetoDaSaida resolve o dialeto de um CSV. As chaves de config.saidas podem ser
+// o agrupador ("chamados") ou o agrupador com a planilha ("chamados_plan1"); a mais
+// específica prevalece.
+func dialetoDaSaida(nomeAgrupador string, nomesheet string) dialetoCSV {
+	d := dialetoPadrao.sobrepor(config.Configuracao.CSV)
+	agr := strings.ToLower(nomeAgrupador)
+	if o, ok := config.Configuracao.Saidas[agr]; ok {
+		d = d.sobrepor(o)
+	}
+	sheet := strings.TrimSuffix(strings.ToLower(nomesheet), "_")
+	if o, ok := config.Configuracao.Saidas[agr+"_"+sheet]; ok {
+		d = d.sobrepor(o)
+	}
+	return d
+}
+
+// colunasNumericas devolve os índices do cabeçalho cujo Para é do tipo "n" no dicionário.
+func colunasNumericas(cabecalho []string, nomeAgrupador string, nomeEmpresa string, nomesheet string) map[int]bool {
+	numericas := make(map[int]bool)
+	sheet := strings.TrimSuffix(strings.ToLower(nomesheet), "_")
+	for _, cab := range est[nomeAgrupador] {
+		if cab.Tipo != "n" || cab.Empresa != nomeEmpresa || cab.Sheet != sheet {
+			continue
+		}
+		for j, c := range cabecalho {
+			if c == cab.Para {
+				numericas[j] = true
+			}
+		}
+	}
+	return numericas
+}
+
+// formatarNumericas aplica formatarDecimal às colunas numéricas da linha,
+// ignorando as que a linha não tem.
+func formatarNumericas(linha []string, numericas map[int]bool, separador string) {
+	for j := range numericas {
+		if j < len(linha) {
+			linha[j] = formatarDecimal(linha[j], separador)
+		}
+	}
+}
+
+// literalDecimal aceita só números escritos por extenso, como "-10.5"; "1e5",
+// "NaN" e "Inf" ficam como estão.
+var literalDecimal = regexp.MustCompile(`^[-+]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+
+// formatarDecimal troca o separador decimal somente de valores numéricos.
+func formatarDecimal(valor string, separador string) string {
+	if separador == "." || separador == "" {
+		return valor
+	}
+	if !literalDecimal.MatchString(valor) {
+		return valor
+	}
+	return strings.Replace(valor, ".", separador, 1)
+}
+
+type escritorCSV struct {
+	w       *bufio.Writer
+	dialeto dialetoCSV
+	err     error
+}
+
+func novoEscritorCSV(w io.Writer, dialeto dialetoCSV) *escritorCSV {
+	e := &escritorCSV{w: bufio.NewWriter(w), dialeto: dialeto}
+	if dialeto.Codificacao == "utf-8-bom" {
+		_, e.err = e.w.WriteString("\ufeff")
+	}
+	return e
+}
+
+func (e *escritorCSV) Write(linha []string) error {
+	if e.err != nil {
+		return e.err
+	}
+	for i, campo := range linha {
+		if i > 0 {
+			e.escrever(e.dialeto.Delimitador)
+		}
+		if e.precisaAspas(campo) {
+			e.escrever(`"` + strings.Replace(campo, `"`, `""`, -1) + `"`)
+		} else {
+			e.escrever(campo)
+		}
+	}
+	if e.dialeto.FimDeLinha == "crlf" {
+		e.escrever("\r\n")
+	} else {
+		e.escrever("\n")
+	}
+	return e.err
+}
+
+func (e *escritorCSV) WriteAll(linhas [][]string) error {
+	for _, linha := range linhas {
+		if err := e.Write(linha); err != nil {
+			return err
+		}
+	}
+	e.Flush()
+	return e.err
+}
+
+func (e *escritorCSV) Flush() {
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+}
+
+func (e *escritorCSV) Error() error {
+	return e.err
+}
+
+func (e *escritorCSV) precisaAspas(campo string) bool {
+	switch e.dialeto.Aspas {
+	case "sempre":
+		return true
+	case "nunca":
+		return false
+	}
+	if campo == "" {
+		return false
+	}
+	return campo[0] == ' ' || strings.Contains(campo, e.dialeto.Delimitador) || strings.ContainsAny(campo, "\"\r\n")
+}
+
+func (e *escritorCSV) escrever(s string) {
+	if e.err != nil {
+		return
+	}
+	if e.dialeto.Codificacao != "windows-1252" {
+		_, e.err = e.w.WriteString(s)
+		return
+	}
+	for len(s) > 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		s = s[n:]
+		e.err = e.w.WriteByte(paraWindows1252(r))
+		if e.err != nil {
+			return
+		}
+	}
+}
+
+// faixa 0x80-0x9F do Windows-1252; o restante coincide com o Latin-1.
+var windows1252 = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+func paraWindows1252(r rune) byte {
+	if r < 0x80 || (r >= 0xA0 && r <= 0xFF) {
+		return byte(r)
+	}
+	if b, ok := windows1252[r]; ok {
+		return b
+	}
+	return '?'
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEscreverCSVLinhasCurtas(t *testing.T) {
+	est = map[string][]*dicionario{
+		"chamados": {
+			{Sheet: "plan1", De: "id", Para: "id", Empresa: "stef"},
+			{Sheet: "plan1", De: "valor", Para: "valor", Tipo: "n", Empresa: "stef"},
+		},
+	}
+	config.Configuracao.CSV = dialetoCSV{Delimitador: ";", SeparadorDecimal: ","}
+	defer func() {
+		est = nil
+		config.Configuracao.CSV = dialetoCSV{}
+	}()
+
+	m := mapearCabecalho([]string{"stef", "chamados"}, "Plan1", []string{"id", "valor"})
+	plan := [][]string{
+		m.cabecalho(),
+		m.linha([]string{"1", "10.5"}, "stef", capturaNome{}),
+		m.linha([]string{"2"}, "stef", capturaNome{}),
+		m.linha(nil, "stef", capturaNome{}),
+	}
+	var b bytes.Buffer
+	if err := escreverCSV(&b, plan, "chamados", "stef", "Plan1_"); err != nil {
+		t.Fatal(err)
+	}
+	esperado := "id;valor;idempresa\n1;10,5;stef\n2;;stef\n;;stef\n"
+	if b.String() != esperado {
+		t.Errorf("escreverCSV = %q, esperado %q", b.String(), esperado)
+	}
+}
+
+func TestFormatarNumericasIgnoraColunasAusentes(t *testing.T) {
+	linha := []string{"1.5"}
+	formatarNumericas(linha, map[int]bool{0: true, 3: true}, ",")
+	if linha[0] != "1,5" {
+		t.Errorf("linha[0] = %q, esperado \"1,5\"", linha[0])
+	}
+}
+
+func TestFormatarDecimal(t *testing.T) {
+	casos := []struct {
+		valor, separador, esperado string
+	}{
+		{"1.5", ",", "1,5"},
+		{"1.5", ".", "1.5"},
+		{"abc.def", ",", "abc.def"},
+		{"", ",", ""},
+		{"-2.25", ",", "-2,25"},
+	}
+	for _, c := range casos {
+		if r := formatarDecimal(c.valor, c.separador); r != c.esperado {
+			t.Errorf("formatarDecimal(%q, %q) = %q, esperado %q", c.valor, c.separador, r, c.esperado)
+		}
+	}
+}
+
+func TestFormatarDecimalSoLiterais(t *testing.T) {
+	for _, v := range []string{"1e5", "NaN", "Inf", "-Inf", "0x1p-2", "1_000.5", "1.2.3", " 1.5"} {
+		if r := formatarDecimal(v, ","); r != v {
+			t.Errorf("formatarDecimal(%q) = %q, esperado sem alteração", v, r)
+		}
+	}
+	for v, esperado := range map[string]string{"+3.0": "+3,0", ".5": ",5", "7.": "7,", "42": "42"} {
+		if r := formatarDecimal(v, ","); r != esperado {
+			t.Errorf("formatarDecimal(%q) = %q, esperado %q", v, r, esperado)
+		}
+	}
+}
+
+func TestEscritorCSVDialetos(t *testing.T) {
+	linhas := [][]string{{"id", "título"}, {"1", `diz "oi"; tchau`}, {"2", " café €™ 日本"}, {"3", ""}}
+	casos := []struct {
+		nome     string
+		dialeto  dialetoCSV
+		esperado string
+	}{
+		{"padrão", dialetoCSV{},
+			"id,título\n1,\"diz \"\"oi\"\"; tchau\"\n2,\" café €™ 日本\"\n3,\n"},
+		{"bom e crlf", dialetoCSV{Delimitador: ";", FimDeLinha: "crlf", Codificacao: "utf-8-bom"},
+			"\ufeffid;título\r\n1;\"diz \"\"oi\"\"; tchau\"\r\n2;\" café €™ 日本\"\r\n3;\r\n"},
+		{"sempre", dialetoCSV{Aspas: "sempre"},
+			"\"id\",\"título\"\n\"1\",\"diz \"\"oi\"\"; tchau\"\n\"2\",\" café €™ 日本\"\n\"3\",\"\"\n"},
+		{"nunca", dialetoCSV{Aspas: "nunca"},
+			"id,título\n1,diz \"oi\"; tchau\n2, café €™ 日本\n3,\n"},
+		{"windows-1252", dialetoCSV{Codificacao: "windows-1252"},
+			"id,t\xedtulo\n1,\"diz \"\"oi\"\"; tchau\"\n2,\" caf\xe9 \x80\x99 ??\"\n3,\n"},
+	}
+	for _, c := range casos {
+		var b bytes.Buffer
+		if err := novoEscritorCSV(&b, dialetoPadrao.sobrepor(c.dialeto)).WriteAll(linhas); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != c.esperado {
+			t.Errorf("%s: %q, esperado %q", c.nome, b.String(), c.esperado)
+		}
+	}
+}
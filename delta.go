@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deltaconfig liga a carga incremental para os agrupadores das "bases
+// históricas", que o cliente reenvia inteiras todo mês. As colunas marcadas
+// com Chave = "s" no metadado identificam a linha; o CSV passa a ter só as
+// linhas novas ou alteradas desde a carga anterior da mesma empresa, arquivo
+// e aba. Com exclusoes, as chaves que sumiram vão para <csv>_exclusoes.csv.
+// O índice (chave → hash da linha) fica em diretorios.indices.
+//
+// A carga completa (a primeira, ou quando o índice não pode ser lido) grava o
+// CSV com o nome de sempre; cada delta grava um CSV próprio,
+// <csv>_delta_<aaaammddhhmmssmmm>.csv, que o Qlik deve acrescentar à base em
+// vez de substituí-la.
+type deltaconfig struct {
+	Agrupadores []string `json:"agrupadores"`
+	Exclusoes   bool     `json:"exclusoes"`
+}
+
+func deltaAtivo(nomeAgrupador string) bool {
+	for _, a := range config.Configuracao.Delta.Agrupadores {
+		if strings.EqualFold(a, nomeAgrupador) {
+			return true
+		}
+	}
+	return false
+}
+
+// colunasChave devolve os índices do cabeçalho cujo Para é chave no dicionário.
+func colunasChave(cabecalho []string, nomeAgrupador string, nomeEmpresa string, nomesheet string) []int {
+	var chaves []int
+	sheet := strings.TrimSuffix(strings.ToLower(nomesheet), "_")
+	for j, c := range cabecalho {
+		for _, cab := range est[nomeAgrupador] {
+			if cab.Chave == "s" && cab.Empresa == nomeEmpresa && cab.Sheet == sheet && c == cab.Para {
+				chaves = append(chaves, j)
+				break
+			}
+		}
+	}
+	return chaves
+}
+
+// avisosSemChave guarda as abas já avisadas de que não têm coluna chave, para
+// o aviso sair uma vez por carga do metadado e não a cada arquivo e passada.
+var avisosSemChave = struct {
+	sync.Mutex
+	abas map[string]bool
+}{abas: make(map[string]bool)}
+
+// avisarSemChave imprime, uma vez por recurso (Delta, Duplicidade) e aba, que
+// a aba não tem coluna chave; consequencia completa a frase.
+func avisarSemChave(recurso string, nomeEmpresa string, nomeAgrupador string, nomesheet string, consequencia string) {
+	aba := fmt.Sprintf("%s/%s/%s", nomeEmpresa, nomeAgrupador, strings.TrimSuffix(strings.ToLower(nomesheet), "_"))
+	avisosSemChave.Lock()
+	avisado := avisosSemChave.abas[recurso+"|"+aba]
+	avisosSemChave.abas[recurso+"|"+aba] = true
+	avisosSemChave.Unlock()
+	if !avisado {
+		fmt.Println(fmt.Sprintf("%s: %s sem coluna chave no metadado%s.", recurso, aba, consequencia))
+	}
+}
+
+// esquecerAvisosSemChave volta a avisar depois de recarregar o metadado.
+func esquecerAvisosSemChave() {
+	avisosSemChave.Lock()
+	avisosSemChave.abas = make(map[string]bool)
+	avisosSemChave.Unlock()
+}
+
+// indicesMu serializa a leitura e a gravação dos índices, para duas cargas do
+// mesmo arquivo na mesma passada não se atropelarem.
+var indicesMu sync.Mutex
+
+func arquivoIndiceDelta(chave string, nomeAgrupador string, nomeEmpresa string, nomesheet string) string {
+	nome := caracteresInvalidos.ReplaceAllString(strings.ToLower(strings.Join([]string{nomeAgrupador, strings.TrimSuffix(nomesheet, "_"), chave}, "_")), "_")
+	return fmt.Sprintf("%s\\%s.json", criarPasta(config.Configuracao.Diretorios.Indices, nomeEmpresa), nome)
+}
+
+func lerIndiceDelta(caminho string) (map[string]string, error) {
+	dat, err := ioutil.ReadFile(caminho)
+	if os.IsNotExist(err) {
+		// Primeira carga: todas as linhas são novas.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	indice := make(map[string]string)
+	return indice, json.Unmarshal(dat, &indice)
+}
+
+func hashLinha(valores []string) string {
+	soma := sha256.Sum256([]byte(strings.Join(valores, "\x1f")))
+	return hex.EncodeToString(soma[:8])
+}
+
+// resultadoDelta é a planilha reduzida às linhas novas e alteradas, com as
+// chaves excluídas (cada uma com os valores das colunas Chaves).
+type resultadoDelta struct {
+	Plan        [][]string
+	Chaves      []string
+	Exclusoes   [][]string
+	Novas       int
+	Alteradas   int
+	Inalteradas int
+}
+
+// comparadorDelta compara as linhas, uma a uma, com o índice da carga
+// anterior. As colunas capturadas do nome do arquivo (as últimas numCaptura)
+// ficam fora do hash, para o período não marcar todas as linhas como
+// alteradas. Quem usa segura indicesMu ao criar e ao concluir.
+type comparadorDelta struct {
+	resultadoDelta
+	colunas    []int
+	anterior   map[string]string
+	atual      map[string]string
+	caminho    string
+	numCaptura int
+}
+
+// novoComparadorDelta devolve nil quando o agrupador não tem delta ou a aba
+// não tem coluna chave.
+func novoComparadorDelta(cabecalho []string, chave string, nomeAgrupador string, nomeEmpresa string, nomesheet string, numCaptura int) (*comparadorDelta, error) {
+	if !deltaAtivo(nomeAgrupador) {
+		return nil, nil
+	}
+	colunas := colunasChave(cabecalho, nomeAgrupador, nomeEmpresa, nomesheet)
+	if len(colunas) == 0 {
+		avisarSemChave("Delta", nomeEmpresa, nomeAgrupador, nomesheet, ", carga completa")
+		return nil, nil
+	}
+	c := &comparadorDelta{colunas: colunas, atual: make(map[string]string), numCaptura: numCaptura}
+	c.caminho = arquivoIndiceDelta(chave, nomeAgrupador, nomeEmpresa, nomesheet)
+	anterior, err := lerIndiceDelta(c.caminho)
+	if err != nil {
+		return nil, fmt.Errorf("índice %s: %s", c.caminho, err.Error())
+	}
+	c.anterior = anterior
+	for _, j := range colunas {
+		c.Chaves = append(c.Chaves, cabecalho[j])
+	}
+	return c, nil
+}
+
+// manter indica se a linha vai para o CSV (nova ou alterada).
+func (c *comparadorDelta) manter(linha []string) bool {
+	var valores []string
+	for _, j := range c.colunas {
+		if j < len(linha) {
+			valores = append(valores, strings.TrimSpace(linha[j]))
+		}
+	}
+	k := strings.Join(valores, "\x1f")
+	if strings.Trim(k, "\x1f") == "" {
+		// Sem chave não há como comparar: a linha sempre vai.
+		c.Novas++
+		return true
+	}
+	fim := len(linha) - c.numCaptura
+	if fim < 0 {
+		fim = 0
+	}
+	h := hashLinha(linha[:fim])
+	c.atual[k] = h
+	switch hAnterior, ok := c.anterior[k]; {
+	case !ok:
+		c.Novas++
+	case hAnterior != h:
+		c.Alteradas++
+	default:
+		c.Inalteradas++
+		return false
+	}
+	return true
+}
+
+// concluir lista as chaves excluídas e grava o índice novo.
+func (c *comparadorDelta) concluir() error {
+	for k := range c.anterior {
+		if _, ok := c.atual[k]; !ok {
+			c.Exclusoes = append(c.Exclusoes, strings.Split(k, "\x1f"))
+		}
+	}
+	dat, err := json.Marshal(c.atual)
+	if err == nil {
+		// Grava ao lado e troca, para uma queda no meio não corromper o índice.
+		temp := c.caminho + ".parcial"
+		if err = ioutil.WriteFile(temp, dat, 0644); err == nil {
+			if err = os.Rename(temp, c.caminho); err != nil {
+				os.Remove(temp)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("índice %s: %s", c.caminho, err.Error())
+	}
+	return nil
+}
+
+// destino devolve o caminho do CSV desta carga: o próprio csv na carga
+// completa e, no delta, um nome novo com o instante da carga, para não
+// sobrescrever o CSV da carga anterior.
+func (c *comparadorDelta) destino(csv string) string {
+	if c == nil || c.anterior == nil {
+		return csv
+	}
+	base := strings.TrimSuffix(csv, ".csv") + "_delta_" + strings.Replace(time.Now().Format("20060102150405.000"), ".", "", 1)
+	nome := base + ".csv"
+	for i := 2; existeArquivo(nome) || existeArquivo(nome+".parcial"); i++ {
+		nome = fmt.Sprintf("%s_%d.csv", base, i)
+	}
+	return nome
+}
+
+func existeArquivo(nome string) bool {
+	_, err := os.Stat(nome)
+	return err == nil
+}
+
+// aplicarDelta compara plan com a carga anterior; Plan fica só com as linhas
+// novas e alteradas. O índice novo só é gravado por concluir, depois que o CSV
+// foi aceito. Devolve nil quando o agrupador não tem delta ou a aba não tem
+// coluna chave.
+func aplicarDelta(plan [][]string, chave string, nomeAgrupador string, nomeEmpresa string, nomesheet string, numCaptura int) (*comparadorDelta, error) {
+	if len(plan) == 0 {
+		return nil, nil
+	}
+	indicesMu.Lock()
+	c, err := novoComparadorDelta(plan[0], chave, nomeAgrupador, nomeEmpresa, nomesheet, numCaptura)
+	indicesMu.Unlock()
+	if c == nil || err != nil {
+		return nil, err
+	}
+	c.Plan = [][]string{plan[0]}
+	for _, linha := range plan[1:] {
+		if c.manter(linha) {
+			c.Plan = append(c.Plan, linha)
+		}
+	}
+	return c, nil
+}
+
+// gravarExclusoes grava as chaves excluídas ao lado do CSV, no mesmo dialeto.
+func gravarExclusoes(nomeCSV string, r *resultadoDelta, nomeAgrupador string, nomesheet string) (string, error) {
+	nome := strings.TrimSuffix(nomeCSV, ".csv") + "_exclusoes.csv"
+	file, err := os.Create(nome)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	w := novoEscritorCSV(file, dialetoDaSaida(nomeAgrupador, nomesheet))
+	w.WriteAll(append([][]string{r.Chaves}, r.Exclusoes...))
+	return nome, w.Error()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configurarDelta liga o delta do agrupador "historico" com a coluna "id"
+// como chave e os índices num diretório temporário.
+func configurarDelta(t *testing.T) string {
+	dir := t.TempDir()
+	est = map[string][]*dicionario{
+		"historico": {
+			{Sheet: "plan1", De: "id", Para: "id", Empresa: "stef", Chave: "s"},
+			{Sheet: "plan1", De: "status", Para: "status", Empresa: "stef"},
+		},
+	}
+	config.Configuracao.Delta.Agrupadores = []string{"historico"}
+	config.Configuracao.Diretorios.Indices = dir
+	t.Cleanup(func() {
+		est = nil
+		config.Configuracao.Delta = deltaconfig{}
+		config.Configuracao.Diretorios.Indices = ""
+	})
+	return dir
+}
+
+func cargaDelta(t *testing.T, plan [][]string) *comparadorDelta {
+	c, err := aplicarDelta(plan, "chamadosbh", "historico", "stef", "Plan1_", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil {
+		t.Fatal("aplicarDelta devolveu nil com o delta ligado")
+	}
+	return c
+}
+
+func TestAplicarDelta(t *testing.T) {
+	configurarDelta(t)
+	cab := []string{"id", "status", "idempresa", "periodo"}
+
+	c := cargaDelta(t, [][]string{cab, {"1", "aberto", "stef", "2016-01"}, {"2", "aberto", "stef", "2016-01"}, {"3", "aberto", "stef", "2016-01"}})
+	if c.Novas != 3 || len(c.Plan) != 4 {
+		t.Fatalf("primeira carga: %d nova(s), %d linha(s); esperado 3 e 4", c.Novas, len(c.Plan))
+	}
+	if err := c.concluir(); err != nil {
+		t.Fatal(err)
+	}
+
+	// O período capturado (última coluna) muda todo mês e fica fora do hash.
+	c = cargaDelta(t, [][]string{cab, {"1", "aberto", "stef", "2016-02"}, {"2", "fechado", "stef", "2016-02"}, {"4", "aberto", "stef", "2016-02"}, {"", "sem chave", "stef", "2016-02"}})
+	if c.Novas != 2 || c.Alteradas != 1 || c.Inalteradas != 1 {
+		t.Errorf("segunda carga: %d nova(s), %d alterada(s), %d inalterada(s); esperado 2, 1 e 1", c.Novas, c.Alteradas, c.Inalteradas)
+	}
+	var ids []string
+	for _, linha := range c.Plan[1:] {
+		ids = append(ids, linha[0])
+	}
+	if strings.Join(ids, ",") != "2,4," {
+		t.Errorf("linhas do delta = %v, esperado [2 4 \"\"]", ids)
+	}
+	if err := c.concluir(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Exclusoes) != 1 || c.Exclusoes[0][0] != "3" {
+		t.Errorf("exclusões = %v, esperado [[3]]", c.Exclusoes)
+	}
+}
+
+func TestAplicarDeltaSoGravaOIndiceAoConcluir(t *testing.T) {
+	configurarDelta(t)
+	plan := [][]string{{"id", "status", "idempresa", "periodo"}, {"1", "aberto", "stef", "2016-01"}}
+
+	c := cargaDelta(t, plan)
+	if _, err := os.Stat(c.caminho); !os.IsNotExist(err) {
+		t.Fatalf("índice gravado antes de concluir: %v", err)
+	}
+	// O CSV não foi aceito: a carga seguinte ainda é completa.
+	c = cargaDelta(t, plan)
+	if c.Novas != 1 {
+		t.Errorf("%d nova(s) depois de uma carga não concluída, esperado 1", c.Novas)
+	}
+}
+
+func TestConcluirDeltaTrocaOIndice(t *testing.T) {
+	configurarDelta(t)
+	c := cargaDelta(t, [][]string{{"id", "status", "idempresa", "periodo"}, {"1", "aberto", "stef", "2016-01"}})
+	if err := c.concluir(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.caminho + ".parcial"); !os.IsNotExist(err) {
+		t.Errorf("o índice temporário ficou para trás: %v", err)
+	}
+	indice, err := lerIndiceDelta(c.caminho)
+	if err != nil || len(indice) != 1 {
+		t.Errorf("lerIndiceDelta = %v, %v; esperado uma chave", indice, err)
+	}
+}
+
+func TestDestinoDelta(t *testing.T) {
+	configurarDelta(t)
+	csv := filepath.Join(t.TempDir(), "historico_plan1_chamadosbh.csv")
+	plan := [][]string{{"id", "status", "idempresa", "periodo"}, {"1", "aberto", "stef", "2016-01"}}
+
+	c := cargaDelta(t, plan)
+	if d := c.destino(csv); d != csv {
+		t.Errorf("carga completa em %s, esperado %s", d, csv)
+	}
+	if err := c.concluir(); err != nil {
+		t.Fatal(err)
+	}
+
+	c = cargaDelta(t, plan)
+	d := c.destino(csv)
+	if d == csv || !strings.HasPrefix(d, strings.TrimSuffix(csv, ".csv")+"_delta_") || !strings.HasSuffix(d, ".csv") {
+		t.Fatalf("delta em %s, esperado um nome próprio ao lado de %s", d, csv)
+	}
+	if err := ioutil.WriteFile(d, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d2 := c.destino(csv); d2 == d {
+		t.Errorf("dois deltas no mesmo instante foram para %s", d2)
+	}
+
+	var nulo *comparadorDelta
+	if d := nulo.destino(csv); d != csv {
+		t.Errorf("sem delta em %s, esperado %s", d, csv)
+	}
+}
+
+func TestAplicarDeltaDesligado(t *testing.T) {
+	configurarDelta(t)
+	c, err := aplicarDelta([][]string{{"id"}, {"1"}}, "chamadosbh", "outro", "stef", "Plan1_", 0)
+	if c != nil || err != nil {
+		t.Errorf("aplicarDelta de agrupador sem delta = %v, %v; esperado nil, nil", c, err)
+	}
+}
+
+func TestAvisoSemChaveUmaVezPorAba(t *testing.T) {
+	configurarDelta(t)
+	est = map[string][]*dicionario{"historico": {{Sheet: "plan1", De: "id", Para: "id", Empresa: "stef"}}}
+	esquecerAvisosSemChave()
+	t.Cleanup(esquecerAvisosSemChave)
+	cab := []string{"id", "idempresa"}
+	emp := []string{"stef", "historico"}
+
+	passada := func() {
+		for i := 0; i < 3; i++ {
+			deduplicarPlan([][]string{cab, {"1", "stef"}}, politicaDuplicidade{Politica: politicaPrimeira}, "historico.xlsx", emp, "Plan1_", "")
+			deduplicarPlan([][]string{cab, {"1", "stef"}}, politicaDuplicidade{Politica: politicaPrimeira}, "historico.xlsx", emp, "Plan2_", "")
+			if c, err := novoComparadorDelta(cab, "historico", "historico", "stef", "Plan1_", 0); c != nil || err != nil {
+				t.Fatalf("novoComparadorDelta sem chave = %v, %v", c, err)
+			}
+		}
+	}
+	esperado := "Duplicidade: stef/historico/plan1 sem coluna chave no metadado.\n" +
+		"Duplicidade: stef/historico/plan2 sem coluna chave no metadado.\n" +
+		"Delta: stef/historico/plan1 sem coluna chave no metadado, carga completa.\n"
+
+	if saida, _ := capturarSaidas(t, passada); saida != esperado {
+		t.Errorf("primeira passada:\n%s\nesperado\n%s", saida, esperado)
+	}
+	if saida, _ := capturarSaidas(t, passada); saida != "" {
+		t.Errorf("aviso repetido na segunda passada:\n%s", saida)
+	}
+	esquecerAvisosSemChave()
+	if saida, _ := capturarSaidas(t, passada); saida != esperado {
+		t.Errorf("depois de recarregar o metadado:\n%s\nesperado\n%s", saida, esperado)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// retratoMetadado é a parte do metadado que define os CSVs (abas por chave e
+// mapeamentos por agrupador). Cada versão carregada é guardada em
+// Log\metadados\<versao>.json e a versão vai para o ledger, para comparar
+// depois com "metadados diff".
+type retratoMetadado struct {
+	Empresa   map[string][]string      `json:"empresa"`
+	Agrupador map[string][]*dicionario `json:"agrupador"`
+}
+
+// versaoMetadadoAtual é a versão do metadado carregado, registrada no ledger.
+var versaoMetadadoAtual string
+
+func retratoDe(m *metadado) *retratoMetadado {
+	return &retratoMetadado{Empresa: m.Empresa, Agrupador: m.Agrupador}
+}
+
+func (r *retratoMetadado) versao() string {
+	dat, _ := json.Marshal(r)
+	soma := sha256.Sum256(dat)
+	return hex.EncodeToString(soma[:])[:12]
+}
+
+func arquivoRetrato(versao string) string {
+	return fmt.Sprintf("%s\\metadados\\%s.json", config.Configuracao.Diretorios.Log, versao)
+}
+
+// salvarRetratoMetadado grava o retrato se a versão ainda não existe e devolve a versão.
+func salvarRetratoMetadado(r *retratoMetadado) string {
+	versao := r.versao()
+	if _, err := os.Stat(arquivoRetrato(versao)); err == nil {
+		return versao
+	}
+	os.MkdirAll(fmt.Sprintf("%s\\metadados", config.Configuracao.Diretorios.Log), os.ModeType)
+	dat, err := json.Marshal(r)
+	if err == nil {
+		err = ioutil.WriteFile(arquivoRetrato(versao), dat, 0644)
+	}
+	if err != nil {
+		fmt.Println("Erro ao gravar a versão do metadado - ", err.Error())
+	}
+	return versao
+}
+
+func lerRetratoMetadado(versao string) (*retratoMetadado, error) {
+	dat, err := ioutil.ReadFile(arquivoRetrato(versao))
+	if err != nil {
+		return nil, fmt.Errorf("versão %s do metadado não encontrada em %s", versao, arquivoRetrato(versao))
+	}
+	r := &retratoMetadado{}
+	return r, json.Unmarshal(dat, r)
+}
+
+// carregarRetrato interpreta um argumento de "metadados diff": "atual" (as
+// fontes configuradas), "ledger" (a versão da última carga registrada), uma
+// versão guardada em Log\metadados ou o caminho de um metadado xlsx/json/yaml.
+func carregarRetrato(arg string) (*retratoMetadado, string, error) {
+	switch arg {
+	case "atual":
+		carregarMetadado()
+		return &retratoMetadado{Empresa: dic, Agrupador: est}, "atual (" + versaoMetadadoAtual + ")", nil
+	case "ledger":
+		registros, err := lerLedger()
+		if err != nil {
+			return nil, "", err
+		}
+		for i := len(registros) - 1; i >= 0; i-- {
+			if registros[i].Metadado != "" {
+				r, err := lerRetratoMetadado(registros[i].Metadado)
+				return r, "ledger (" + registros[i].Metadado + ")", err
+			}
+		}
+		return nil, "", fmt.Errorf("nenhuma carga com versão do metadado no ledger")
+	}
+	if _, err := os.Stat(arquivoRetrato(arg)); err == nil {
+		r, err := lerRetratoMetadado(arg)
+		return r, arg, err
+	}
+	m, err := carregaDicionario(arg)
+	if err != nil {
+		return nil, "", err
+	}
+	return retratoDe(m), arg, nil
+}
+
+type chaveMapeamento struct {
+	Empresa, Agrupador, Sheet, De string
+}
+
+func (r *retratoMetadado) mapeamentos() map[chaveMapeamento]*dicionario {
+	m := make(map[chaveMapeamento]*dicionario)
+	for agr, lista := range r.Agrupador {
+		for _, d := range lista {
+			k := chaveMapeamento{d.Empresa, agr, d.Sheet, d.De}
+			if _, ok := m[k]; !ok {
+				m[k] = d
+			}
+		}
+	}
+	return m
+}
+
+// diferencasMetadado lista as mudanças de antigo para novo: abas de arquivo
+// incluídas, removidas ou apontando para outra empresa/agrupador e mapeamentos
+// De→Para incluídos, removidos ou alterados, agrupados por empresa/agrupador/aba.
+func diferencasMetadado(antigo *retratoMetadado, novo *retratoMetadado) []string {
+	var linhas []string
+	for _, k := range chavesOrdenadas(antigo.Empresa, novo.Empresa) {
+		a, okA := antigo.Empresa[k]
+		n, okN := novo.Empresa[k]
+		switch {
+		case !okA:
+			linhas = append(linhas, fmt.Sprintf("arquivo %s: + empresa %s, agrupador %s", k, n[0], n[1]))
+		case !okN:
+			linhas = append(linhas, fmt.Sprintf("arquivo %s: - empresa %s, agrupador %s", k, a[0], a[1]))
+		case strings.Join(a, "|") != strings.Join(n, "|"):
+			linhas = append(linhas, fmt.Sprintf("arquivo %s: ~ empresa/agrupador %s/%s → %s/%s", k, a[0], a[1], n[0], n[1]))
+		}
+	}
+
+	ma, mn := antigo.mapeamentos(), novo.mapeamentos()
+	var chaves []chaveMapeamento
+	for k := range ma {
+		chaves = append(chaves, k)
+	}
+	for k := range mn {
+		if _, ok := ma[k]; !ok {
+			chaves = append(chaves, k)
+		}
+	}
+	sort.Slice(chaves, func(i, j int) bool {
+		a, b := chaves[i], chaves[j]
+		if a.Empresa != b.Empresa {
+			return a.Empresa < b.Empresa
+		}
+		if a.Agrupador != b.Agrupador {
+			return a.Agrupador < b.Agrupador
+		}
+		if a.Sheet != b.Sheet {
+			return a.Sheet < b.Sheet
+		}
+		return a.De < b.De
+	})
+	for _, k := range chaves {
+		prefixo := fmt.Sprintf("%s/%s/%s: ", k.Empresa, k.Agrupador, k.Sheet)
+		a, okA := ma[k]
+		n, okN := mn[k]
+		switch {
+		case !okA:
+			linhas = append(linhas, fmt.Sprintf("%s+ %s → %s (obrigatório %q, tipo %q)", prefixo, k.De, n.Para, n.Obrigatorio, n.Tipo))
+		case !okN:
+			linhas = append(linhas, fmt.Sprintf("%s- %s → %s", prefixo, k.De, a.Para))
+		default:
+			var mudancas []string
+			if a.Para != n.Para {
+				mudancas = append(mudancas, fmt.Sprintf("para %s → %s", a.Para, n.Para))
+			}
+			if a.Obrigatorio != n.Obrigatorio {
+				mudancas = append(mudancas, fmt.Sprintf("obrigatório %q → %q", a.Obrigatorio, n.Obrigatorio))
+			}
+			if a.Tipo != n.Tipo {
+				mudancas = append(mudancas, fmt.Sprintf("tipo %q → %q", a.Tipo, n.Tipo))
+			}
+			if a.Chave != n.Chave {
+				mudancas = append(mudancas, fmt.Sprintf("chave %q → %q", a.Chave, n.Chave))
+			}
+			if len(mudancas) > 0 {
+				linhas = append(linhas, fmt.Sprintf("%s~ %s: %s", prefixo, k.De, strings.Join(mudancas, "; ")))
+			}
+		}
+	}
+	return linhas
+}
+
+func chavesOrdenadas(a map[string][]string, b map[string][]string) []string {
+	vistas := make(map[string]bool)
+	var chaves []string
+	for _, m := range []map[string][]string{a, b} {
+		for k := range m {
+			if !vistas[k] {
+				vistas[k] = true
+				chaves = append(chaves, k)
+			}
+		}
+	}
+	sort.Strings(chaves)
+	return chaves
+}
+
+// impactoMetadado refaz, com o metadado novo, o cabeçalho de saída da última
+// carga bem sucedida de cada arquivo do ledger e lista as que mudariam.
+func impactoMetadado(novo *retratoMetadado) ([]string, error) {
+	registros, err := lerLedger()
+	if err != nil {
+		return nil, err
+	}
+	ultima := make(map[string]registroLedger)
+	var ordem []string
+	for _, r := range registros {
+		if r.Status != eventoSucesso || len(r.Abas) == 0 {
+			continue
+		}
+		if _, ok := ultima[r.Arquivo]; !ok {
+			ordem = append(ordem, r.Arquivo)
+		}
+		ultima[r.Arquivo] = r
+	}
+
+	dicAnterior, estAnterior := dic, est
+	dic, est = novo.Empresa, novo.Agrupador
+	defer func() { dic, est = dicAnterior, estAnterior }()
+
+	var linhas []string
+	for _, arquivo := range ordem {
+		r := ultima[arquivo]
+		for _, aba := range r.Abas {
+			emp, ok := dic[r.Chave+"|"+strings.ToLower(aba.Sheet)]
+			if !ok {
+				linhas = append(linhas, fmt.Sprintf("%s [%s]: a aba deixaria de ter metadado", arquivo, aba.Sheet))
+				continue
+			}
+			m := mapearCabecalho(emp, aba.Sheet, aba.Origem)
+			if len(m.Faltantes) > 0 {
+				var faltantes []string
+				for _, f := range m.Faltantes {
+					faltantes = append(faltantes, f.De)
+				}
+				linhas = append(linhas, fmt.Sprintf("%s [%s]: seria rejeitada, faltam as obrigatórias %s", arquivo, aba.Sheet, strings.Join(faltantes, ", ")))
+				continue
+			}
+			if saida := m.cabecalho(); strings.Join(saida, "|") != strings.Join(aba.Saida, "|") {
+				linhas = append(linhas, fmt.Sprintf("%s [%s]: esquema %s → %s", arquivo, aba.Sheet, strings.Join(aba.Saida, ","), strings.Join(saida, ",")))
+			}
+		}
+	}
+	return linhas, nil
+}
+
+func imprimirDiffMetadado(w io.Writer, rotuloAntigo string, rotuloNovo string, diferencas []string, impacto []string) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", rotuloAntigo, rotuloNovo)
+	if len(diferencas) == 0 {
+		fmt.Fprintln(w, "\nSem diferenças.")
+	} else {
+		fmt.Fprintln(w)
+		for _, d := range diferencas {
+			fmt.Fprintln(w, d)
+		}
+	}
+	if len(impacto) > 0 {
+		fmt.Fprintf(w, "\nCargas já importadas que gerariam outro esquema (%d):\n", len(impacto))
+		for _, i := range impacto {
+			fmt.Fprintln(w, "  "+i)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func retratoAntigo() *retratoMetadado {
+	return &retratoMetadado{
+		Empresa: map[string][]string{
+			"chamados|plan1":  {"stef", "chamados", "plan1"},
+			"historico|plan1": {"stef", "historico", "plan1"},
+			"removido|plan1":  {"stef", "removido", "plan1"},
+			"velho|plan1":     {"stef", "velho", "plan1"},
+		},
+		Agrupador: map[string][]*dicionario{
+			"chamados": {
+				{Sheet: "plan1", De: "id", Para: "Id", Tipo: "n", Empresa: "stef"},
+				{Sheet: "plan1", De: "status", Para: "Status", Empresa: "stef"},
+			},
+			"historico": {
+				{Sheet: "plan1", De: "data", Para: "Data", Empresa: "stef"},
+			},
+		},
+	}
+}
+
+func retratoNovo() *retratoMetadado {
+	return &retratoMetadado{
+		Empresa: map[string][]string{
+			"chamados|plan1":  {"stef", "chamados", "plan1"},
+			"historico|plan1": {"stef", "historico", "plan1"},
+			"novo|plan1":      {"abb", "novo", "plan1"},
+			"velho|plan1":     {"abb", "velho", "plan1"},
+		},
+		Agrupador: map[string][]*dicionario{
+			"chamados": {
+				{Sheet: "plan1", De: "id", Para: "IdChamado", Tipo: "n", Obrigatorio: "s", Empresa: "stef", Chave: "s"},
+				{Sheet: "plan1", De: "prioridade", Para: "Prioridade", Obrigatorio: "s", Empresa: "stef"},
+			},
+			"historico": {
+				{Sheet: "plan1", De: "data", Para: "DataHora", Empresa: "stef"},
+			},
+		},
+	}
+}
+
+func TestDiferencasMetadado(t *testing.T) {
+	esperado := []string{
+		"arquivo novo|plan1: + empresa abb, agrupador novo",
+		"arquivo removido|plan1: - empresa stef, agrupador removido",
+		"arquivo velho|plan1: ~ empresa/agrupador stef/velho → abb/velho",
+		`stef/chamados/plan1: ~ id: para Id → IdChamado; obrigatório "" → "s"; chave "" → "s"`,
+		`stef/chamados/plan1: + prioridade → Prioridade (obrigatório "s", tipo "")`,
+		"stef/chamados/plan1: - status → Status",
+		"stef/historico/plan1: ~ data: para Data → DataHora",
+	}
+	if d := diferencasMetadado(retratoAntigo(), retratoNovo()); !reflect.DeepEqual(d, esperado) {
+		t.Errorf("diferencasMetadado =\n%q\nesperado\n%q", d, esperado)
+	}
+	if d := diferencasMetadado(retratoAntigo(), retratoAntigo()); len(d) != 0 {
+		t.Errorf("diferenças entre versões iguais: %q", d)
+	}
+}
+
+func TestImpactoMetadado(t *testing.T) {
+	config.Configuracao.Diretorios.Log = t.TempDir()
+	t.Cleanup(func() { config.Configuracao.Diretorios.Log = "" })
+	registros := []registroLedger{
+		{Arquivo: "chamados", Chave: "chamados", Status: eventoSucesso, Abas: []abaLedger{{Sheet: "Plan1", Origem: []string{"id", "status"}, Saida: []string{"Id", "Status", "idempresa"}}}},
+		{Arquivo: "historico", Chave: "historico", Status: eventoSucesso, Abas: []abaLedger{{Sheet: "plan1", Origem: []string{"data"}, Saida: []string{"Data", "idempresa"}}}},
+		{Arquivo: "removido", Chave: "removido", Status: eventoSucesso, Abas: []abaLedger{{Sheet: "plan1", Origem: []string{"x"}, Saida: []string{"x", "idempresa"}}}},
+		// Só a última carga bem sucedida de cada arquivo conta.
+		{Arquivo: "historico", Chave: "historico", Status: eventoSucesso, Abas: []abaLedger{{Sheet: "plan1", Origem: []string{"data", "obs"}, Saida: []string{"Data", "idempresa"}}}},
+		{Arquivo: "historico", Chave: "historico", Status: eventoFalha, Abas: []abaLedger{{Sheet: "plan1", Origem: []string{"outra"}, Saida: []string{"outra", "idempresa"}}}},
+		{Arquivo: "velho", Chave: "velho", Status: eventoSucesso},
+	}
+	for _, r := range registros {
+		registrarLedger(r)
+	}
+	dic, est = retratoAntigo().Empresa, retratoAntigo().Agrupador
+	t.Cleanup(func() { dic, est = nil, nil })
+	dicAntes, estAntes := dic, est
+
+	impacto, err := impactoMetadado(retratoNovo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	esperado := []string{
+		"chamados [Plan1]: seria rejeitada, faltam as obrigatórias prioridade",
+		"historico [plan1]: esquema Data,idempresa → DataHora,idempresa",
+		"removido [plan1]: a aba deixaria de ter metadado",
+	}
+	if !reflect.DeepEqual(impacto, esperado) {
+		t.Errorf("impactoMetadado =\n%q\nesperado\n%q", impacto, esperado)
+	}
+	if !reflect.DeepEqual(dic, dicAntes) || !reflect.DeepEqual(est, estAntes) {
+		t.Error("impactoMetadado não restaurou o metadado carregado")
+	}
+
+	if impacto, _ := impactoMetadado(retratoAntigo()); len(impacto) != 0 {
+		t.Errorf("impacto com o mesmo metadado: %q", impacto)
+	}
+}
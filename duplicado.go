@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tealeg/xlsx"
+)
+
+// impressaoArquivo identifica o conteúdo de uma planilha: Arquivo é o sha256
+// dos bytes e Conteudo o sha256 da empresa e das abas normalizadas (nome da
+// aba e valores, sem linhas vazias nem células vazias no fim), que não muda
+// quando o arquivo é salvo de novo ou renomeado. Abas só com o cabeçalho não
+// entram no Conteudo, e um arquivo sem nenhuma linha de dados fica sem ele:
+// relatórios vazios de clientes ou meses diferentes não são duplicados.
+type impressaoArquivo struct {
+	Arquivo  string
+	Conteudo string
+}
+
+func impressaoDe(caminho string, empresa string, xlFile *xlsx.File) impressaoArquivo {
+	var abas [][]byte
+	for _, sheet := range xlFile.Sheets {
+		h := novoHashAba(sheet.Name)
+		for _, row := range sheet.Rows {
+			var valores []string
+			for _, cel := range row.Cells {
+				valores = append(valores, cel.Value)
+			}
+			h.linha(valores)
+		}
+		abas = append(abas, h.soma())
+	}
+	return impressaoArquivo{Arquivo: hashArquivo(caminho), Conteudo: somaConteudo(empresa, abas)}
+}
+
+// empresaDaChave devolve a empresa do metadado para a chave do arquivo.
+func empresaDaChave(chave string) string {
+	if auxarq, ok := dicArquivo[chave]; ok {
+		if emp, ok := dic[auxarq]; ok {
+			return emp[0]
+		}
+	}
+	return ""
+}
+
+func hashArquivo(caminho string) string {
+	f, err := os.Open(caminho)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashAba é o hash do conteúdo normalizado de uma aba. Cada aba tem o seu,
+// para poderem ser lidas em paralelo; o do arquivo é o hash deles, na ordem.
+type hashAba struct {
+	h      hash.Hash
+	linhas int
+}
+
+func novoHashAba(nome string) *hashAba {
+	a := &hashAba{h: sha256.New()}
+	fmt.Fprintf(a.h, "\x1d%s", strings.ToLower(strings.TrimSpace(nome)))
+	return a
+}
+
+func (a *hashAba) linha(valores []string) {
+	n := len(valores)
+	for n > 0 && strings.TrimSpace(valores[n-1]) == "" {
+		n--
+	}
+	if n == 0 {
+		return
+	}
+	a.linhas++
+	io.WriteString(a.h, "\x1e")
+	for i, v := range valores[:n] {
+		if i > 0 {
+			io.WriteString(a.h, "\x1f")
+		}
+		io.WriteString(a.h, strings.TrimSpace(v))
+	}
+}
+
+// soma devolve o hash da aba, ou nil quando ela não tem linhas além do
+// cabeçalho.
+func (a *hashAba) soma() []byte {
+	if a.linhas < 2 {
+		return nil
+	}
+	return a.h.Sum(nil)
+}
+
+// somaConteudo junta a empresa e os hashes das abas com dados; devolve ""
+// quando nenhuma aba tem dados.
+func somaConteudo(empresa string, abas [][]byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "\x1c%s", strings.ToLower(strings.TrimSpace(empresa)))
+	vazio := true
+	for _, a := range abas {
+		if a != nil {
+			h.Write(a)
+			vazio = false
+		}
+	}
+	if vazio {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// originais indexa as cargas bem sucedidas do ledger (e as que estão em
+// processamento, para pegar duplicados da mesma passada) pelas impressões.
+var originais = struct {
+	sync.Mutex
+	carregado   bool
+	porArquivo  map[string]impressaoOriginal
+	porConteudo map[string]impressaoOriginal
+}{porArquivo: make(map[string]impressaoOriginal), porConteudo: make(map[string]impressaoOriginal)}
+
+// impressoesConcluidas acorda quem espera uma reserva pendente, quando ela é
+// confirmada ou liberada.
+var impressoesConcluidas = sync.NewCond(&originais.Mutex)
+
+// impressaoOriginal é uma carga indexada; pendente enquanto o arquivo que a
+// reservou ainda está em processamento.
+type impressaoOriginal struct {
+	registro registroLedger
+	pendente bool
+}
+
+// reservarImpressao devolve a carga original quando imp já foi importada (e
+// o tipo de duplicidade); senão reserva imp para t até confirmarImpressao ou
+// liberarImpressao. Uma cópia de um arquivo ainda em processamento espera o
+// resultado dele: só é duplicada se o original for importado.
+func reservarImpressao(t tarefa, imp impressaoArquivo) (*registroLedger, string) {
+	originais.Lock()
+	defer originais.Unlock()
+	if !originais.carregado {
+		registros, err := lerLedger()
+		if err != nil {
+			fmt.Println("Erro ao ler o ledger - ", err.Error())
+		}
+		for _, r := range registros {
+			if r.Status != eventoSucesso {
+				continue
+			}
+			if r.Hash != "" {
+				originais.porArquivo[r.Hash] = impressaoOriginal{registro: r}
+			}
+			if r.HashConteudo != "" {
+				originais.porConteudo[r.HashConteudo] = impressaoOriginal{registro: r}
+			}
+		}
+		originais.carregado = true
+	}
+
+	for {
+		o, tipo, achou := buscarImpressao(imp)
+		if !achou {
+			break
+		}
+		if !o.pendente {
+			return &o.registro, tipo
+		}
+		impressoesConcluidas.Wait()
+	}
+	r := impressaoOriginal{registro: registroLedger{Data: time.Now(), Arquivo: t.Arquivo, Pasta: t.Pasta}, pendente: true}
+	if imp.Arquivo != "" {
+		originais.porArquivo[imp.Arquivo] = r
+	}
+	if imp.Conteudo != "" {
+		originais.porConteudo[imp.Conteudo] = r
+	}
+	return nil, ""
+}
+
+func buscarImpressao(imp impressaoArquivo) (impressaoOriginal, string, bool) {
+	if o, ok := originais.porArquivo[imp.Arquivo]; ok && imp.Arquivo != "" {
+		return o, "arquivo idêntico", true
+	}
+	if o, ok := originais.porConteudo[imp.Conteudo]; ok && imp.Conteudo != "" {
+		return o, "mesmo conteúdo nas abas", true
+	}
+	return impressaoOriginal{}, "", false
+}
+
+// confirmarImpressao troca a reserva de imp pela carga registrada no ledger.
+func confirmarImpressao(imp impressaoArquivo, r registroLedger) {
+	originais.Lock()
+	defer originais.Unlock()
+	if imp.Arquivo != "" {
+		originais.porArquivo[imp.Arquivo] = impressaoOriginal{registro: r}
+	}
+	if imp.Conteudo != "" {
+		originais.porConteudo[imp.Conteudo] = impressaoOriginal{registro: r}
+	}
+	impressoesConcluidas.Broadcast()
+}
+
+// liberarImpressao desfaz a reserva de um arquivo que não foi importado.
+func liberarImpressao(imp impressaoArquivo) {
+	originais.Lock()
+	defer originais.Unlock()
+	if o, ok := originais.porArquivo[imp.Arquivo]; ok && o.pendente {
+		delete(originais.porArquivo, imp.Arquivo)
+	}
+	if o, ok := originais.porConteudo[imp.Conteudo]; ok && o.pendente {
+		delete(originais.porConteudo, imp.Conteudo)
+	}
+	impressoesConcluidas.Broadcast()
+}
+
+// moverDuplicado separa em PlanilhasDuplicadas um arquivo já importado, com
+// uma nota <arquivo>.txt apontando o original, também gravado no ledger.
+func moverDuplicado(t tarefa, imp impressaoArquivo, original *registroLedger, tipo string) {
+	origem := caminhoNaPasta(original.Pasta, original.Arquivo)
+	motivo := fmt.Sprintf("Duplicado de %s (%s), importado em %s.", origem, tipo, original.Data.Format("02/01/2006 15:04"))
+	destino := criarPasta(config.Configuracao.Diretorios.PlanilhasDuplicadas, t.Pasta)
+	fmt.Println("Duplicado: ", fmt.Sprintf("%s\\%s", t.origem(), t.Arquivo), "-", motivo)
+	erro := os.Rename(fmt.Sprintf("%s\\%s", t.origem(), t.Arquivo), fmt.Sprintf("%s\\%s", destino, t.Arquivo))
+	if erro != nil {
+		fmt.Println(erro.Error())
+	}
+	if err := ioutil.WriteFile(fmt.Sprintf("%s\\%s.txt", destino, t.Arquivo), []byte(motivo+"\r\n"), 0644); err != nil {
+		fmt.Println("Erro ao gravar a nota do duplicado - ", err.Error())
+	}
+	registrarLedger(registroLedger{Arquivo: t.Arquivo, Pasta: t.Pasta, Pacote: t.Pacote, Chave: original.Chave, Status: eventoDuplicado, Motivos: []string{motivo}, Hash: imp.Arquivo, HashConteudo: imp.Conteudo, DuplicadoDe: origem})
+	notificar(notificacao{Evento: eventoDuplicado, Chave: original.Chave, Arquivo: t.Arquivo, Motivos: []string{motivo}})
+}
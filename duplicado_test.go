@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// conteudoDe calcula o Conteudo de um arquivo com uma aba plan1.
+func conteudoDe(empresa string, linhas ...[]string) string {
+	h := novoHashAba("plan1")
+	for _, l := range linhas {
+		h.linha(l)
+	}
+	return somaConteudo(empresa, [][]byte{h.soma()})
+}
+
+func usarOriginais(t *testing.T) {
+	config.Configuracao.Diretorios.Log = t.TempDir()
+	originais.carregado = false
+	originais.porArquivo = make(map[string]impressaoOriginal)
+	originais.porConteudo = make(map[string]impressaoOriginal)
+	t.Cleanup(func() {
+		config.Configuracao.Diretorios.Log = ""
+		originais.carregado = false
+		originais.porArquivo = make(map[string]impressaoOriginal)
+		originais.porConteudo = make(map[string]impressaoOriginal)
+	})
+}
+
+func TestSomaConteudo(t *testing.T) {
+	cabecalho := []string{"Id", "Titulo"}
+	dados := []string{"1", "Impressora"}
+
+	if c := conteudoDe("stef", cabecalho); c != "" {
+		t.Errorf("aba só com cabeçalho gerou conteúdo %s", c)
+	}
+	if c := conteudoDe("stef", cabecalho, []string{"", " "}); c != "" {
+		t.Errorf("aba com cabeçalho e linha vazia gerou conteúdo %s", c)
+	}
+	if conteudoDe("stef", cabecalho, dados) == conteudoDe("abb", cabecalho, dados) {
+		t.Error("mesmos dados de empresas diferentes deram o mesmo conteúdo")
+	}
+	if conteudoDe("stef", cabecalho, dados) != conteudoDe(" STEF", cabecalho, append(dados, "", "")) {
+		t.Error("células vazias no fim ou caixa da empresa mudaram o conteúdo")
+	}
+}
+
+func TestReservarImpressao(t *testing.T) {
+	usarOriginais(t)
+	cabecalho := []string{"Id", "Titulo"}
+	dados := []string{"1", "Impressora"}
+
+	casos := []struct {
+		nome      string
+		imp       impressaoArquivo
+		duplicado bool
+	}{
+		{"primeiro", impressaoArquivo{Arquivo: "a1", Conteudo: conteudoDe("stef", cabecalho, dados)}, false},
+		{"salvo de novo", impressaoArquivo{Arquivo: "a2", Conteudo: conteudoDe("stef", cabecalho, dados)}, true},
+		{"mesmo arquivo", impressaoArquivo{Arquivo: "a1"}, true},
+		{"outra empresa", impressaoArquivo{Arquivo: "a3", Conteudo: conteudoDe("abb", cabecalho, dados)}, false},
+		{"vazio stef", impressaoArquivo{Arquivo: "a4", Conteudo: conteudoDe("stef", cabecalho)}, false},
+		{"vazio abb", impressaoArquivo{Arquivo: "a5", Conteudo: conteudoDe("abb", cabecalho)}, false},
+		{"vazio de outro mês", impressaoArquivo{Arquivo: "a6", Conteudo: conteudoDe("stef", cabecalho)}, false},
+	}
+	for _, c := range casos {
+		original, tipo := reservarImpressao(tarefa{Arquivo: c.nome + ".xlsx"}, c.imp)
+		if (original != nil) != c.duplicado {
+			t.Errorf("%s: duplicado = %v (%s), esperado %v", c.nome, original != nil, tipo, c.duplicado)
+		}
+		if original == nil {
+			confirmarImpressao(c.imp, registroLedger{Arquivo: c.nome + ".xlsx", Status: eventoSucesso})
+		}
+	}
+}
+
+func TestReservarImpressaoEsperaOOriginal(t *testing.T) {
+	usarOriginais(t)
+	imp := impressaoArquivo{Arquivo: "a1", Conteudo: "c1"}
+	if original, _ := reservarImpressao(tarefa{Arquivo: "primeira.xlsx"}, imp); original != nil {
+		t.Fatal("primeira cópia tratada como duplicada")
+	}
+
+	segunda := func() chan *registroLedger {
+		resultado := make(chan *registroLedger)
+		go func() {
+			original, _ := reservarImpressao(tarefa{Arquivo: "segunda.xlsx"}, impressaoArquivo{Arquivo: "a2", Conteudo: "c1"})
+			resultado <- original
+		}()
+		return resultado
+	}
+
+	// A primeira cópia falha: a segunda não é duplicada e fica com a reserva.
+	resultado := segunda()
+	select {
+	case <-resultado:
+		t.Fatal("segunda cópia decidida antes do resultado da primeira")
+	case <-time.After(50 * time.Millisecond):
+	}
+	liberarImpressao(imp)
+	if original := <-resultado; original != nil {
+		t.Fatalf("segunda cópia duplicada de %s, que falhou", original.Arquivo)
+	}
+
+	// A segunda é importada: uma terceira cópia é duplicada dela.
+	confirmarImpressao(impressaoArquivo{Arquivo: "a2", Conteudo: "c1"}, registroLedger{Arquivo: "segunda.xlsx", Chave: "chamados", Status: eventoSucesso})
+	original, tipo := reservarImpressao(tarefa{Arquivo: "terceira.xlsx"}, impressaoArquivo{Arquivo: "a3", Conteudo: "c1"})
+	if original == nil || original.Arquivo != "segunda.xlsx" || original.Chave != "chamados" {
+		t.Errorf("terceira cópia: original %+v (%s), esperado segunda.xlsx", original, tipo)
+	}
+}
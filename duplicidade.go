@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	politicaPrimeira = "primeira"
+	politicaUltima   = "ultima"
+	politicaFalhar   = "falhar"
+)
+
+// politicaDuplicidade decide o que fazer com linhas de mesma chave de negócio
+// (colunas com Chave = "s" no metadado) de um agrupador: primeira mantém a
+// primeira linha, ultima a de maior data na coluna (o Para) informada, com
+// empate ficando a mais recente, e falhar recusa o arquivo. Vale dentro do
+// arquivo e entre arquivos da mesma empresa e período capturado do nome; entre
+// arquivos, ultima age como primeira, porque a linha do arquivo anterior já
+// está no CSV dele e não pode ser substituída.
+type politicaDuplicidade struct {
+	Politica string `json:"politica"`
+	Coluna   string `json:"coluna"`
+}
+
+// decisaoDuplicidade é uma linha descartada, substituída ou recusada.
+type decisaoDuplicidade struct {
+	Linha int
+	Chave string
+	Texto string
+}
+
+// chaveCarregada é a última ocorrência de uma chave nos arquivos do período.
+type chaveCarregada struct {
+	Arquivo string    `json:"arquivo"`
+	Linha   int       `json:"linha"`
+	Data    time.Time `json:"data,omitempty"`
+}
+
+// chavesPendentes são as chaves de um arquivo a acrescentar ao índice do
+// período. Só são gravadas quando o arquivo é importado, para um arquivo
+// recusado não bloquear o reenvio corrigido.
+type chavesPendentes struct {
+	caminho string
+	chaves  map[string]chaveCarregada
+}
+
+var duplicidadeMu sync.Mutex
+
+func arquivoIndiceDuplicidade(nomeAgrupador string, nomeEmpresa string, nomesheet string, periodo string) string {
+	nome := caracteresInvalidos.ReplaceAllString(strings.ToLower(strings.Join([]string{"chaves", nomeAgrupador, strings.TrimSuffix(nomesheet, "_"), periodo}, "_")), "_")
+	return fmt.Sprintf("%s\\%s.json", criarPasta(config.Configuracao.Diretorios.Indices, nomeEmpresa), nome)
+}
+
+func lerIndiceDuplicidade(caminho string) (map[string]chaveCarregada, error) {
+	carregadas := make(map[string]chaveCarregada)
+	dat, err := ioutil.ReadFile(caminho)
+	if os.IsNotExist(err) {
+		return carregadas, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(dat, &carregadas); err != nil {
+		return nil, fmt.Errorf("índice %s: %s", caminho, err.Error())
+	}
+	return carregadas, nil
+}
+
+// gravar acrescenta as chaves ao índice do período.
+func (p *chavesPendentes) gravar() error {
+	duplicidadeMu.Lock()
+	defer duplicidadeMu.Unlock()
+	carregadas, err := lerIndiceDuplicidade(p.caminho)
+	if err != nil {
+		return err
+	}
+	for k, c := range p.chaves {
+		carregadas[k] = c
+	}
+	dat, err := json.Marshal(carregadas)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p.caminho, dat, 0644)
+}
+
+// valorData interpreta datas como o Excel grava (número de dias) ou como texto.
+func valorData(valor string) (time.Time, bool) {
+	valor = strings.TrimSpace(valor)
+	if f, err := strconv.ParseFloat(valor, 64); err == nil {
+		return time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).Add(time.Duration(f * float64(24*time.Hour))), true
+	}
+	for _, layout := range []string{"02/01/2006 15:04:05", "02/01/2006 15:04", "02/01/2006", "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if d, err := time.Parse(layout, valor); err == nil {
+			return d, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// deduplicarPlan aplica a política às linhas de plan (a primeira é o
+// cabeçalho). arquivo identifica o arquivo no índice do período, para o
+// reprocessamento do mesmo arquivo não ser tratado como duplicidade. Com
+// falhar, recusar indica que há chaves repetidas (nas decisões). As chaves do
+// arquivo voltam em pendentes, para gravar no índice se ele for importado.
+func deduplicarPlan(plan [][]string, p politicaDuplicidade, arquivo string, emp []string, nomesheet string, periodo string) (saida [][]string, decisoes []decisaoDuplicidade, pendentes *chavesPendentes, recusar bool, err error) {
+	chaves := colunasChave(plan[0], emp[1], emp[0], nomesheet)
+	if len(chaves) == 0 {
+		avisarSemChave("Duplicidade", emp[0], emp[1], nomesheet, "")
+		return plan, nil, nil, false, nil
+	}
+	colData := -1
+	if p.Politica == politicaUltima {
+		for j, c := range plan[0] {
+			if strings.EqualFold(c, p.Coluna) {
+				colData = j
+			}
+		}
+		if colData < 0 {
+			return nil, nil, nil, false, fmt.Errorf("coluna de data %q da política de duplicidade não está na saída", p.Coluna)
+		}
+	}
+
+	type ocorrencia struct {
+		pos, linha int
+		data       time.Time
+	}
+	vistas := make(map[string]ocorrencia)
+	dataDa := func(linha []string, numLinha int) time.Time {
+		if colData < 0 || colData >= len(linha) {
+			return time.Time{}
+		}
+		d, ok := valorData(linha[colData])
+		if !ok && strings.TrimSpace(linha[colData]) != "" {
+			decisoes = append(decisoes, decisaoDuplicidade{Linha: numLinha, Texto: fmt.Sprintf("data %q não reconhecida, tratada como a mais antiga", linha[colData])})
+		}
+		return d
+	}
+
+	saida = [][]string{plan[0]}
+	for i, linha := range plan[1:] {
+		numLinha := i + 2
+		var valores []string
+		for _, j := range chaves {
+			if j < len(linha) {
+				valores = append(valores, strings.TrimSpace(linha[j]))
+			}
+		}
+		k := strings.Join(valores, "\x1f")
+		if strings.Trim(k, "\x1f") == "" {
+			saida = append(saida, linha)
+			continue
+		}
+		atual := ocorrencia{pos: len(saida), linha: numLinha, data: dataDa(linha, numLinha)}
+		anterior, repetida := vistas[k]
+		switch {
+		case !repetida:
+			vistas[k] = atual
+			saida = append(saida, linha)
+		case p.Politica == politicaFalhar:
+			recusar = true
+			decisoes = append(decisoes, decisaoDuplicidade{numLinha, k, fmt.Sprintf("chave repetida da linha %d", anterior.linha)})
+		case p.Politica == politicaUltima && !atual.data.Before(anterior.data):
+			atual.pos = anterior.pos
+			vistas[k] = atual
+			saida[anterior.pos] = linha
+			decisoes = append(decisoes, decisaoDuplicidade{numLinha, k, fmt.Sprintf("mantida; substitui a linha %d", anterior.linha)})
+		default:
+			decisoes = append(decisoes, decisaoDuplicidade{numLinha, k, fmt.Sprintf("descartada; mantida a linha %d", anterior.linha)})
+		}
+	}
+	if recusar || periodo == "" {
+		return saida, decisoes, nil, recusar, nil
+	}
+
+	// Entre arquivos da mesma empresa e período.
+	duplicidadeMu.Lock()
+	defer duplicidadeMu.Unlock()
+	caminho := arquivoIndiceDuplicidade(emp[1], emp[0], nomesheet, periodo)
+	carregadas, err := lerIndiceDuplicidade(caminho)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+
+	descartar := make(map[int]bool)
+	for k, o := range vistas {
+		c, ok := carregadas[k]
+		if !ok || strings.EqualFold(c.Arquivo, arquivo) {
+			continue
+		}
+		switch {
+		case p.Politica == politicaFalhar:
+			recusar = true
+			decisoes = append(decisoes, decisaoDuplicidade{o.linha, k, fmt.Sprintf("chave já carregada por %s (linha %d)", c.Arquivo, c.Linha)})
+		default:
+			texto := fmt.Sprintf("descartada; chave já carregada por %s (linha %d)", c.Arquivo, c.Linha)
+			if p.Politica == politicaUltima && o.data.After(c.Data) {
+				texto += "; ultima só substitui linhas do mesmo arquivo"
+			}
+			descartar[o.pos] = true
+			delete(vistas, k)
+			decisoes = append(decisoes, decisaoDuplicidade{o.linha, k, texto})
+		}
+	}
+	if recusar {
+		return saida, decisoes, nil, true, nil
+	}
+	if len(descartar) > 0 {
+		filtrada := saida[:0:0]
+		for i, linha := range saida {
+			if !descartar[i] {
+				filtrada = append(filtrada, linha)
+			}
+		}
+		saida = filtrada
+	}
+
+	pendentes = &chavesPendentes{caminho: caminho, chaves: make(map[string]chaveCarregada)}
+	for k, o := range vistas {
+		pendentes.chaves[k] = chaveCarregada{Arquivo: arquivo, Linha: o.linha, Data: o.data}
+	}
+	return saida, decisoes, pendentes, false, nil
+}
+
+// deduplicarAba aplica a política do agrupador à aba já convertida. As
+// decisões vão para <arquivo>_duplicidades.log no diretório de log (o .log do
+// arquivo só recebe a recusa, que faz o arquivo ir para PlanilhasComErro). As
+// chaves pendentes são gravadas por concluirImportacao.
+func deduplicarAba(logger *log.Logger, relatorio *relatorioErro, plan [][]string, arq []string, nomeSheet string, emp []string, t tarefa) ([][]string, *chavesPendentes) {
+	p, ok := config.Configuracao.Duplicidade[emp[1]]
+	if !ok || len(plan) < 2 {
+		return plan, nil
+	}
+	p.Politica = strings.ToLower(p.Politica)
+	periodo := capturarNomeArquivo(arq[2], arq[1]).Periodo()
+	saida, decisoes, pendentes, recusar, err := deduplicarPlan(plan, p, caminhoNaPasta(t.Pasta, arq[1]), emp, nomeSheet, periodo)
+	if err != nil {
+		logger.Println(fmt.Sprintf("[plan: %s] - Erro na verificação de duplicidade: %s", nomeSheet, err.Error()))
+		relatorio.erroArquivo(fmt.Sprintf("%s: erro na verificação de chaves duplicadas: %s", nomeSheet, err.Error()))
+		return plan, nil
+	}
+	if len(decisoes) == 0 {
+		return saida, pendentes
+	}
+	sort.SliceStable(decisoes, func(i, j int) bool { return decisoes[i].Linha < decisoes[j].Linha })
+
+	nome := strings.Split(arq[1], ".xls")[0]
+	f, errF := os.OpenFile(fmt.Sprintf("%s\\%s_duplicidades.log", criarPasta(config.Configuracao.Diretorios.Log, t.Pasta), nome), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if errF != nil {
+		fmt.Println("Erro ao gravar as decisões de duplicidade - ", errF.Error())
+	} else {
+		decisao := log.New(f, "", log.Ldate+log.Ltime)
+		for _, d := range decisoes {
+			decisao.Println(fmt.Sprintf("[plan: %s] [%s] linha %d, chave %q: %s", nomeSheet, p.Politica, d.Linha, exibirChave(d.Chave), d.Texto))
+		}
+		f.Close()
+	}
+	fmt.Println(fmt.Sprintf("Duplicidade %s/%s: %d decisão(ões), %d linha(s) na saída", arq[1], nomeSheet, len(decisoes), len(saida)-1))
+
+	if recusar {
+		for _, d := range decisoes {
+			logger.Println(fmt.Sprintf("[plan: %s] - Linha %d: chave %q duplicada (%s).", nomeSheet, d.Linha, exibirChave(d.Chave), d.Texto))
+			relatorio.problemaLinha(nomeSheet, d.Linha, "", fmt.Sprintf("Chave %q duplicada: %s.", exibirChave(d.Chave), d.Texto))
+		}
+	}
+	return saida, pendentes
+}
+
+// exibirChave mostra as partes da chave separadas por " | ".
+func exibirChave(k string) string {
+	return strings.Replace(k, "\x1f", " | ", -1)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// configurarDuplicidade usa "id" como chave do agrupador "chamados" e grava
+// os índices num diretório temporário.
+func configurarDuplicidade(t *testing.T) {
+	est = map[string][]*dicionario{
+		"chamados": {
+			{Sheet: "plan1", De: "id", Para: "id", Empresa: "stef", Chave: "s"},
+			{Sheet: "plan1", De: "data", Para: "data", Empresa: "stef"},
+		},
+	}
+	config.Configuracao.Diretorios.Indices = t.TempDir()
+	t.Cleanup(func() {
+		est = nil
+		config.Configuracao.Diretorios.Indices = ""
+	})
+}
+
+var empDuplicidade = []string{"stef", "chamados"}
+
+func idsDe(plan [][]string) string {
+	var ids []string
+	for _, linha := range plan[1:] {
+		ids = append(ids, linha[0]+"@"+linha[1])
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestDeduplicarPlanNoArquivo(t *testing.T) {
+	configurarDuplicidade(t)
+	plan := [][]string{
+		{"id", "data"},
+		{"1", "01/01/2016"},
+		{"2", "01/01/2016"},
+		{"1", "05/01/2016"},
+		{"1", "03/01/2016"},
+		{"", "sem chave"},
+		{"", "sem chave"},
+	}
+	casos := []struct {
+		politica politicaDuplicidade
+		ids      string
+		recusar  bool
+	}{
+		{politicaDuplicidade{Politica: politicaPrimeira}, "1@01/01/2016,2@01/01/2016,@sem chave,@sem chave", false},
+		{politicaDuplicidade{Politica: politicaUltima, Coluna: "data"}, "1@05/01/2016,2@01/01/2016,@sem chave,@sem chave", false},
+		{politicaDuplicidade{Politica: politicaFalhar}, "1@01/01/2016,2@01/01/2016,@sem chave,@sem chave", true},
+	}
+	for _, c := range casos {
+		saida, decisoes, _, recusar, err := deduplicarPlan(plan, c.politica, "chamados.xlsx", empDuplicidade, "Plan1_", "")
+		if err != nil {
+			t.Fatalf("%s: %v", c.politica.Politica, err)
+		}
+		if ids := idsDe(saida); ids != c.ids {
+			t.Errorf("%s: saída %s, esperado %s", c.politica.Politica, ids, c.ids)
+		}
+		if recusar != c.recusar {
+			t.Errorf("%s: recusar = %v, esperado %v", c.politica.Politica, recusar, c.recusar)
+		}
+		if len(decisoes) != 2 {
+			t.Errorf("%s: %d decisão(ões), esperado 2", c.politica.Politica, len(decisoes))
+		}
+	}
+}
+
+func TestDeduplicarPlanColunaDeDataAusente(t *testing.T) {
+	configurarDuplicidade(t)
+	_, _, _, _, err := deduplicarPlan([][]string{{"id", "data"}, {"1", "x"}}, politicaDuplicidade{Politica: politicaUltima, Coluna: "alterado"}, "a.xlsx", empDuplicidade, "Plan1_", "")
+	if err == nil {
+		t.Error("ultima sem a coluna de data na saída não deu erro")
+	}
+}
+
+func TestDeduplicarPlanEntreArquivos(t *testing.T) {
+	configurarDuplicidade(t)
+	ultima := politicaDuplicidade{Politica: politicaUltima, Coluna: "data"}
+
+	primeiro := [][]string{{"id", "data"}, {"1", "01/01/2016"}, {"2", "01/01/2016"}}
+	saida, _, pendentes, _, err := deduplicarPlan(primeiro, ultima, "a.xlsx", empDuplicidade, "Plan1_", "2016-01")
+	if err != nil || len(saida) != 3 {
+		t.Fatalf("primeiro arquivo: %d linha(s), %v", len(saida), err)
+	}
+
+	// Antes de gravar as pendentes (arquivo ainda não importado) nada é duplicado.
+	segundo := [][]string{{"id", "data"}, {"1", "10/01/2016"}, {"3", "10/01/2016"}}
+	if saida, _, _, _, _ := deduplicarPlan(segundo, ultima, "b.xlsx", empDuplicidade, "Plan1_", "2016-01"); len(saida) != 3 {
+		t.Errorf("chaves de um arquivo não importado bloquearam %d linha(s)", 3-len(saida))
+	}
+
+	if err := pendentes.gravar(); err != nil {
+		t.Fatal(err)
+	}
+	// A linha 1 do primeiro arquivo já está no CSV dele: mesmo mais recente,
+	// a do segundo é descartada, senão o Qlik carregaria as duas.
+	saida, decisoes, pendentes, _, err := deduplicarPlan(segundo, ultima, "b.xlsx", empDuplicidade, "Plan1_", "2016-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := idsDe(saida); ids != "3@10/01/2016" {
+		t.Errorf("segundo arquivo: saída %s, esperado 3@10/01/2016", ids)
+	}
+	if len(decisoes) != 1 || !strings.Contains(decisoes[0].Texto, "a.xlsx") {
+		t.Errorf("decisões = %v", decisoes)
+	}
+	if _, ok := pendentes.chaves["1"]; ok {
+		t.Error("a chave descartada foi para o índice em nome do segundo arquivo")
+	}
+
+	// O reprocessamento do próprio arquivo não é duplicidade.
+	if saida, _, _, _, _ := deduplicarPlan(primeiro, ultima, "A.xlsx", empDuplicidade, "Plan1_", "2016-01"); len(saida) != 3 {
+		t.Errorf("reprocessamento do mesmo arquivo: %d linha(s), esperado 3", len(saida))
+	}
+	// Outro período não conflita.
+	if saida, _, _, _, _ := deduplicarPlan(segundo, ultima, "b.xlsx", empDuplicidade, "Plan1_", "2016-02"); len(saida) != 3 {
+		t.Errorf("outro período: %d linha(s), esperado 3", len(saida))
+	}
+}
+
+func TestDeduplicarPlanFalharEntreArquivos(t *testing.T) {
+	configurarDuplicidade(t)
+	falhar := politicaDuplicidade{Politica: politicaFalhar}
+	_, _, pendentes, _, _ := deduplicarPlan([][]string{{"id", "data"}, {"1", "x"}}, falhar, "a.xlsx", empDuplicidade, "Plan1_", "2016-01")
+	if err := pendentes.gravar(); err != nil {
+		t.Fatal(err)
+	}
+	_, _, pendentes, recusar, _ := deduplicarPlan([][]string{{"id", "data"}, {"1", "y"}}, falhar, "b.xlsx", empDuplicidade, "Plan1_", "2016-01")
+	if !recusar || pendentes != nil {
+		t.Errorf("recusar = %v, pendentes = %v; esperado true, nil", recusar, pendentes)
+	}
+}
+
+func TestValorData(t *testing.T) {
+	casos := []struct {
+		valor    string
+		esperado time.Time
+		ok       bool
+	}{
+		{"42370", time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"05/01/2016", time.Date(2016, 1, 5, 0, 0, 0, 0, time.UTC), true},
+		{"2016-01-05 10:30:00", time.Date(2016, 1, 5, 10, 30, 0, 0, time.UTC), true},
+		{" 2016-01-05 ", time.Date(2016, 1, 5, 0, 0, 0, 0, time.UTC), true},
+		{"ontem", time.Time{}, false},
+	}
+	for _, c := range casos {
+		d, ok := valorData(c.valor)
+		if ok != c.ok || !d.Equal(c.esperado) {
+			t.Errorf("valorData(%q) = %v, %v; esperado %v, %v", c.valor, d, ok, c.esperado, c.ok)
+		}
+	}
+}
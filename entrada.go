@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// entradaconfig controla como as subpastas de PlanilhasAImportar são tratadas.
+// Com recursiva, o arquivo mantém a pasta relativa durante o processamento e
+// vai para a mesma subpasta em PlanilhasImportadas, PlanilhasComErro etc. Sem
+// ela, os arquivos das subpastas são movidos para a raiz, como antes.
+// Com empresapelapasta, a primeira pasta é o nome da empresa e só as entradas
+// do metadado dessa empresa são consideradas para o arquivo.
+//
+// Incluir e excluir são globs (ex.: "*.xlsx", "teste*") comparados com o nome
+// do arquivo e com o caminho relativo; com incluir vazio tudo é incluído.
+// Travas do Office, arquivos de sistema, temporários e ocultos são sempre
+// ignorados. Um arquivo alterado há menos de estabilidade só entra quando o
+// tamanho e a data se repetirem na passada seguinte.
+//
+// Com duplicados, um arquivo idêntico ou com o mesmo conteúdo de uma carga
+// anterior vai para PlanilhasDuplicadas em vez de ser importado de novo.
+type entradaconfig struct {
+	Recursiva        bool     `json:"recursiva"`
+	EmpresaPelaPasta bool     `json:"empresapelapasta"`
+	Incluir          []string `json:"incluir"`
+	Excluir          []string `json:"excluir"`
+	Estabilidade     duracao  `json:"estabilidade"`
+	Duplicados       bool     `json:"duplicados"`
+	LimiteMembro     tamanho  `json:"limitemembro"` // maior planilha extraída de um pacote
+	LimitePacote     tamanho  `json:"limitepacote"` // soma das planilhas extraídas de um pacote
+}
+
+// ignoradoPorPadrao reconhece travas do Office (~$x.xlsx, .~lock.x#),
+// arquivos de sistema, cópias parciais e arquivos ocultos (.x).
+func ignoradoPorPadrao(nome string) bool {
+	nome = strings.ToLower(nome)
+	switch nome {
+	case "thumbs.db", "desktop.ini":
+		return true
+	}
+	for _, prefixo := range []string{"~$", "."} {
+		if strings.HasPrefix(nome, prefixo) {
+			return true
+		}
+	}
+	for _, sufixo := range []string{".tmp", ".temp", ".part", ".crdownload"} {
+		if strings.HasSuffix(nome, sufixo) {
+			return true
+		}
+	}
+	return false
+}
+
+func casaAlgum(padroes []string, rel string, nome string) bool {
+	for _, p := range padroes {
+		p = strings.ToLower(p)
+		if ok, _ := filepath.Match(p, nome); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, strings.ToLower(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type observacaoArquivo struct {
+	tamanho    int64
+	modificado time.Time
+}
+
+// observados guarda tamanho e data dos arquivos recentes vistos na passada
+// anterior e observadosNaPassada os da passada atual. Só o walker usa.
+var (
+	observados          = make(map[string]observacaoArquivo)
+	observadosNaPassada = make(map[string]observacaoArquivo)
+)
+
+func tamanhoEstavel(caminho string, info os.FileInfo) bool {
+	if time.Since(info.ModTime()) >= config.Configuracao.Entrada.Estabilidade.Duration {
+		return true
+	}
+	atual := observacaoArquivo{info.Size(), info.ModTime()}
+	if anterior, visto := observados[caminho]; visto && anterior == atual {
+		return true
+	}
+	observadosNaPassada[caminho] = atual
+	return false
+}
+
+// encerrarObservacao é chamado ao fim de cada passada do walker: só os
+// arquivos ainda em gravação seguem observados, e os aceitos ou que sumiram
+// da entrada saem do mapa.
+func encerrarObservacao() {
+	observados, observadosNaPassada = observadosNaPassada, make(map[string]observacaoArquivo)
+}
+
+// aceitarArquivo aplica as regras de entrada ao arquivo do walker. Arquivos
+// ainda em gravação ficam para a próxima passada.
+func aceitarArquivo(caminho string, rel string, info os.FileInfo) bool {
+	nome := strings.ToLower(info.Name())
+	if ignoradoPorPadrao(nome) || arquivoOculto(info) {
+		return false
+	}
+	e := config.Configuracao.Entrada
+	if casaAlgum(e.Excluir, rel, nome) || (len(e.Incluir) > 0 && !casaAlgum(e.Incluir, rel, nome)) {
+		return false
+	}
+	if arquivoEmUso(caminho) || !tamanhoEstavel(caminho, info) {
+		fmt.Println("Aguardando o fim da gravação:", rel)
+		return false
+	}
+	return true
+}
+
+// tarefa é um arquivo da fila de processamento. Pasta é relativa a
+// PlanilhasAImportar e fica vazia para os arquivos da raiz. Pacote é o
+// arquivo compactado de onde a planilha foi extraída (ver pacote.go); nesse
+// caso ela está em diretorios.extracao.
+type tarefa struct {
+	Arquivo string
+	Pasta   string
+	Pacote  string
+}
+
+// origem é o diretório onde o arquivo da tarefa está.
+func (t tarefa) origem() string {
+	if t.Pacote != "" {
+		return naPasta(config.Configuracao.Diretorios.Extracao, t.Pasta)
+	}
+	return naPasta(config.Configuracao.Diretorios.PlanilhasAImportar, t.Pasta)
+}
+
+// naPasta devolve dir\pasta.
+func naPasta(dir string, pasta string) string {
+	if pasta == "" {
+		return dir
+	}
+	return dir + "\\" + pasta
+}
+
+// criarPasta é naPasta criando a subpasta, para os destinos.
+func criarPasta(dir string, pasta string) string {
+	dir = naPasta(dir, pasta)
+	if pasta != "" {
+		os.MkdirAll(dir, os.ModeType)
+	}
+	return dir
+}
+
+// caminhoNaPasta junta a pasta relativa e o nome do arquivo, para exibição e
+// para diferenciar arquivos de mesmo nome em pastas diferentes.
+func caminhoNaPasta(pasta string, nome string) string {
+	if pasta == "" {
+		return nome
+	}
+	return pasta + "\\" + nome
+}
+
+// separarPasta divide um caminho relativo em pasta e nome do arquivo.
+func separarPasta(caminho string) (string, string) {
+	pasta := filepath.Dir(caminho)
+	if pasta == "." {
+		pasta = ""
+	}
+	return pasta, filepath.Base(caminho)
+}
+
+func empresaDaPasta(pasta string) string {
+	if !config.Configuracao.Entrada.EmpresaPelaPasta || pasta == "" {
+		return ""
+	}
+	return strings.ToLower(strings.FieldsFunc(pasta, func(r rune) bool { return r == '\\' || r == '/' })[0])
+}
+
+// daEmpresa mantém só os candidatos cujas abas são da empresa informada.
+func daEmpresa(candidatos []padraoArquivo, empresa string) []padraoArquivo {
+	var r []padraoArquivo
+	for _, c := range candidatos {
+		if emp, ok := dic[dicArquivo[c.Chave]]; ok && strings.EqualFold(emp[0], empresa) {
+			r = append(r, c)
+		}
+	}
+	return r
+}
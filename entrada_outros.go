@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "os"
+
+// Fora do Windows ocultos são só os arquivos com ".", tratados em
+// ignoradoPorPadrao, e não há trava obrigatória para detectar.
+func arquivoOculto(info os.FileInfo) bool {
+	return false
+}
+
+func arquivoEmUso(caminho string) bool {
+	return false
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIgnoradoPorPadrao(t *testing.T) {
+	casos := []struct {
+		nome    string
+		ignorar bool
+	}{
+		{"~$chamados.xlsx", true},
+		{".~lock.chamados.xlsx#", true},
+		{".oculto.xlsx", true},
+		{"Thumbs.db", true},
+		{"desktop.ini", true},
+		{"chamados.xlsx.tmp", true},
+		{"chamados.TEMP", true},
+		{"chamados.xlsx.part", true},
+		{"chamados.xlsx.crdownload", true},
+		{"chamados.xlsx", false},
+		{"chamados~$.xlsx", false},
+		{"temp.xlsx", false},
+	}
+	for _, c := range casos {
+		if r := ignoradoPorPadrao(c.nome); r != c.ignorar {
+			t.Errorf("ignoradoPorPadrao(%q) = %v, esperado %v", c.nome, r, c.ignorar)
+		}
+	}
+}
+
+func TestCasaAlgum(t *testing.T) {
+	casos := []struct {
+		padroes   []string
+		rel, nome string
+		casa      bool
+	}{
+		{[]string{"*.xlsx"}, "chamados.xlsx", "chamados.xlsx", true},
+		{[]string{"*.XLSX"}, "Chamados.xlsx", "chamados.xlsx", true},
+		{[]string{"*.xls"}, "chamados.xlsx", "chamados.xlsx", false},
+		{[]string{"rascunho*", "*.csv"}, "dados.csv", "dados.csv", true},
+		{[]string{"*.xlsx"}, "norte\\chamados.xlsx", "chamados.xlsx", true},
+		{[]string{"sul\\*.xlsx"}, "norte\\chamados.xlsx", "chamados.xlsx", false},
+		{nil, "chamados.xlsx", "chamados.xlsx", false},
+	}
+	for _, c := range casos {
+		if r := casaAlgum(c.padroes, c.rel, c.nome); r != c.casa {
+			t.Errorf("casaAlgum(%q, %q) = %v, esperado %v", c.padroes, c.rel, r, c.casa)
+		}
+	}
+}
+
+// usarEntrada configura a estabilidade e limpa as observações do walker.
+func usarEntrada(t *testing.T, e entradaconfig) string {
+	config.Configuracao.Entrada = e
+	observados = make(map[string]observacaoArquivo)
+	observadosNaPassada = make(map[string]observacaoArquivo)
+	t.Cleanup(func() {
+		config.Configuracao.Entrada = entradaconfig{}
+		observados = make(map[string]observacaoArquivo)
+		observadosNaPassada = make(map[string]observacaoArquivo)
+	})
+	return t.TempDir()
+}
+
+func gravarEntrada(t *testing.T, caminho string, conteudo string) os.FileInfo {
+	if err := ioutil.WriteFile(caminho, []byte(conteudo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(caminho)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestAceitarArquivoIncluirExcluir(t *testing.T) {
+	dir := usarEntrada(t, entradaconfig{})
+	casos := []struct {
+		incluir, excluir []string
+		nome             string
+		aceito           bool
+	}{
+		{nil, nil, "chamados.xlsx", true},
+		{nil, nil, "~$chamados.xlsx", false},
+		{[]string{"*.xlsx"}, nil, "chamados.xlsx", true},
+		{[]string{"*.xlsx"}, nil, "chamados.xls", false},
+		{nil, []string{"rascunho*"}, "Rascunho chamados.xlsx", false},
+		{[]string{"*.xlsx"}, []string{"*chamados*"}, "chamados.xlsx", false},
+	}
+	for _, c := range casos {
+		config.Configuracao.Entrada.Incluir, config.Configuracao.Entrada.Excluir = c.incluir, c.excluir
+		caminho := filepath.Join(dir, c.nome)
+		info := gravarEntrada(t, caminho, "x")
+		if r := aceitarArquivo(caminho, c.nome, info); r != c.aceito {
+			t.Errorf("incluir %q excluir %q: aceitarArquivo(%q) = %v, esperado %v", c.incluir, c.excluir, c.nome, r, c.aceito)
+		}
+	}
+}
+
+func TestTamanhoEstavel(t *testing.T) {
+	dir := usarEntrada(t, entradaconfig{Estabilidade: duracao{time.Hour}})
+	caminho := filepath.Join(dir, "chamados.xlsx")
+
+	info := gravarEntrada(t, caminho, "parte")
+	if tamanhoEstavel(caminho, info) {
+		t.Fatal("arquivo recente aceito na primeira passada")
+	}
+	encerrarObservacao()
+
+	info = gravarEntrada(t, caminho, "parte maior")
+	if tamanhoEstavel(caminho, info) {
+		t.Fatal("arquivo aceito com o tamanho mudando")
+	}
+	encerrarObservacao()
+
+	if !tamanhoEstavel(caminho, info) {
+		t.Fatal("arquivo não aceito com tamanho e data iguais aos da passada anterior")
+	}
+	encerrarObservacao()
+	if len(observados) != 0 {
+		t.Errorf("arquivo aceito continua observado: %v", observados)
+	}
+
+	antigo := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(caminho, antigo, antigo)
+	info, _ = os.Stat(caminho)
+	if !tamanhoEstavel(caminho, info) {
+		t.Error("arquivo mais antigo que entrada.estabilidade não foi aceito")
+	}
+}
+
+func TestTamanhoEstavelEsqueceArquivosQueSumiram(t *testing.T) {
+	dir := usarEntrada(t, entradaconfig{Estabilidade: duracao{time.Hour}})
+	caminho := filepath.Join(dir, "chamados.xlsx")
+
+	tamanhoEstavel(caminho, gravarEntrada(t, caminho, "parte"))
+	encerrarObservacao()
+	if _, ok := observados[caminho]; !ok {
+		t.Fatal("arquivo em gravação não ficou observado")
+	}
+
+	os.Remove(caminho)
+	encerrarObservacao()
+	if len(observados) != 0 {
+		t.Errorf("arquivo removido continua observado: %v", observados)
+	}
+}
+
+func TestPastasDaEntrada(t *testing.T) {
+	if r := naPasta("Importadas", ""); r != "Importadas" {
+		t.Errorf("naPasta sem pasta = %q", r)
+	}
+	if r := naPasta("Importadas", "norte\\filial"); r != "Importadas\\norte\\filial" {
+		t.Errorf("naPasta = %q", r)
+	}
+	if r := caminhoNaPasta("norte\\filial", "chamados.xlsx"); r != "norte\\filial\\chamados.xlsx" {
+		t.Errorf("caminhoNaPasta = %q", r)
+	}
+	pasta, nome := separarPasta(filepath.Join("norte", "filial", "chamados.xlsx"))
+	if pasta != filepath.Join("norte", "filial") || nome != "chamados.xlsx" {
+		t.Errorf("separarPasta = %q, %q", pasta, nome)
+	}
+	if pasta, _ := separarPasta("chamados.xlsx"); pasta != "" {
+		t.Errorf("separarPasta na raiz = %q, esperado vazio", pasta)
+	}
+}
+
+func TestEmpresaDaPasta(t *testing.T) {
+	casos := []struct {
+		pelaPasta bool
+		pasta     string
+		empresa   string
+	}{
+		{true, "Stef\\filial", "stef"},
+		{true, "Stef/filial", "stef"},
+		{true, "stef", "stef"},
+		{true, "", ""},
+		{false, "stef\\filial", ""},
+	}
+	for _, c := range casos {
+		usarEntrada(t, entradaconfig{EmpresaPelaPasta: c.pelaPasta})
+		if r := empresaDaPasta(c.pasta); r != c.empresa {
+			t.Errorf("empresapelapasta %v: empresaDaPasta(%q) = %q, esperado %q", c.pelaPasta, c.pasta, r, c.empresa)
+		}
+	}
+}
+
+// TestRoteamentoMantemSubpasta confere que, com entrada.recursiva, cada
+// destino recebe o arquivo na mesma subpasta em que ele estava na entrada e
+// que o ledger guarda essa pasta.
+func TestRoteamentoMantemSubpasta(t *testing.T) {
+	const pasta = "norte\\filial"
+	casos := []struct {
+		nome    string
+		rotear  func(t tarefa)
+		destino func() string
+		arquivo string
+		status  string
+	}{
+		{"importada", func(t tarefa) { moverImportado("chamados", t) },
+			func() string { return config.Configuracao.Diretorios.PlanilhasImportadas }, "chamados.xlsx", ""},
+		{"erro", func(t tarefa) {
+			geraArquivoCSV(nil, novoRelatorioErro("chamados.xlsx"), "chamados", "chamados|stef", "", "", t)
+		}, func() string { return config.Configuracao.Diretorios.PlanilhasComErro }, "chamados.xlsx", eventoFalha},
+		{"sem metadado", func(t tarefa) {
+			geraArquivoCSV(nil, novoRelatorioErro("chamados.xlsx"), "chamados", "chamados|stef", "plan1_", "stef", t)
+		}, func() string { return config.Configuracao.Diretorios.PlanilhasSemMetaDado }, "stef_chamados.xlsx", eventoSemMetadado},
+		{"quarentena", func(t tarefa) {
+			colocarEmQuarentena(config.Configuracao.Diretorios.PlanilhasAImportar, t.Pasta, t.Arquivo, []registroLedger{{Arquivo: "chamados", Status: eventoFalha, Causa: causaTransitoria}})
+		}, func() string { return config.Configuracao.Diretorios.PlanilhasQuarentena }, "chamados.xlsx", eventoQuarentena},
+		{"retida", func(t tarefa) { reterArquivo(t, "chamados", "metadado com erro") },
+			func() string { return config.Configuracao.Diretorios.PlanilhasRetidas }, "chamados.xlsx", eventoRetido},
+		{"ambígua", func(t tarefa) { moverAmbiguo(t, nil) },
+			func() string { return config.Configuracao.Diretorios.PlanilhasAmbiguas }, "chamados.xlsx", eventoAmbiguo},
+	}
+	for _, c := range casos {
+		usarEntrada(t, entradaconfig{Recursiva: true})
+		dir := t.TempDir()
+		config.Configuracao.Diretorios = diretorios{
+			PlanilhasAImportar:   filepath.Join(dir, "entrada"),
+			PlanilhasImportadas:  filepath.Join(dir, "importadas"),
+			PlanilhasComErro:     filepath.Join(dir, "erro"),
+			PlanilhasSemMetaDado: filepath.Join(dir, "semmetadado"),
+			PlanilhasQuarentena:  filepath.Join(dir, "quarentena"),
+			PlanilhasRetidas:     filepath.Join(dir, "retidas"),
+			PlanilhasAmbiguas:    filepath.Join(dir, "ambiguas"),
+			Log:                  dir,
+		}
+		t.Cleanup(func() { config.Configuracao.Diretorios = diretorios{} })
+		tr := tarefa{Arquivo: "chamados.xlsx", Pasta: pasta}
+		origem := fmt.Sprintf("%s\\chamados.xlsx", criarPasta(config.Configuracao.Diretorios.PlanilhasAImportar, pasta))
+		gravarEntrada(t, origem, "planilha")
+
+		c.rotear(tr)
+
+		esperado := fmt.Sprintf("%s\\%s\\%s", c.destino(), pasta, c.arquivo)
+		if _, err := os.Stat(esperado); err != nil {
+			t.Errorf("%s: arquivo não está em %s: %v", c.nome, esperado, err)
+		}
+		if _, err := os.Stat(origem); !os.IsNotExist(err) {
+			t.Errorf("%s: arquivo continua na entrada", c.nome)
+		}
+		if c.status == "" {
+			continue
+		}
+		registros, err := lerLedger()
+		if err != nil || len(registros) == 0 {
+			t.Fatalf("%s: ledger %v (%v)", c.nome, registros, err)
+		}
+		if r := registros[len(registros)-1]; r.Status != c.status || r.Pasta != pasta {
+			t.Errorf("%s: registro %+v, esperado %s na pasta %s", c.nome, r, c.status, pasta)
+		}
+	}
+}
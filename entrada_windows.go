@@ -0,0 +1,34 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// errorSharingViolation é o ERROR_SHARING_VIOLATION do Windows.
+const errorSharingViolation = syscall.Errno(32)
+
+// arquivoOculto consulta o atributo oculto do Windows.
+func arquivoOculto(info os.FileInfo) bool {
+	if a, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return a.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+	}
+	return false
+}
+
+// arquivoEmUso tenta abrir o arquivo para escrita: o Excel e a cópia do
+// Explorer o mantêm aberto sem compartilhar a escrita.
+func arquivoEmUso(caminho string) bool {
+	f, err := os.OpenFile(caminho, os.O_RDWR, 0)
+	if err == nil {
+		f.Close()
+		return false
+	}
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err == errorSharingViolation
+	}
+	return false
+}
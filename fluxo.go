@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// saidaAba é o resultado de uma aba convertida. As linhas vão para temp, que
+// só vira o CSV quando o arquivo inteiro deu certo (concluirImportacao); na
+// leitura em fluxo, as abas com política de duplicidade ficam em plan, porque
+// "ultima" pode trocar uma linha já lida.
+type saidaAba struct {
+	nome      string
+	emp       []string
+	origem    []string
+	saida     []string
+	csv       string
+	temp      string
+	plan      [][]string
+	delta     *comparadorDelta
+	chaves    *chavesPendentes
+	conteudo  []byte
+	relatorio *relatorioErro
+	err       error
+}
+
+// interpretarEmFluxo é o interpretarPlanilha dos arquivos grandes: lê as abas
+// em paralelo pelo leitorXlsx, com memória constante por aba, e grava os CSVs
+// enquanto lê.
+func interpretarEmFluxo(logger *log.Logger, relatorio *relatorioErro, logFile *os.File, nomeLog string, arq []string, t tarefa) {
+	nomeArq := fmt.Sprintf("%s\\%s", t.origem(), arq[1])
+	nome := strings.ToLower(strings.Replace(strings.Replace(strings.Replace(arq[1], ".xlsx", "", -1), ".xlsm", "", -1), ".xls", "", -1))
+	defer memoriaEmUso.liberar(memoriaEmUso.reservar(estimativaFluxo(nomeArq)))
+
+	auxemp := empresaDaChave(arq[2])
+
+	leitor, err := abrirLeitorXlsx(nomeArq)
+	if err != nil {
+		if _, leitura := err.(*os.PathError); leitura {
+			logger.Println(fmt.Sprintf("Erro ao ler o arquivo [%s]. %s", nomeArq, err.Error()))
+			relatorio.erroTransitorio("Não foi possível ler o arquivo. Uma nova tentativa será feita automaticamente.")
+		} else {
+			logger.Println(fmt.Sprintf("Erro ao abrir o arquivo [%s]. \n O Arquivo não esta no formato correto. %s", nomeArq, err.Error()))
+			relatorio.erroArquivo("O arquivo não esta no formato xlsx. Salve a planilha no formato xlsx e envie novamente.")
+		}
+		geraArquivoCSV(logger, relatorio, nome, arq[2], "", auxemp, t)
+		return
+	}
+
+	fmt.Println(fmt.Sprintf("%s: leitura em fluxo de %d aba(s)", arq[1], len(leitor.Abas)))
+	saidas := make([]*saidaAba, len(leitor.Abas))
+	vagas := make(chan struct{}, runtime.NumCPU())
+	var abas sync.WaitGroup
+	for i, aba := range leitor.Abas {
+		abas.Add(1)
+		vagas <- struct{}{}
+		go func(i int, aba abaXlsx) {
+			defer abas.Done()
+			defer func() { <-vagas }()
+			saidas[i] = lerAbaEmFluxo(leitor, aba, arq, t, logger)
+		}(i, aba)
+	}
+	abas.Wait()
+	leitor.Close()
+
+	var conteudo [][]byte
+	lido := true
+	for _, s := range saidas {
+		conteudo = append(conteudo, s.conteudo)
+		relatorio.juntar(s.relatorio)
+		if s.err != nil {
+			lido = false
+			logger.Println(fmt.Sprintf("Erro ao ler a aba %s do arquivo [%s]. %s", s.nome, nomeArq, s.err.Error()))
+			relatorio.erroArquivo(fmt.Sprintf("%s: o arquivo não esta no formato correto. %s", s.nome, s.err.Error()))
+		}
+	}
+
+	importado := false
+	var imp impressaoArquivo
+	if lido && config.Configuracao.Entrada.Duplicados {
+		imp = impressaoArquivo{Arquivo: hashArquivo(nomeArq), Conteudo: somaConteudo(auxemp, conteudo)}
+		if original, tipo := reservarImpressao(t, imp); original != nil {
+			descartarSaidas(saidas)
+			logFile.Close()
+			os.Remove(nomeLog)
+			moverDuplicado(t, imp, original, tipo)
+			return
+		}
+		defer func() {
+			if !importado {
+				liberarImpressao(imp)
+			}
+		}()
+	}
+
+	if info, err := logFile.Stat(); err == nil && info.Size() > 0 {
+		descartarSaidas(saidas)
+		geraArquivoCSV(logger, relatorio, nome, arq[2], "", auxemp, t)
+		return
+	}
+
+	var abasLedger []abaLedger
+	for _, s := range saidas {
+		if s.plan != nil {
+			g, err := gravarAbaCSV(nome, s.plan, arq[2], s.nome+"_", s.emp[1], s.emp[0])
+			if err != nil {
+				logger.Println(fmt.Sprintf("[plan: %s] - Erro ao gravar o CSV. %s", s.nome, err.Error()))
+				relatorio.erroTransitorio("Não foi possível gravar o CSV. Uma nova tentativa será feita automaticamente.")
+				descartarSaidas(saidas)
+				geraArquivoCSV(logger, relatorio, nome, arq[2], "", auxemp, t)
+				return
+			}
+			s.csv, s.temp, s.delta = g.csv, g.temp, g.delta
+		}
+		if s.temp != "" {
+			abasLedger = append(abasLedger, abaLedger{Sheet: s.nome, Origem: s.origem, Saida: s.saida})
+		}
+	}
+	if len(abasLedger) == 0 {
+		geraArquivoCSV(logger, relatorio, nome, arq[2], "_", auxemp, t)
+		return
+	}
+	importado = concluirImportacao(logger, relatorio, nome, arq, t, saidas, abasLedger, imp)
+}
+
+// lerAbaEmFluxo lê uma aba, validando e gravando cada linha no CSV temporário.
+func lerAbaEmFluxo(leitor *leitorXlsx, aba abaXlsx, arq []string, t tarefa, logger *log.Logger) (s *saidaAba) {
+	s = &saidaAba{nome: aba.Nome, relatorio: novoRelatorioErro(arq[1])}
+	h := novoHashAba(aba.Nome)
+	defer func() {
+		if r := recover(); r != nil {
+			s.err = fmt.Errorf("%v", r)
+		}
+		s.conteudo = h.soma()
+	}()
+
+	emp, ok := dic[arq[2]+"|"+strings.ToLower(aba.Nome)]
+	buffer := false
+	if ok {
+		_, buffer = config.Configuracao.Duplicidade[emp[1]]
+	}
+	nomesheet := aba.Nome + "_"
+	captura := capturarNomeArquivo(arq[2], arq[1])
+	var m *mapeamentoCabecalho
+	var w *escritorCSV
+	var numericas map[int]bool
+	var dialeto dialetoCSV
+
+	var f *os.File
+	defer func() {
+		if f != nil {
+			w.Flush()
+			f.Close()
+			if s.err == nil {
+				s.err = w.Error()
+			}
+		}
+	}()
+
+	s.err = leitor.percorrer(aba, func(numLinha int, valores []string) error {
+		h.linha(valores)
+		if !ok {
+			return nil
+		}
+		if numLinha == 1 {
+			m = mapearCabecalho(emp, aba.Nome, valores)
+			for _, cab := range m.Faltantes {
+				logger.Println(fmt.Sprintf("[plan: %s] - A coluna [%s] é obrigatório e não se encontra na planilha. Verifique o dicionário de dados deste arquivo.\n\r", aba.Nome, cab.De))
+				s.relatorio.colunaFaltante(aba.Nome, cab.De, valores)
+			}
+			if len(m.Faltantes) > 0 {
+				m = nil
+				return nil
+			}
+			s.emp, s.origem, s.saida = emp, valores, m.cabecalho()
+			cabecalho := append(m.cabecalho(), captura.Colunas...)
+			if buffer {
+				s.plan = [][]string{cabecalho}
+				return nil
+			}
+
+			var err error
+			indicesMu.Lock()
+			s.delta, err = novoComparadorDelta(cabecalho, strings.Split(arq[2], "|")[0], emp[1], emp[0], nomesheet, len(captura.Colunas))
+			indicesMu.Unlock()
+			if err != nil {
+				fmt.Println("Erro no delta, carga completa - ", err.Error())
+			}
+			nome := strings.ToLower(strings.Replace(strings.Replace(strings.Replace(arq[1], ".xlsx", "", -1), ".xlsm", "", -1), ".xls", "", -1))
+			s.csv = s.delta.destino(destinoCSV(strings.Split(arq[2], "|")[0], nome, emp[1], nomesheet))
+			s.temp = s.csv + ".parcial"
+			if f, err = os.Create(s.temp); err != nil {
+				return err
+			}
+			dialeto = dialetoDaSaida(emp[1], nomesheet)
+			numericas = colunasNumericas(cabecalho, emp[1], emp[0], nomesheet)
+			w = novoEscritorCSV(f, dialeto)
+			return w.Write(cabecalho)
+		}
+		if m == nil {
+			return nil
+		}
+
+		validarLinha(s.relatorio, aba.Nome, numLinha, valores, m.Mapeadas)
+		linha := m.linha(valores, emp[0], captura)
+		if buffer {
+			s.plan = append(s.plan, linha)
+			return nil
+		}
+		if (s.delta != nil && !s.delta.manter(linha)) || *dialeto.SomenteCabecalho {
+			return nil
+		}
+		formatarNumericas(linha, numericas, dialeto.SeparadorDecimal)
+		return w.Write(linha)
+	})
+
+	if s.err == nil && s.plan != nil {
+		s.plan, s.chaves = deduplicarAba(logger, s.relatorio, s.plan, arq, aba.Nome, emp, t)
+	}
+	return s
+}
+
+// descartarSaidas apaga os CSVs temporários das abas de um arquivo que não
+// foi importado.
+func descartarSaidas(saidas []*saidaAba) {
+	for _, s := range saidas {
+		if s != nil && s.temp != "" {
+			os.Remove(s.temp)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// registroLedger é uma linha do histórico de processamento (Log\ledger.json,
+// um JSON por linha, somente acréscimo).
+type registroLedger struct {
+	Data    time.Time         `json:"data"`
+	Arquivo string            `json:"arquivo"`
+	Pasta   string            `json:"pasta,omitempty"`
+	Chave   string            `json:"chave,omitempty"`
+	Empresa string            `json:"empresa,omitempty"`
+	Status  string            `json:"status"`
+	CSVs    []string          `json:"csvs,omitempty"`
+	Motivos []string          `json:"motivos,omitempty"`
+	Captura map[string]string `json:"captura,omitempty"`
+	Periodo string            `json:"periodo,omitempty"`
+	// Metadado é a versão (hash) do metadado usado; ver retratoMetadado.
+	Metadado string      `json:"metadado,omitempty"`
+	Abas     []abaLedger `json:"abas,omitempty"`
+	// Causa das falhas (metadado, transitoria ou conteudo); ver reprocessamento.go.
+	Causa string `json:"causa,omitempty"`
+	// Pacote é o arquivo compactado (pasta\nome) de onde a planilha veio.
+	Pacote string `json:"pacote,omitempty"`
+	// Hash e HashConteudo são as impressões do arquivo (ver duplicado.go);
+	// DuplicadoDe aponta a carga original de um duplicado.
+	Hash         string `json:"hash,omitempty"`
+	HashConteudo string `json:"hashconteudo,omitempty"`
+	DuplicadoDe  string `json:"duplicadode,omitempty"`
+}
+
+// abaLedger guarda o cabeçalho de origem e o de saída de cada aba convertida,
+// para "metadados diff" saber quais cargas mudariam de esquema.
+type abaLedger struct {
+	Sheet  string   `json:"sheet"`
+	Origem []string `json:"origem"`
+	Saida  []string `json:"saida"`
+}
+
+var ledgerMu sync.Mutex
+
+func arquivoLedger() string {
+	return fmt.Sprintf("%s\\ledger.json", config.Configuracao.Diretorios.Log)
+}
+
+func registrarLedger(r registroLedger) {
+	if r.Data.IsZero() {
+		r.Data = time.Now()
+	}
+	if r.Metadado == "" {
+		r.Metadado = versaoMetadadoAtual
+	}
+	if r.Empresa == "" {
+		if e, ok := email[r.Chave]; ok {
+			r.Empresa = e[0]
+		}
+	}
+	if r.Pacote != "" {
+		anotarMembro(r)
+	}
+	linha, err := json.Marshal(r)
+	if err != nil {
+		fmt.Println("Erro ao registrar no ledger - ", err.Error())
+		return
+	}
+
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	f, err := os.OpenFile(arquivoLedger(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Println("Erro ao registrar no ledger - ", err.Error())
+		return
+	}
+	defer f.Close()
+	f.Write(append(linha, '\n'))
+}
+
+// lerLedger devolve o histórico em ordem de gravação. Linhas inválidas são ignoradas.
+func lerLedger() ([]registroLedger, error) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	f, err := os.Open(arquivoLedger())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var registros []registroLedger
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		var r registroLedger
+		if err := json.Unmarshal(s.Bytes(), &r); err == nil {
+			registros = append(registros, r)
+		}
+	}
+	return registros, s.Err()
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// leitorXlsx lê a pasta de trabalho direto do zip, uma linha por vez, sem
+// montar a planilha inteira na memória como o xlsx.OpenFile. Só as strings
+// compartilhadas ficam carregadas. Os valores são os mesmos de Cell.Value do
+// xlsx: o texto, o número como gravado (datas em dias) e booleano 0/1.
+type leitorXlsx struct {
+	arquivo *zip.ReadCloser
+	partes  map[string]*zip.File
+	textos  []string
+	Abas    []abaXlsx
+}
+
+type abaXlsx struct {
+	Nome  string
+	parte string
+}
+
+func abrirLeitorXlsx(caminho string) (*leitorXlsx, error) {
+	z, err := zip.OpenReader(caminho)
+	if err != nil {
+		return nil, err
+	}
+	l := &leitorXlsx{arquivo: z, partes: make(map[string]*zip.File)}
+	for _, f := range z.File {
+		l.partes[strings.ToLower(f.Name)] = f
+	}
+	if err := l.carregar(); err != nil {
+		z.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *leitorXlsx) Close() error {
+	return l.arquivo.Close()
+}
+
+func (l *leitorXlsx) decodificar(parte string, v interface{}) error {
+	f, ok := l.partes[strings.ToLower(parte)]
+	if !ok {
+		return fmt.Errorf("%s não encontrado no arquivo", parte)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// carregar lê a lista de abas (workbook.xml e os relacionamentos dele) e as
+// strings compartilhadas.
+func (l *leitorXlsx) carregar() error {
+	var pasta struct {
+		Abas []struct {
+			Nome string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := l.decodificar("xl/workbook.xml", &pasta); err != nil {
+		return err
+	}
+	var rels struct {
+		Itens []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := l.decodificar("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return err
+	}
+	alvos := make(map[string]string)
+	for _, r := range rels.Itens {
+		alvo := r.Target
+		if strings.HasPrefix(alvo, "/") {
+			alvo = strings.TrimPrefix(alvo, "/")
+		} else {
+			alvo = path.Join("xl", alvo)
+		}
+		alvos[r.ID] = alvo
+	}
+	for _, a := range pasta.Abas {
+		parte, ok := alvos[a.ID]
+		if !ok {
+			return fmt.Errorf("aba %s sem relacionamento %s", a.Nome, a.ID)
+		}
+		l.Abas = append(l.Abas, abaXlsx{Nome: a.Nome, parte: parte})
+	}
+
+	f, ok := l.partes["xl/sharedstrings.xml"]
+	if !ok {
+		return nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return l.lerTextos(r)
+}
+
+// lerTextos junta os trechos <t> de cada <si>, sem a grafia fonética (<rPh>).
+func (l *leitorXlsx) lerTextos(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	var atual strings.Builder
+	emTexto, emFonetica := false, false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch e := tok.(type) {
+		case xml.StartElement:
+			switch e.Name.Local {
+			case "si":
+				atual.Reset()
+			case "t":
+				emTexto = true
+			case "rPh":
+				emFonetica = true
+			}
+		case xml.EndElement:
+			switch e.Name.Local {
+			case "si":
+				l.textos = append(l.textos, atual.String())
+			case "t":
+				emTexto = false
+			case "rPh":
+				emFonetica = false
+			}
+		case xml.CharData:
+			if emTexto && !emFonetica {
+				atual.Write(e)
+			}
+		}
+	}
+}
+
+// colunaDaReferencia devolve o índice (a partir de 0) da coluna de "AB12".
+func colunaDaReferencia(ref string) int {
+	col := 0
+	for _, c := range strings.ToUpper(ref) {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+	}
+	return col - 1
+}
+
+// percorrer chama f para cada linha da aba, em ordem e a partir de 1, com as
+// células completadas até a largura da aba (<dimension>), como o
+// xlsx.OpenFile. Linhas ausentes no XML são passadas vazias.
+func (l *leitorXlsx) percorrer(aba abaXlsx, f func(numLinha int, valores []string) error) error {
+	parte, ok := l.partes[strings.ToLower(aba.parte)]
+	if !ok {
+		return fmt.Errorf("%s não encontrado no arquivo", aba.parte)
+	}
+	r, err := parte.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dec := xml.NewDecoder(r)
+	largura, proxima := 0, 1
+	var linha []string
+	var valor strings.Builder
+	tipo, col, numLinha := "", 0, 0
+	emValor := false
+	completar := func(valores []string) []string {
+		for len(valores) < largura {
+			valores = append(valores, "")
+		}
+		return valores
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch e := tok.(type) {
+		case xml.StartElement:
+			switch e.Name.Local {
+			case "dimension":
+				for _, a := range e.Attr {
+					if a.Name.Local == "ref" {
+						partes := strings.Split(a.Value, ":")
+						largura = colunaDaReferencia(partes[len(partes)-1]) + 1
+					}
+				}
+			case "row":
+				numLinha = proxima
+				for _, a := range e.Attr {
+					if a.Name.Local == "r" {
+						if n, err := strconv.Atoi(a.Value); err == nil {
+							numLinha = n
+						}
+					}
+				}
+				for ; proxima < numLinha; proxima++ {
+					if err := f(proxima, completar(nil)); err != nil {
+						return err
+					}
+				}
+				linha = nil
+			case "c":
+				tipo, col = "", len(linha)
+				for _, a := range e.Attr {
+					switch a.Name.Local {
+					case "t":
+						tipo = a.Value
+					case "r":
+						col = colunaDaReferencia(a.Value)
+					}
+				}
+				valor.Reset()
+			case "v", "t":
+				emValor = true
+			}
+		case xml.EndElement:
+			switch e.Name.Local {
+			case "v", "t":
+				emValor = false
+			case "c":
+				v := valor.String()
+				if tipo == "s" && v != "" {
+					i, err := strconv.Atoi(v)
+					if err != nil || i < 0 || i >= len(l.textos) {
+						return fmt.Errorf("aba %s: string compartilhada %q inválida", aba.Nome, v)
+					}
+					v = l.textos[i]
+				}
+				for len(linha) < col {
+					linha = append(linha, "")
+				}
+				if col < len(linha) {
+					linha[col] = v
+				} else {
+					linha = append(linha, v)
+				}
+			case "row":
+				if err := f(numLinha, completar(linha)); err != nil {
+					return err
+				}
+				proxima = numLinha + 1
+			}
+		case xml.CharData:
+			if emValor {
+				valor.Write(e)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tealeg/xlsx"
+)
+
+// linhasDoLeitor devolve as linhas de cada aba lidas pelo leitorXlsx.
+func linhasDoLeitor(t *testing.T, caminho string) map[string][][]string {
+	l, err := abrirLeitorXlsx(caminho)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	abas := make(map[string][][]string)
+	for _, aba := range l.Abas {
+		err := l.percorrer(aba, func(numLinha int, valores []string) error {
+			if numLinha != len(abas[aba.Nome])+1 {
+				t.Errorf("aba %s: linha %d fora de ordem", aba.Nome, numLinha)
+			}
+			abas[aba.Nome] = append(abas[aba.Nome], valores)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return abas
+}
+
+func TestLeitorXlsxIgualAoXlsx(t *testing.T) {
+	caminho := filepath.Join(t.TempDir(), "chamados.xlsx")
+	f := xlsx.NewFile()
+	plan1, _ := f.AddSheet("plan1")
+	for _, l := range [][]interface{}{
+		{"Id", "Titulo", "Valor", "Aberto"},
+		{1, "Impressora", 10.5, true},
+		{2, "Rede & VPN <lenta>", -3, false},
+		{3, "", 0.1, true},
+	} {
+		row := plan1.AddRow()
+		for _, v := range l {
+			row.AddCell().SetValue(v)
+		}
+	}
+	outra, _ := f.AddSheet("Resumo")
+	outra.AddRow().AddCell().SetString("Impressora")
+	if err := f.Save(caminho); err != nil {
+		t.Fatal(err)
+	}
+
+	esperado, err := xlsx.OpenFile(caminho)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lido := linhasDoLeitor(t, caminho)
+	if len(lido) != len(esperado.Sheets) {
+		t.Fatalf("%d aba(s), esperado %d", len(lido), len(esperado.Sheets))
+	}
+	for _, sheet := range esperado.Sheets {
+		linhas := lido[sheet.Name]
+		if len(linhas) != len(sheet.Rows) {
+			t.Errorf("aba %s: %d linha(s), esperado %d", sheet.Name, len(linhas), len(sheet.Rows))
+			continue
+		}
+		for i, row := range sheet.Rows {
+			var valores []string
+			for _, c := range row.Cells {
+				valores = append(valores, c.Value)
+			}
+			if strings.Join(linhas[i], "|") != strings.Join(valores, "|") {
+				t.Errorf("aba %s linha %d = %q, esperado %q", sheet.Name, i+1, linhas[i], valores)
+			}
+		}
+	}
+}
+
+// gravarZip monta um xlsx mínimo com as partes dadas.
+func gravarZip(t *testing.T, partes map[string]string) string {
+	caminho := filepath.Join(t.TempDir(), "manual.xlsx")
+	arq, err := os.Create(caminho)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zip.NewWriter(arq)
+	for nome, conteudo := range partes {
+		w, _ := z.Create(nome)
+		w.Write([]byte(conteudo))
+	}
+	z.Close()
+	arq.Close()
+	return caminho
+}
+
+func TestLeitorXlsxLinhasEsparsas(t *testing.T) {
+	caminho := gravarZip(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="plan1" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+			<Relationship Id="rId1" Target="/xl/worksheets/Sheet1.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+			<si><t>Id</t></si>
+			<si><r><t>Títu</t></r><r><t>lo</t></r><rPh><t>ティトゥロ</t></rPh></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+			<dimension ref="A1:C4"/><sheetData>
+			<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>
+			<row r="4"><c r="B4" t="inlineStr"><is><t>texto</t></is></c><c r="C4"><v>42</v></c></row>
+			</sheetData></worksheet>`,
+	})
+
+	linhas := linhasDoLeitor(t, caminho)["plan1"]
+	esperado := []string{"Id||Título", "||", "||", "|texto|42"}
+	if len(linhas) != len(esperado) {
+		t.Fatalf("linhas = %q", linhas)
+	}
+	for i, l := range linhas {
+		if strings.Join(l, "|") != esperado[i] {
+			t.Errorf("linha %d = %q, esperado %q", i+1, l, esperado[i])
+		}
+	}
+}
+
+func TestLeitorXlsxStringCompartilhadaInvalida(t *testing.T) {
+	caminho := gravarZip(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="plan1" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+			<Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="s"><v>7</v></c></row></sheetData></worksheet>`,
+	})
+	l, err := abrirLeitorXlsx(caminho)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	err = l.percorrer(l.Abas[0], func(int, []string) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "string compartilhada") {
+		t.Errorf("erro = %v", err)
+	}
+}
+
+func TestColunaDaReferencia(t *testing.T) {
+	for ref, col := range map[string]int{"A1": 0, "c4": 2, "Z9": 25, "AA10": 26, "AB12": 27} {
+		if c := colunaDaReferencia(ref); c != col {
+			t.Errorf("colunaDaReferencia(%s) = %d, esperado %d", ref, c, col)
+		}
+	}
+}
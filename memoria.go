@@ -0,0 +1,91 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"strings"
+	"sync"
+)
+
+// memoriaconfig limita a memória usada pelos arquivos em processamento.
+// Arquivos a partir de arquivogrande são lidos em fluxo (ver leitorxlsx.go),
+// os demais pelo xlsx.OpenFile, que monta a pasta de trabalho inteira. Cada
+// arquivo reserva uma estimativa do que vai usar e espera enquanto a soma das
+// reservas passar de orcamento; um arquivo maior que o orçamento roda sozinho.
+// Orçamento zero não limita.
+type memoriaconfig struct {
+	Orcamento     tamanho `json:"orcamento"`
+	ArquivoGrande tamanho `json:"arquivogrande"`
+}
+
+// fatorOpenFile estima a memória do xlsx.OpenFile em relação ao tamanho do
+// arquivo: ele guarda cada célula como objeto e o perfil robomem mostra
+// dezenas de vezes o tamanho do xlsx em alocações.
+const fatorOpenFile = 40
+
+// orcamentoMemoria é um semáforo em bytes.
+type orcamentoMemoria struct {
+	mu    sync.Mutex
+	livre *sync.Cond
+	uso   int64
+}
+
+var memoriaEmUso = novoOrcamentoMemoria()
+
+func novoOrcamentoMemoria() *orcamentoMemoria {
+	o := &orcamentoMemoria{}
+	o.livre = sync.NewCond(&o.mu)
+	return o
+}
+
+// reservar espera caber n bytes no orçamento e devolve o que foi reservado,
+// a ser passado para liberar.
+func (o *orcamentoMemoria) reservar(n int64) int64 {
+	limite := config.Configuracao.Memoria.Orcamento.Bytes
+	if limite <= 0 || n <= 0 {
+		return 0
+	}
+	if n > limite {
+		n = limite
+	}
+	o.mu.Lock()
+	for o.uso > 0 && o.uso+n > limite {
+		o.livre.Wait()
+	}
+	o.uso += n
+	o.mu.Unlock()
+	return n
+}
+
+func (o *orcamentoMemoria) liberar(n int64) {
+	if n == 0 {
+		return
+	}
+	o.mu.Lock()
+	o.uso -= n
+	o.livre.Broadcast()
+	o.mu.Unlock()
+}
+
+// arquivoGrande indica se o arquivo deve ser lido em fluxo.
+func arquivoGrande(info os.FileInfo) bool {
+	limite := config.Configuracao.Memoria.ArquivoGrande.Bytes
+	return limite > 0 && info.Size() >= limite
+}
+
+// estimativaFluxo é a memória da leitura em fluxo: as strings compartilhadas,
+// carregadas inteiras, e os buffers de cada aba.
+func estimativaFluxo(caminho string) int64 {
+	estimativa := int64(4 << 20)
+	z, err := zip.OpenReader(caminho)
+	if err != nil {
+		return estimativa
+	}
+	defer z.Close()
+	for _, f := range z.File {
+		if strings.EqualFold(f.Name, "xl/sharedStrings.xml") {
+			estimativa += 2 * int64(f.UncompressedSize64)
+		}
+	}
+	return estimativa
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kr/fs"
+)
+
+// metadado é o conteúdo de uma fonte de metadado, já no formato das variáveis
+// globais (dic, est, email, dicArquivo e padroesArquivo).
+type metadado struct {
+	Origem    string
+	Empresa   map[string][]string
+	Agrupador map[string][]*dicionario
+	Email     map[string][]string
+	Arquivo   map[string]string
+	Padroes   []padraoArquivo
+}
+
+// chavesRetidas são as chaves de arquivo cujo metadado está quebrado ou em
+// conflito; os arquivos delas vão para PlanilhasRetidas até a correção.
+var chavesRetidas map[string]string
+
+// retencoesSemIndice são as fontes com erro sem carga boa anterior: sem o
+// índice não há como saber quais arquivos eram delas, e os arquivos sem
+// correspondência no metadado não podem ir para sem metadado. Uma fonte num
+// subdiretório de metadados.diretorio (um por empresa) retém só os arquivos
+// da pasta dessa empresa (entrada.empresapelapasta); na raiz, retém todos.
+var retencoesSemIndice []retencaoFonte
+
+type retencaoFonte struct {
+	empresa string // subdiretório da fonte, minúsculo; vazio na raiz
+	motivo  string
+}
+
+// empresaDaFonte é o primeiro subdiretório da fonte dentro de
+// metadados.diretorio, ou vazio para as fontes da raiz.
+func empresaDaFonte(fonte string) string {
+	rel, err := filepath.Rel(filepath.Clean(config.Configuracao.Metadados.Diretorio), fonte)
+	if err != nil {
+		return ""
+	}
+	partes := strings.FieldsFunc(rel, func(r rune) bool { return r == '\\' || r == '/' })
+	if len(partes) < 2 {
+		return ""
+	}
+	return strings.ToLower(partes[0])
+}
+
+// motivoRetencao diz se o arquivo da chave (info[2] de buscarNomeArquivo) deve
+// ir para PlanilhasRetidas, e por quê. empresa é a de empresaDaPasta, vazia
+// quando a pasta do arquivo não indica a empresa.
+func motivoRetencao(chave string, empresa string) (string, bool) {
+	if motivo, ok := chavesRetidas[chave]; ok {
+		return motivo, true
+	}
+	if _, ok := dicArquivo[chave]; ok {
+		return "", false
+	}
+	var motivos []string
+	for _, r := range retencoesSemIndice {
+		if r.empresa == "" || empresa == "" || r.empresa == empresa {
+			motivos = append(motivos, r.motivo)
+		}
+	}
+	if len(motivos) == 0 {
+		return "", false
+	}
+	return strings.Join(motivos, " "), true
+}
+
+// fontesMetadado lista as fontes (xlsx, json ou yaml) de metadados.diretorio
+// (e subdiretórios, um por empresa se preferir) cujo nome casa com
+// metadados.nomearquivo, que pode ser um nome ou um glob como *.xlsx.
+func fontesMetadado() []string {
+	padrao := strings.ToLower(config.Configuracao.Metadados.NomeArquivo)
+	if !strings.ContainsAny(padrao, "*?[") {
+		return []string{config.Configuracao.Metadados.Diretorio + "\\" + config.Configuracao.Metadados.NomeArquivo}
+	}
+
+	var fontes []string
+	walker := fs.Walk(config.Configuracao.Metadados.Diretorio)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		nome := strings.ToLower(walker.Stat().Name())
+		// ~$ são travas do Excel; Dicionario*.json são as exportações de "metadados dump".
+		if walker.Stat().IsDir() || strings.HasPrefix(nome, "~$") || (strings.HasPrefix(nome, "dicionario") && strings.HasSuffix(nome, ".json")) {
+			continue
+		}
+		if ok, _ := filepath.Match(padrao, nome); ok {
+			fontes = append(fontes, walker.Path())
+		}
+	}
+	sort.Strings(fontes)
+	return fontes
+}
+
+// carregarMetadado lê todas as fontes e mescla nas variáveis globais. Uma
+// fonte com erro não interrompe as demais: as chaves que ela tinha na última
+// carga boa ficam retidas; sem carga boa anterior, ficam retidos os arquivos
+// que nenhuma fonte reconhece (ver retencoesSemIndice). Chaves definidas de forma diferente em
+// duas fontes também ficam retidas. Grava o índice e o retrato da versão em
+// Log e devolve os problemas encontrados.
+func carregarMetadado() []string {
+	return lerFontesMetadado(true)
+}
+
+// carregarMetadadoSomenteLeitura carrega como carregarMetadado, sem gravar o
+// índice nem o retrato: para convert, que não grava nada em disco.
+func carregarMetadadoSomenteLeitura() []string {
+	return lerFontesMetadado(false)
+}
+
+func lerFontesMetadado(gravar bool) []string {
+	fontes := fontesMetadado()
+	if len(fontes) == 0 {
+		panic(fmt.Sprintf("Nenhum metadado encontrado em %s (%s).", config.Configuracao.Metadados.Diretorio, config.Configuracao.Metadados.NomeArquivo))
+	}
+
+	indice := lerIndiceMetadado()
+	m := &metadado{
+		Empresa:   make(map[string][]string),
+		Agrupador: make(map[string][]*dicionario),
+		Email:     make(map[string][]string),
+		Arquivo:   make(map[string]string),
+	}
+	retidas := make(map[string]string)
+	var semIndice []retencaoFonte
+	var problemas []string
+	origemChave := make(map[string]string)
+
+	for _, fonte := range fontes {
+		f, err := carregaDicionario(fonte)
+		if err != nil {
+			problemas = append(problemas, fmt.Sprintf("%s: %s", fonte, err.Error()))
+			for _, texto := range indice[fonte] {
+				p, errP := novoPadraoArquivo(texto)
+				if errP != nil {
+					continue
+				}
+				m.Padroes = append(m.Padroes, p)
+				retidas[p.Chave] = fmt.Sprintf("Metadado com erro em %s: %s", fonte, err.Error())
+			}
+			if len(indice[fonte]) == 0 {
+				r := retencaoFonte{empresa: empresaDaFonte(fonte)}
+				alcance := "os arquivos sem metadado"
+				if r.empresa != "" {
+					alcance = fmt.Sprintf("os arquivos sem metadado da pasta %s", r.empresa)
+				}
+				r.motivo = fmt.Sprintf("Metadado com erro em %s, sem carga anterior para saber os arquivos dele; %s ficam retidos até a correção: %s", fonte, alcance, err.Error())
+				problemas = append(problemas, fmt.Sprintf("%s: sem carga anterior; %s ficam retidos até a correção.", fonte, alcance))
+				semIndice = append(semIndice, r)
+			}
+			continue
+		}
+
+		var textos []string
+		for _, p := range f.Padroes {
+			textos = append(textos, p.Texto)
+		}
+		indice[fonte] = textos
+
+		for k, v := range f.Empresa {
+			chave := strings.Split(k, "|")[0]
+			if atual, ok := m.Empresa[k]; ok && strings.Join(atual, "|") != strings.Join(v, "|") {
+				retidas[chave] = fmt.Sprintf("Aba %s definida de forma diferente em %s e %s.", k, origemChave[chave], fonte)
+				problemas = append(problemas, retidas[chave])
+				continue
+			}
+			m.Empresa[k] = v
+			origemChave[chave] = fonte
+		}
+		for k, v := range f.Email {
+			if atual, ok := m.Email[k]; ok && strings.Join(atual, "|") != strings.Join(v, "|") {
+				retidas[k] = fmt.Sprintf("E-mails de %s definidos de forma diferente em dois metadados (%s).", k, fonte)
+				problemas = append(problemas, retidas[k])
+				continue
+			}
+			m.Email[k] = v
+		}
+		for k, v := range f.Arquivo {
+			if _, ok := m.Arquivo[k]; !ok {
+				m.Arquivo[k] = v
+			}
+		}
+		for k, v := range f.Agrupador {
+			m.Agrupador[k] = append(m.Agrupador[k], v...)
+		}
+		m.Padroes = append(m.Padroes, f.Padroes...)
+	}
+
+	for _, p := range problemas {
+		fmt.Fprintln(os.Stderr, "Metadado:", p)
+	}
+	dic, est, email, dicArquivo, padroesArquivo, chavesRetidas, retencoesSemIndice = m.Empresa, m.Agrupador, m.Email, m.Arquivo, m.Padroes, retidas, semIndice
+	if gravar {
+		gravarIndiceMetadado(indice)
+		versaoMetadadoAtual = salvarRetratoMetadado(retratoDe(m))
+	} else {
+		versaoMetadadoAtual = retratoDe(m).versao()
+	}
+	assinaturaMetadado = assinaturaFontes(fontes)
+	esquecerAvisosSemChave()
+	return problemas
+}
+
+// O índice guarda, por fonte, os padrões de nome de arquivo da última carga
+// boa, para saber quais arquivos reter quando a fonte quebrar.
+func arquivoIndiceMetadado() string {
+	return fmt.Sprintf("%s\\metadados_indice.json", config.Configuracao.Diretorios.Log)
+}
+
+func lerIndiceMetadado() map[string][]string {
+	indice := make(map[string][]string)
+	if dat, err := ioutil.ReadFile(arquivoIndiceMetadado()); err == nil {
+		if err := json.Unmarshal(dat, &indice); err != nil {
+			fmt.Fprintln(os.Stderr, "Erro ao ler o índice de metadados - ", err.Error())
+		}
+	}
+	return indice
+}
+
+func gravarIndiceMetadado(indice map[string][]string) {
+	dat, err := json.MarshalIndent(indice, "", "\t")
+	if err == nil {
+		err = ioutil.WriteFile(arquivoIndiceMetadado(), dat, 0644)
+	}
+	if err != nil {
+		fmt.Println("Erro ao gravar o índice de metadados - ", err.Error())
+	}
+}
+
+// reterArquivo move para PlanilhasRetidas um arquivo cujo metadado está com
+// erro; replay -retidas o devolve depois da correção.
+func reterArquivo(t tarefa, chave string, motivo string) {
+	fmt.Println("Retido: ", fmt.Sprintf("%s\\%s", t.origem(), t.Arquivo))
+	erro := os.Rename(fmt.Sprintf("%s\\%s", t.origem(), t.Arquivo), fmt.Sprintf("%s\\%s", criarPasta(config.Configuracao.Diretorios.PlanilhasRetidas, t.Pasta), t.Arquivo))
+	if erro != nil {
+		fmt.Println(erro.Error())
+	}
+	registrarLedger(registroLedger{Arquivo: t.Arquivo, Pasta: t.Pasta, Pacote: t.Pacote, Chave: chave, Status: eventoRetido, Motivos: []string{motivo}})
+	notificar(notificacao{Evento: eventoRetido, Chave: chave, Arquivo: t.Arquivo, Motivos: []string{motivo}})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const metadadoStef = `{
+	"versao": 1,
+	"estrutura": [
+		{"empresa": "stef", "agrupador": "chamados", "nomearquivo": "chamados stef", "sheet": "plan1", "de": "id", "para": "Id"}
+	],
+	"emails": [
+		{"empresa": "stef", "nomearquivo": "chamados stef", "email1": "ti@stef.com"}
+	]
+}`
+
+const metadadoAbb = `{
+	"versao": 1,
+	"estrutura": [
+		{"empresa": "abb", "agrupador": "chamados", "nomearquivo": "chamados abb", "sheet": "plan1", "de": "id", "para": "Id"}
+	]
+}`
+
+// usarMetadados grava as fontes num diretório temporário e configura
+// metadados.nomearquivo como *.json.
+func usarMetadados(t *testing.T, fontes map[string]string) string {
+	dir := t.TempDir()
+	for nome, conteudo := range fontes {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, nome)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, nome), []byte(conteudo), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Configuracao.Metadados = metadados{Diretorio: dir, NomeArquivo: "*.json"}
+	config.Configuracao.Diretorios.Log = t.TempDir()
+	t.Cleanup(func() {
+		config.Configuracao.Metadados = metadados{}
+		config.Configuracao.Diretorios.Log = ""
+		dic, est, email, dicArquivo, padroesArquivo, chavesRetidas, retencoesSemIndice = nil, nil, nil, nil, nil, nil, nil
+	})
+	return dir
+}
+
+func chaveDoArquivo(t *testing.T, nome string) string {
+	info, ambiguos := buscarNomeArquivo(nome)
+	if ambiguos != nil {
+		t.Fatalf("%s ambíguo", nome)
+	}
+	return info[2]
+}
+
+func TestCarregarMetadadoFontes(t *testing.T) {
+	usarMetadados(t, map[string]string{"stef.json": metadadoStef, "abb.json": metadadoAbb})
+	if p := carregarMetadado(); len(p) > 0 {
+		t.Fatalf("problemas: %v", p)
+	}
+	for _, nome := range []string{"chamados stef.xlsx", "chamados abb.xlsx"} {
+		if _, retido := motivoRetencao(chaveDoArquivo(t, nome), ""); retido {
+			t.Errorf("%s retido com as fontes boas", nome)
+		}
+	}
+	if _, retido := motivoRetencao(chaveDoArquivo(t, "outro.xlsx"), ""); retido {
+		t.Error("arquivo sem metadado retido com as fontes boas")
+	}
+}
+
+func TestCarregarMetadadoFonteQuebradaComCargaAnterior(t *testing.T) {
+	dir := usarMetadados(t, map[string]string{"stef.json": metadadoStef, "abb.json": metadadoAbb})
+	carregarMetadado()
+
+	ioutil.WriteFile(filepath.Join(dir, "abb.json"), []byte(`{"versao": 1, "estrutura": [`), 0644)
+	problemas := carregarMetadado()
+	if len(problemas) != 1 || !strings.Contains(problemas[0], "abb.json") {
+		t.Fatalf("problemas = %v", problemas)
+	}
+	if _, retido := motivoRetencao(chaveDoArquivo(t, "chamados abb.xlsx"), ""); !retido {
+		t.Error("arquivo da fonte quebrada não foi retido")
+	}
+	if _, retido := motivoRetencao(chaveDoArquivo(t, "chamados stef.xlsx"), ""); retido {
+		t.Error("arquivo da fonte boa foi retido")
+	}
+	if _, retido := motivoRetencao(chaveDoArquivo(t, "outro.xlsx"), ""); retido {
+		t.Error("com o índice, o arquivo sem metadado não precisa ser retido")
+	}
+}
+
+func TestCarregarMetadadoFonteQuebradaSemCargaAnterior(t *testing.T) {
+	usarMetadados(t, map[string]string{"stef.json": metadadoStef, "abb.json": `{"versao": 1, "estrutura": [`})
+	problemas := carregarMetadado()
+	if len(problemas) != 2 {
+		t.Fatalf("problemas = %v", problemas)
+	}
+	// Sem o índice não se sabe quais arquivos eram da fonte quebrada: os que
+	// nenhuma fonte reconhece ficam retidos em vez de ir para sem metadado.
+	for _, nome := range []string{"chamados abb.xlsx", "outro.xlsx"} {
+		if motivo, retido := motivoRetencao(chaveDoArquivo(t, nome), ""); !retido || !strings.Contains(motivo, "abb.json") {
+			t.Errorf("%s: retido = %v, motivo %q", nome, retido, motivo)
+		}
+	}
+	if _, retido := motivoRetencao(chaveDoArquivo(t, "chamados stef.xlsx"), ""); retido {
+		t.Error("arquivo da fonte boa foi retido")
+	}
+}
+
+func TestCarregarMetadadoFonteQuebradaNaPastaDaEmpresa(t *testing.T) {
+	usarMetadados(t, map[string]string{"stef.json": metadadoStef, "abb/abb.json": `{"versao": 1, "estrutura": [`})
+	problemas := carregarMetadado()
+	if len(problemas) != 2 || !strings.Contains(problemas[1], "da pasta abb") {
+		t.Fatalf("problemas = %v", problemas)
+	}
+	// A fonte quebrada está em MetaDados\abb: só os arquivos sem metadado da
+	// pasta abb (ou de pasta que não indica a empresa) ficam retidos.
+	casos := []struct {
+		empresa string
+		retido  bool
+	}{
+		{"abb", true},
+		{"", true},
+		{"stef", false},
+	}
+	for _, c := range casos {
+		motivo, retido := motivoRetencao(chaveDoArquivo(t, "outro.xlsx"), c.empresa)
+		if retido != c.retido || retido && !strings.Contains(motivo, "abb.json") {
+			t.Errorf("empresa %q: retido = %v, motivo %q", c.empresa, retido, motivo)
+		}
+	}
+	if _, retido := motivoRetencao(chaveDoArquivo(t, "chamados stef.xlsx"), "abb"); retido {
+		t.Error("arquivo da fonte boa foi retido")
+	}
+}
+
+func TestReterArquivoRegistraAFonte(t *testing.T) {
+	usarMetadados(t, map[string]string{"abb/abb.json": `{"versao": 1, "estrutura": [`})
+	carregarMetadado()
+	entrada, retidas := t.TempDir(), t.TempDir()
+	config.Configuracao.Diretorios.PlanilhasAImportar = entrada
+	config.Configuracao.Diretorios.PlanilhasRetidas = retidas
+	t.Cleanup(func() {
+		config.Configuracao.Diretorios.PlanilhasAImportar = ""
+		config.Configuracao.Diretorios.PlanilhasRetidas = ""
+	})
+	ioutil.WriteFile(fmt.Sprintf("%s\\abb\\outro.xlsx", entrada), []byte("x"), 0644)
+
+	motivo, retido := motivoRetencao(chaveDoArquivo(t, "outro.xlsx"), "abb")
+	if !retido {
+		t.Fatal("arquivo não retido")
+	}
+	reterArquivo(tarefa{Arquivo: "outro.xlsx", Pasta: "abb"}, "outro.xlsx", motivo)
+	registros, err := lerLedger()
+	if err != nil || len(registros) != 1 {
+		t.Fatalf("ledger %+v (%v)", registros, err)
+	}
+	if r := registros[0]; r.Status != eventoRetido || len(r.Motivos) != 1 || !strings.Contains(r.Motivos[0], filepath.Join("abb", "abb.json")) {
+		t.Errorf("registro %+v, esperado retido citando abb.json", r)
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s\\abb\\outro.xlsx", retidas)); err != nil {
+		t.Errorf("arquivo não foi para PlanilhasRetidas\\abb: %v", err)
+	}
+}
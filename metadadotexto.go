@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/tealeg/xlsx"
+	"gopkg.in/yaml.v2"
+)
+
+// Formato texto do metadado (.json, .yaml ou .yml), alternativa à pasta de
+// trabalho. Cada item de estrutura é uma linha da primeira aba e cada item de
+// emails uma linha da segunda, com os mesmos nomes de coluna:
+//
+//	versao: 1
+//	estrutura:
+//	  - empresa: abb
+//	    agrupador: chamados
+//	    nomearquivo: chamados abertos-abb
+//	    sheet: plan1
+//	    de: id do chamado
+//	    para: IdChamado
+//	    obrigatorio: s
+//	    tipo: n
+//	    padrao: chamados abertos-{empresa}-{yyyy}-{mm}*
+//	    chave: s
+//	emails:
+//	  - empresa: abb
+//	    nomearquivo: chamados abertos-abb
+//	    email1: ti@abb.com
+//	    webhook: https://...
+//	    formato: teams
+//
+// Campos desconhecidos são erro. "metadados converter" converte entre este
+// formato e o xlsx nos dois sentidos.
+const versaoMetadado = 1
+
+type documentoMetadado struct {
+	Versao    int              `json:"versao" yaml:"versao"`
+	Estrutura []linhaEstrutura `json:"estrutura" yaml:"estrutura"`
+	Emails    []linhaEmail     `json:"emails" yaml:"emails"`
+}
+
+type linhaEstrutura struct {
+	Empresa     string `json:"empresa" yaml:"empresa"`
+	Agrupador   string `json:"agrupador" yaml:"agrupador"`
+	NomeArquivo string `json:"nomearquivo" yaml:"nomearquivo"`
+	Sheet       string `json:"sheet" yaml:"sheet"`
+	Caminho     string `json:"caminho,omitempty" yaml:"caminho,omitempty"`
+	De          string `json:"de" yaml:"de"`
+	Para        string `json:"para" yaml:"para"`
+	Obrigatorio string `json:"obrigatorio,omitempty" yaml:"obrigatorio,omitempty"`
+	Tipo        string `json:"tipo,omitempty" yaml:"tipo,omitempty"`
+	Padrao      string `json:"padrao,omitempty" yaml:"padrao,omitempty"`
+	Chave       string `json:"chave,omitempty" yaml:"chave,omitempty"`
+	local       string
+}
+
+type linhaEmail struct {
+	Empresa     string `json:"empresa" yaml:"empresa"`
+	NomeArquivo string `json:"nomearquivo" yaml:"nomearquivo"`
+	Email1      string `json:"email1,omitempty" yaml:"email1,omitempty"`
+	Email2      string `json:"email2,omitempty" yaml:"email2,omitempty"`
+	Email3      string `json:"email3,omitempty" yaml:"email3,omitempty"`
+	Webhook     string `json:"webhook,omitempty" yaml:"webhook,omitempty"`
+	Formato     string `json:"formato,omitempty" yaml:"formato,omitempty"`
+}
+
+var (
+	cabecalhoEstrutura = []string{"Empresa", "Agrupador", "NomeArquivo", "Sheet", "CaminhoFisico", "De", "Para", "Obrigatorio", "Tipo", "Padrao", "Chave"}
+	cabecalhoEmail     = []string{"Empresa", "NomeArquivo", "Email1", "Email2", "Email3", "Webhook", "Formato"}
+)
+
+func formatoMetadado(caminho string) string {
+	switch strings.ToLower(filepath.Ext(strings.Replace(caminho, "\\", "/", -1))) {
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+	return "xlsx"
+}
+
+func lerDocumentoMetadado(caminho string) (*documentoMetadado, error) {
+	formato := formatoMetadado(caminho)
+	if formato == "xlsx" {
+		return lerMetadadoXlsx(caminho)
+	}
+
+	dat, err := ioutil.ReadFile(caminho)
+	if err != nil {
+		return nil, err
+	}
+	doc := &documentoMetadado{}
+	if formato == "json" {
+		dec := json.NewDecoder(bytes.NewReader(dat))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(doc)
+	} else {
+		err = yaml.UnmarshalStrict(dat, doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("metadado inválido: %s", err.Error())
+	}
+	if doc.Versao == 0 {
+		return nil, fmt.Errorf("metadado sem \"versao\"")
+	}
+	if doc.Versao > versaoMetadado {
+		return nil, fmt.Errorf("versão %d do metadado não suportada (máximo %d)", doc.Versao, versaoMetadado)
+	}
+	return doc, nil
+}
+
+func gravarDocumentoMetadado(doc *documentoMetadado, caminho string) error {
+	var dat []byte
+	var err error
+	switch formatoMetadado(caminho) {
+	case "json":
+		dat, err = json.MarshalIndent(doc, "", "  ")
+	case "yaml":
+		dat, err = yaml.Marshal(doc)
+	default:
+		return gravarMetadadoXlsx(doc, caminho)
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(caminho, dat, 0644)
+}
+
+func gravarMetadadoXlsx(doc *documentoMetadado, caminho string) error {
+	file := xlsx.NewFile()
+	estrutura, err := file.AddSheet("Estrutura")
+	if err != nil {
+		return err
+	}
+	adicionarLinha(estrutura, cabecalhoEstrutura)
+	for _, l := range doc.Estrutura {
+		adicionarLinha(estrutura, []string{l.Empresa, l.Agrupador, l.NomeArquivo, l.Sheet, l.Caminho, l.De, l.Para, l.Obrigatorio, l.Tipo, l.Padrao, l.Chave})
+	}
+
+	emails, err := file.AddSheet("Email")
+	if err != nil {
+		return err
+	}
+	adicionarLinha(emails, cabecalhoEmail)
+	for _, l := range doc.Emails {
+		adicionarLinha(emails, []string{l.Empresa, l.NomeArquivo, l.Email1, l.Email2, l.Email3, l.Webhook, l.Formato})
+	}
+	return file.Save(caminho)
+}
+
+func adicionarLinha(sheet *xlsx.Sheet, valores []string) {
+	row := sheet.AddRow()
+	for _, v := range valores {
+		row.AddCell().SetString(v)
+	}
+}
+
+// converterMetadado lê o metadado de entrada (xlsx, json ou yaml) e grava no
+// formato indicado pela extensão da saída.
+func converterMetadado(entrada string, saida string) error {
+	doc, err := lerDocumentoMetadado(entrada)
+	if err != nil {
+		return err
+	}
+	if _, err := montarMetadado(entrada, doc); err != nil {
+		return err
+	}
+	doc.Versao = versaoMetadado
+	return gravarDocumentoMetadado(doc, saida)
+}
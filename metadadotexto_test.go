@@ -0,0 +1,103 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// padroesComparaveis tira dos padrões as expressões compiladas, deixando o
+// texto, a chave e a captura.
+func padroesComparaveis(ps []padraoArquivo) [][]string {
+	var r [][]string
+	for _, p := range ps {
+		captura := ""
+		if p.captura != nil {
+			captura = p.captura.String()
+		}
+		r = append(r, []string{p.Chave, p.Texto, captura})
+	}
+	return r
+}
+
+func compararMetadados(t *testing.T, nome string, esperado *metadado, obtido *metadado) {
+	if !reflect.DeepEqual(obtido.Agrupador, esperado.Agrupador) {
+		t.Errorf("%s: DicionarioAgrupador diferente", nome)
+	}
+	if !reflect.DeepEqual(obtido.Empresa, esperado.Empresa) {
+		t.Errorf("%s: DicionarioEmpresa = %v, esperado %v", nome, obtido.Empresa, esperado.Empresa)
+	}
+	if !reflect.DeepEqual(obtido.Email, esperado.Email) {
+		t.Errorf("%s: DicionarioEmail = %v, esperado %v", nome, obtido.Email, esperado.Email)
+	}
+	if !reflect.DeepEqual(obtido.Arquivo, esperado.Arquivo) {
+		t.Errorf("%s: DicionarioArquivo = %v, esperado %v", nome, obtido.Arquivo, esperado.Arquivo)
+	}
+	if p, e := padroesComparaveis(obtido.Padroes), padroesComparaveis(esperado.Padroes); !reflect.DeepEqual(p, e) {
+		t.Errorf("%s: padrões = %v, esperado %v", nome, p, e)
+	}
+}
+
+// TestConverterMetadadoIdaEVolta converte xlsx → json → xlsx (e yaml) e
+// confere que todas as formas carregam o mesmo metadado.
+func TestConverterMetadadoIdaEVolta(t *testing.T) {
+	dir := t.TempDir()
+	origem := filepath.Join(dir, "origem.xlsx")
+	doc := &documentoMetadado{
+		Versao: versaoMetadado,
+		Estrutura: []linhaEstrutura{
+			{Empresa: "ABB", Agrupador: "Chamados", NomeArquivo: "chamados abertos-abb", Sheet: "Plan1", De: "ID do Chamado", Para: "IdChamado", Obrigatorio: "s", Tipo: "n", Padrao: "chamados abertos-{empresa}-{yyyy}-{mm}*", Chave: "s"},
+			{Empresa: "ABB", Agrupador: "Chamados", NomeArquivo: "chamados abertos-abb", Sheet: "Plan1", De: "status", Para: "Status"},
+			{Empresa: "stef", Agrupador: "historico", NomeArquivo: "re:^historico-\\d+$", Sheet: "dados", Caminho: "C:\\dados", De: "data", Para: "Data", Tipo: "d"},
+		},
+		Emails: []linhaEmail{
+			{Empresa: "ABB", NomeArquivo: "chamados abertos-abb", Email1: "TI@abb.com", Email2: "qlik@abb.com", Webhook: "https://hooks.example/abb", Formato: "teams"},
+		},
+	}
+	if err := gravarMetadadoXlsx(doc, origem); err != nil {
+		t.Fatal(err)
+	}
+
+	caminhos := []string{origem, filepath.Join(dir, "ida.json"), filepath.Join(dir, "volta.xlsx"), filepath.Join(dir, "ida.yaml")}
+	for i, de := range [][2]string{{caminhos[0], caminhos[1]}, {caminhos[1], caminhos[2]}, {caminhos[2], caminhos[3]}} {
+		if err := converterMetadado(de[0], de[1]); err != nil {
+			t.Fatalf("conversão %d (%s → %s): %v", i+1, de[0], de[1], err)
+		}
+	}
+
+	esperado, err := carregaDicionario(origem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(esperado.Agrupador) != 2 || len(esperado.Padroes) != 2 || len(esperado.Email) != 1 {
+		t.Fatalf("metadado de origem incompleto: %+v", esperado)
+	}
+	for _, c := range caminhos[1:] {
+		obtido, err := carregaDicionario(c)
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		compararMetadados(t, filepath.Base(c), esperado, obtido)
+	}
+}
+
+func TestConverterMetadadoDaPlanilhaDoRepositorio(t *testing.T) {
+	dir := t.TempDir()
+	origem := filepath.Join("MetaDados", "MetaDados_Stef.xlsx")
+	json, volta := filepath.Join(dir, "stef.json"), filepath.Join(dir, "stef.xlsx")
+	if err := converterMetadado(origem, json); err != nil {
+		t.Fatal(err)
+	}
+	if err := converterMetadado(json, volta); err != nil {
+		t.Fatal(err)
+	}
+	esperado, err := carregaDicionario(origem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obtido, err := carregaDicionario(volta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compararMetadados(t, "MetaDados_Stef", esperado, obtido)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// notificacao descreve um resultado de processamento a ser avisado.
+// Chave é a chave do arquivo no metadado (dicArquivo / aba de e-mails).
+type notificacao struct {
+	Evento   string
+	Empresa  string
+	Chave    string
+	Arquivo  string
+	Mensagem string
+	Motivos  []string
+	Anexos   []string
+}
+
+const (
+	eventoSucesso     = "sucesso"
+	eventoFalha       = "falha"
+	eventoSemMetadado = "semmetadado"
+	eventoAmbiguo     = "ambiguo"
+	eventoRetido      = "retido"
+	eventoQuarentena  = "quarentena"
+	eventoDuplicado   = "duplicado"
+	eventoResumo      = "resumo"
+)
+
+type notificador interface {
+	Notificar(n notificacao) error
+}
+
+var notificadores []notificador
+
+// resumo conta os eventos da passada atual para a notificação de resumo.
+var resumo = struct {
+	sync.Mutex
+	contagem map[string]int
+}{contagem: make(map[string]int)}
+
+func configurarNotificadores() {
+	notificadores = nil
+	if config.Configuracao.EnviarEmail {
+		if config.Configuracao.Resumo.Ativo {
+			resumoDiario = novoNotificadorResumo(config.Configuracao.Resumo, novoNotificadorSMTP(config.Configuracao.Email))
+			notificadores = append(notificadores, resumoDiario)
+		} else {
+			notificadores = append(notificadores, novoNotificadorSMTP(config.Configuracao.Email))
+		}
+	}
+	notificadores = append(notificadores, novoNotificadorWebhook(config.Configuracao.Webhook))
+}
+
+// encerrarNotificadores espera as entregas em andamento dos notificadores com
+// fila própria. Chamado no fim do processamento, antes de o programa sair.
+func encerrarNotificadores() {
+	for _, nt := range notificadores {
+		if e, ok := nt.(interface{ encerrar() }); ok {
+			e.encerrar()
+		}
+	}
+}
+
+// entrega é um envio feito pela filaEntrega. enviar recebe o canal que fecha
+// no encerramento, para abandonar as esperas entre tentativas; falhou recebe o
+// erro quando o envio não pôde ser feito.
+type entrega struct {
+	enviar func(parar <-chan struct{}) error
+	falhou func(err error)
+}
+
+// filaEntrega faz os envios numa goroutine própria, para as tentativas e
+// esperas de um destino fora do ar não travarem os workers. Com a fila cheia,
+// ou depois de encerrada, o envio falha na hora.
+type filaEntrega struct {
+	mu        sync.Mutex
+	itens     chan entrega
+	parar     chan struct{}
+	encerrada bool
+	fim       sync.WaitGroup
+}
+
+func novaFilaEntrega(tamanho int) *filaEntrega {
+	f := &filaEntrega{itens: make(chan entrega, tamanho), parar: make(chan struct{})}
+	f.fim.Add(1)
+	go func() {
+		defer f.fim.Done()
+		for e := range f.itens {
+			if err := e.enviar(f.parar); err != nil {
+				e.falhou(err)
+			}
+		}
+	}()
+	return f
+}
+
+func (f *filaEntrega) adicionar(e entrega) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.encerrada {
+		e.falhou(errors.New("notificações encerradas"))
+		return
+	}
+	select {
+	case f.itens <- e:
+	default:
+		e.falhou(errors.New("fila de envio cheia"))
+	}
+}
+
+// encerrar interrompe as esperas entre tentativas e aguarda a fila esvaziar:
+// os envios restantes têm uma única tentativa.
+func (f *filaEntrega) encerrar() {
+	f.mu.Lock()
+	if f.encerrada {
+		f.mu.Unlock()
+		return
+	}
+	f.encerrada = true
+	close(f.parar)
+	close(f.itens)
+	f.mu.Unlock()
+	f.fim.Wait()
+}
+
+// esperar dorme d, ou menos se parar fechar; devolve se dormiu até o fim.
+func esperar(d time.Duration, parar <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-parar:
+		return false
+	}
+}
+
+// notificar repassa a notificação a todos os notificadores configurados.
+// Falhas de envio não interrompem o processamento.
+func notificar(n notificacao) {
+	if n.Empresa == "" {
+		if e, ok := email[n.Chave]; ok {
+			n.Empresa = e[0]
+		}
+	}
+	if n.Evento != eventoResumo {
+		resumo.Lock()
+		resumo.contagem[n.Evento]++
+		resumo.Unlock()
+	}
+	for _, nt := range notificadores {
+		if err := nt.Notificar(n); err != nil {
+			fmt.Println(fmt.Sprintf("Erro ao notificar [%s] %s - %s", n.Evento, n.Arquivo, err.Error()))
+		}
+	}
+}
+
+// notificarResumo envia o resumo da passada, se algum arquivo foi processado.
+func notificarResumo() {
+	if resumoDiario != nil {
+		resumoDiario.enviarSeVencido()
+	}
+
+	resumo.Lock()
+	c := resumo.contagem
+	resumo.contagem = make(map[string]int)
+	resumo.Unlock()
+
+	if c[eventoSucesso]+c[eventoFalha]+c[eventoSemMetadado]+c[eventoAmbiguo]+c[eventoRetido]+c[eventoQuarentena]+c[eventoDuplicado] == 0 {
+		return
+	}
+	notificar(notificacao{
+		Evento:   eventoResumo,
+		Mensagem: fmt.Sprintf("Importadas: %d, com erro: %d, sem metadado: %d, ambíguas: %d, retidas: %d, em quarentena: %d, duplicadas: %d.", c[eventoSucesso], c[eventoFalha], c[eventoSemMetadado], c[eventoAmbiguo], c[eventoRetido], c[eventoQuarentena], c[eventoDuplicado]),
+	})
+}
+
+// destinatariosEmail devolve os e-mails preenchidos nas três colunas da aba de
+// e-mails do metadado para a chave do arquivo.
+func destinatariosEmail(chave string) []string {
+	var dest []string
+	e, ok := email[chave]
+	if !ok {
+		return dest
+	}
+	for _, d := range e[1:4] {
+		if d = strings.TrimSpace(d); d != "" {
+			dest = append(dest, d)
+		}
+	}
+	return dest
+}
+
+type notificadorSMTP struct {
+	cfg        emailconfig
+	tentativas int
+	intervalo  time.Duration
+	enviar     func(m ...*gomail.Message) error
+	fila       *filaEntrega
+}
+
+// novoNotificadorSMTP verifica o certificado do servidor. Para servidores com
+// certificado próprio (ou um SMTP local de teste) informe certificadoca.
+func novoNotificadorSMTP(cfg emailconfig) *notificadorSMTP {
+	n := &notificadorSMTP{cfg: cfg, tentativas: cfg.Tentativas, intervalo: cfg.Intervalo.Duration}
+	if n.tentativas <= 0 {
+		n.tentativas = 3
+	}
+	if n.intervalo <= 0 {
+		n.intervalo = 2 * time.Second
+	}
+
+	d := gomail.NewDialer(cfg.Servidor, cfg.Porta, cfg.ContaEmail, cfg.Senha)
+	d.TLSConfig = &tls.Config{ServerName: cfg.Servidor}
+	if cfg.CertificadoCA != "" {
+		pool, err := carregarCertificadoCA(cfg.CertificadoCA)
+		if err != nil {
+			fmt.Println("Erro ao carregar o certificado do servidor de e-mail - ", err.Error())
+		} else {
+			d.TLSConfig.RootCAs = pool
+		}
+	}
+	n.enviar = d.DialAndSend
+	n.fila = novaFilaEntrega(100)
+	return n
+}
+
+// carregarCertificadoCA lê os certificados PEM de caminho; um arquivo sem
+// nenhum certificado válido é erro, em vez de um pool vazio que recusaria
+// todo servidor.
+func carregarCertificadoCA(caminho string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caminho)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("nenhum certificado PEM válido em %s", caminho)
+	}
+	return pool, nil
+}
+
+// Notificar põe na fila de envio o e-mail dos arquivos com erro; os outros
+// eventos não geram e-mail.
+func (s *notificadorSMTP) Notificar(n notificacao) error {
+	if n.Evento != eventoFalha {
+		return nil
+	}
+	dest := destinatariosEmail(n.Chave)
+	if len(dest) == 0 {
+		return errors.New("nenhum e-mail cadastrado no metadado para " + n.Chave)
+	}
+
+	m := s.novaMensagem(dest, s.cfg.Titulo, fmt.Sprintf("Planilha: %s. <br> %s", n.Arquivo, n.Mensagem), n.Anexos)
+	s.fila.adicionar(entrega{
+		enviar: func(parar <-chan struct{}) error { return s.enviarMensagem(m, parar) },
+		falhou: func(err error) {
+			fmt.Println(fmt.Sprintf("Erro ao notificar [%s] %s - e-mail para %s: %s", n.Evento, n.Arquivo, strings.Join(dest, ", "), err.Error()))
+		},
+	})
+	return nil
+}
+
+func (s *notificadorSMTP) encerrar() {
+	s.fila.encerrar()
+}
+
+// novaMensagem monta um e-mail HTML. Os anexos são lidos agora, porque o
+// arquivo pode ser movido antes de o envio da fila acontecer.
+func (s *notificadorSMTP) novaMensagem(dest []string, assunto string, corpo string, anexos []string) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetAddressHeader("From", s.cfg.ContaEmail, s.cfg.NomeRemetente)
+	m.SetHeader("To", dest...)
+	m.SetHeader("Subject", assunto)
+	m.SetBody("text/html", corpo)
+	for _, a := range anexos {
+		dat, err := ioutil.ReadFile(a)
+		if err != nil {
+			fmt.Println("Anexo não incluído no e-mail - ", err.Error())
+			continue
+		}
+		m.Attach(filepath.Base(strings.Replace(a, "\\", "/", -1)), gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(dat)
+			return err
+		}))
+	}
+	return m
+}
+
+// enviarMensagem envia o e-mail, com nova tentativa e espera dobrada a cada
+// falha. As esperas terminam quando parar fecha.
+func (s *notificadorSMTP) enviarMensagem(m *gomail.Message, parar <-chan struct{}) error {
+	var err error
+	espera := s.intervalo
+	for t := 1; t <= s.tentativas; t++ {
+		if err = s.enviar(m); err == nil {
+			return nil
+		}
+		if t < s.tentativas {
+			fmt.Println(fmt.Sprintf("Erro ao enviar e-mail (tentativa %d de %d), nova tentativa em %s - %s", t, s.tentativas, espera, err.Error()))
+			if !esperar(espera, parar) {
+				break
+			}
+			espera *= 2
+		}
+	}
+	return err
+}
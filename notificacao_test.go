@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// smtpLocal é um servidor SMTP mínimo, com STARTTLS, para os testes do
+// notificadorSMTP. Guarda os destinatários e o corpo de cada mensagem; as
+// primeiras rejeitarAte conexões são recusadas.
+type smtpLocal struct {
+	ln          net.Listener
+	tls         *tls.Config
+	ca          string
+	mu          sync.Mutex
+	mensagens   []mensagemLocal
+	rejeitarAte int
+	conexoes    int
+}
+
+type mensagemLocal struct {
+	para  []string
+	dados string
+}
+
+func novoSMTPLocal(t *testing.T, rejeitarAte int) *smtpLocal {
+	chave, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelo := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smtp local"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, modelo, modelo, &chave.PublicKey, chave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpLocal{
+		tls:         &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: chave}}},
+		ca:          filepath.Join(t.TempDir(), "ca.pem"),
+		rejeitarAte: rejeitarAte,
+	}
+	if err := ioutil.WriteFile(s.ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if s.ln, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go s.aceitar()
+	t.Cleanup(func() { s.ln.Close() })
+	return s
+}
+
+func (s *smtpLocal) porta() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpLocal) aceitar() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.atender(c)
+	}
+}
+
+func (s *smtpLocal) atender(c net.Conn) {
+	defer c.Close()
+	s.mu.Lock()
+	s.conexoes++
+	rejeitar := s.conexoes <= s.rejeitarAte
+	s.mu.Unlock()
+	if rejeitar {
+		c.Write([]byte("421 ocupado\r\n"))
+		return
+	}
+
+	r := bufio.NewReader(c)
+	responder := func(linha string) { c.Write([]byte(linha + "\r\n")) }
+	responder("220 localhost ESMTP")
+	emTLS := false
+	var atual mensagemLocal
+	for {
+		linha, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		comando := strings.ToUpper(strings.TrimSpace(linha))
+		switch {
+		case strings.HasPrefix(comando, "EHLO"), strings.HasPrefix(comando, "HELO"):
+			if emTLS {
+				responder("250 localhost")
+			} else {
+				responder("250-localhost")
+				responder("250 STARTTLS")
+			}
+		case comando == "STARTTLS":
+			responder("220 pronto")
+			tc := tls.Server(c, s.tls)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			c, r, emTLS = tc, bufio.NewReader(tc), true
+		case strings.HasPrefix(comando, "RCPT TO:"):
+			atual.para = append(atual.para, strings.Trim(strings.TrimSpace(linha)[len("RCPT TO:"):], "<> "))
+			responder("250 ok")
+		case comando == "DATA":
+			responder("354 envie")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			atual.dados = b.String()
+			s.mu.Lock()
+			s.mensagens = append(s.mensagens, atual)
+			s.mu.Unlock()
+			atual = mensagemLocal{}
+			responder("250 ok")
+		case comando == "QUIT":
+			responder("221 tchau")
+			return
+		default:
+			responder("250 ok")
+		}
+	}
+}
+
+func (s *smtpLocal) recebidas() []mensagemLocal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]mensagemLocal(nil), s.mensagens...)
+}
+
+func configSMTPLocal(s *smtpLocal, ca string) emailconfig {
+	return emailconfig{
+		NomeRemetente: "Robo",
+		Titulo:        "QLIK - Planilha com erro",
+		Servidor:      "127.0.0.1",
+		Porta:         s.porta(),
+		ContaEmail:    "robo@stef.com",
+		CertificadoCA: ca,
+		Tentativas:    3,
+		Intervalo:     duracao{time.Millisecond},
+	}
+}
+
+func usarEmails(t *testing.T) {
+	email = map[string][]string{"chamados": {"stef", "a@stef.com", "", " c@stef.com "}}
+	t.Cleanup(func() { email = nil })
+}
+
+func TestNotificadorSMTPEnviaAosTresDestinatarios(t *testing.T) {
+	usarEmails(t)
+	s := novoSMTPLocal(t, 2)
+	anexo := filepath.Join(t.TempDir(), "chamados_plan1.log")
+	ioutil.WriteFile(anexo, []byte("coluna obrigatória ausente"), 0644)
+
+	n := novoNotificadorSMTP(configSMTPLocal(s, s.ca))
+	if err := n.Notificar(notificacao{Evento: eventoFalha, Chave: "chamados", Arquivo: "chamados.xlsx", Mensagem: "erro", Anexos: []string{anexo}}); err != nil {
+		t.Fatal(err)
+	}
+	// Notificar não espera o envio; as recusas são refeitas pela fila.
+	for limite := time.Now().Add(10 * time.Second); len(s.recebidas()) == 0 && time.Now().Before(limite); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	n.encerrar()
+
+	m := s.recebidas()
+	if len(m) != 1 {
+		t.Fatalf("%d mensagem(ns), esperado 1 depois de 2 recusas", len(m))
+	}
+	if strings.Join(m[0].para, ",") != "a@stef.com,c@stef.com" {
+		t.Errorf("destinatários = %v", m[0].para)
+	}
+	if !strings.Contains(m[0].dados, "chamados_plan1.log") || !strings.Contains(m[0].dados, "QLIK - Planilha com erro") {
+		t.Errorf("mensagem sem o assunto ou o anexo:\n%s", m[0].dados)
+	}
+}
+
+func TestNotificadorSMTPVerificaOCertificado(t *testing.T) {
+	usarEmails(t)
+	s := novoSMTPLocal(t, 0)
+	n := novoNotificadorSMTP(configSMTPLocal(s, ""))
+	m := n.novaMensagem([]string{"a@stef.com"}, "assunto", "corpo", nil)
+	err := n.enviarMensagem(m, nil)
+	if err == nil || len(s.recebidas()) != 0 {
+		t.Fatalf("envio para servidor com certificado desconhecido: %v", err)
+	}
+	var desconhecido x509.UnknownAuthorityError
+	if !errors.As(err, &desconhecido) && !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("erro = %v, esperado falha na verificação do certificado", err)
+	}
+}
+
+func TestNotificadorSMTPNaoTravaQuemNotifica(t *testing.T) {
+	usarEmails(t)
+	var mu sync.Mutex
+	tentativas := 0
+	n := &notificadorSMTP{tentativas: 3, intervalo: time.Hour, fila: novaFilaEntrega(10), enviar: func(m ...*gomail.Message) error {
+		mu.Lock()
+		tentativas++
+		mu.Unlock()
+		return errors.New("servidor fora do ar")
+	}}
+
+	inicio := time.Now()
+	n.Notificar(notificacao{Evento: eventoFalha, Chave: "chamados", Arquivo: "a.xlsx"})
+	n.Notificar(notificacao{Evento: eventoSucesso, Chave: "chamados", Arquivo: "b.xlsx"})
+	if d := time.Since(inicio); d > time.Second {
+		t.Errorf("Notificar esperou o envio: %s", d)
+	}
+	n.encerrar()
+	if d := time.Since(inicio); d > 10*time.Second {
+		t.Errorf("encerrar esperou o intervalo entre tentativas: %s", d)
+	}
+	if tentativas != 1 {
+		t.Errorf("%d tentativa(s), esperado 1 (só falha gera e-mail; o encerramento corta as esperas)", tentativas)
+	}
+}
+
+func TestNotificadorSMTPSemDestinatario(t *testing.T) {
+	usarEmails(t)
+	n := &notificadorSMTP{tentativas: 1, fila: novaFilaEntrega(1), enviar: func(m ...*gomail.Message) error { return nil }}
+	defer n.encerrar()
+	if err := n.Notificar(notificacao{Evento: eventoFalha, Chave: "outro"}); err == nil {
+		t.Error("falha sem e-mail cadastrado não deu erro")
+	}
+}
+
+func TestDestinatariosEmail(t *testing.T) {
+	email = map[string][]string{"chamados": {"stef", " a@stef.com", "b@stef.com", ""}}
+	defer func() { email = nil }()
+	if d := strings.Join(destinatariosEmail("chamados"), ","); d != "a@stef.com,b@stef.com" {
+		t.Errorf("destinatariosEmail = %s", d)
+	}
+	if d := destinatariosEmail("outro"); len(d) != 0 {
+		t.Errorf("destinatariosEmail de chave sem cadastro = %v", d)
+	}
+}
+
+func TestCarregarCertificadoCA(t *testing.T) {
+	s := novoSMTPLocal(t, 0)
+	invalido := filepath.Join(t.TempDir(), "ca.pem")
+	ioutil.WriteFile(invalido, []byte("não é um certificado"), 0644)
+
+	if pool, err := carregarCertificadoCA(s.ca); err != nil || pool == nil {
+		t.Errorf("certificado válido recusado: %v", err)
+	}
+	if _, err := carregarCertificadoCA(invalido); err == nil || !strings.Contains(err.Error(), invalido) {
+		t.Errorf("arquivo sem certificado: erro %v, esperado citando %s", err, invalido)
+	}
+	if _, err := carregarCertificadoCA(invalido + ".ausente"); err == nil {
+		t.Error("arquivo ausente aceito")
+	}
+
+	cfg := configPadrao()
+	cfg.Configuracao.Metadados.NomeArquivo = "MetaDados.xlsx"
+	cfg.Configuracao.EnviarEmail = true
+	cfg.Configuracao.Email = configSMTPLocal(s, invalido)
+	if p := validarConfiguracao(cfg); len(p) != 1 || !strings.HasPrefix(p[0], "email.certificadoca") {
+		t.Errorf("validarConfiguracao = %v, esperado só email.certificadoca", p)
+	}
+	cfg.Configuracao.Email.CertificadoCA = s.ca
+	if p := validarConfiguracao(cfg); len(p) != 0 {
+		t.Errorf("validarConfiguracao com certificado válido = %v", p)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Arquivos compactados (.zip, .tar.gz/.tgz e .gz) recebidos em
+// PlanilhasAImportar: as planilhas são extraídas para
+// diretorios.extracao\<pasta>\<pacote> e entram na fila com essa pasta, que
+// também é usada nos diretórios de saída. O pacote fica em PlanilhasAImportar
+// até o último membro terminar e então vai para PlanilhasImportadas (todos
+// importados ou duplicados) ou PlanilhasComErro, com o resultado de cada membro no ledger.
+type pacoteEmAndamento struct {
+	tarefa    tarefa
+	pasta     string            // pasta dos membros, relativa a diretorios.extracao
+	membros   map[string]string // nome do membro (minúsculo, sem extensão) → status no ledger
+	nomes     []string
+	ignorados []string
+	restantes int
+}
+
+var (
+	pacotesMu sync.Mutex
+	pacotes   = make(map[string]*pacoteEmAndamento)
+)
+
+func extensaoPacote(nome string) string {
+	nome = strings.ToLower(nome)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip", ".gz"} {
+		if strings.HasSuffix(nome, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// abrirPacote extrai as planilhas do pacote e coloca cada uma na fila. Um
+// pacote que já está sendo processado (passada anterior do modo contínuo) é
+// ignorado.
+func abrirPacote(t tarefa) {
+	chave := caminhoNaPasta(t.Pasta, t.Arquivo)
+	pacotesMu.Lock()
+	_, emAndamento := pacotes[chave]
+	pacotesMu.Unlock()
+	if emAndamento {
+		return
+	}
+
+	ext := extensaoPacote(t.Arquivo)
+	p := &pacoteEmAndamento{tarefa: t, pasta: caminhoNaPasta(t.Pasta, t.Arquivo[:len(t.Arquivo)-len(ext)]), membros: make(map[string]string)}
+	destino := criarPasta(config.Configuracao.Diretorios.Extracao, p.pasta)
+	nomes, ignorados, err := extrairPacote(fmt.Sprintf("%s\\%s", t.origem(), t.Arquivo), ext, destino)
+	if err == nil && len(nomes) == 0 {
+		err = fmt.Errorf("nenhuma planilha no arquivo compactado")
+	}
+	if err != nil {
+		fmt.Println("ERRO: ", fmt.Sprintf("%s\\%s", t.origem(), t.Arquivo), err.Error())
+		os.RemoveAll(destino)
+		motivos := append([]string{fmt.Sprintf("Não foi possível extrair as planilhas: %s", err.Error())}, ignorados...)
+		moverPacote(t, config.Configuracao.Diretorios.PlanilhasComErro)
+		registrarLedger(registroLedger{Arquivo: t.Arquivo, Pasta: t.Pasta, Status: eventoFalha, Causa: causaConteudo, Motivos: motivos})
+		notificar(notificacao{Evento: eventoFalha, Arquivo: t.Arquivo, Mensagem: config.Configuracao.Email.Mensagem, Motivos: motivos})
+		return
+	}
+
+	p.nomes, p.ignorados, p.restantes = nomes, ignorados, len(nomes)
+	for _, nome := range nomes {
+		p.membros[nomeSemExtensao(strings.ToLower(nome))] = ""
+	}
+	pacotesMu.Lock()
+	pacotes[chave] = p
+	pacotesMu.Unlock()
+
+	// Mantém a passada aberta até o pacote ser movido.
+	wg.Add(1)
+	fmt.Println(fmt.Sprintf("%s: %d planilha(s) extraída(s)", chave, len(nomes)))
+	for _, nome := range nomes {
+		wg.Add(1)
+		tasks <- tarefa{Arquivo: nome, Pasta: p.pasta, Pacote: chave}
+	}
+}
+
+// extrairPacote grava em destino as planilhas do pacote, com nomes únicos, e
+// devolve os nomes gravados e os membros ignorados (não planilhas). A cópia
+// para em entrada.limitemembro por planilha e entrada.limitepacote no total,
+// para um pacote pequeno que se expande muito não encher o disco.
+func extrairPacote(caminho string, ext string, destino string) ([]string, []string, error) {
+	var nomes, ignorados []string
+	var total int64
+	usados := make(map[string]bool)
+	limiteMembro, limitePacote := config.Configuracao.Entrada.LimiteMembro, config.Configuracao.Entrada.LimitePacote
+	membro := func(nome string, r io.Reader) error {
+		nome = path.Base(strings.Replace(nome, "\\", "/", -1))
+		if !strings.Contains(strings.ToLower(nome), ".xls") || ignoradoPorPadrao(nome) {
+			ignorados = append(ignorados, "Ignorado (não é planilha): "+nome)
+			return nil
+		}
+		nome = nomeUnico(nome, usados)
+		f, err := os.Create(fmt.Sprintf("%s\\%s", destino, nome))
+		if err != nil {
+			return err
+		}
+		limite := limiteMembro.Bytes
+		if resta := limitePacote.Bytes - total; resta < limite {
+			limite = resta
+		}
+		n, err := io.Copy(f, io.LimitReader(r, limite+1))
+		total += n
+		if errC := f.Close(); err == nil {
+			err = errC
+		}
+		if err == nil && n > limiteMembro.Bytes {
+			err = fmt.Errorf("%s excede o limite de %s por planilha (entrada.limitemembro)", nome, limiteMembro)
+		} else if err == nil && total > limitePacote.Bytes {
+			err = fmt.Errorf("as planilhas excedem o limite de %s por pacote (entrada.limitepacote)", limitePacote)
+		}
+		if err == nil {
+			nomes = append(nomes, nome)
+		}
+		return err
+	}
+
+	if ext == ".zip" {
+		z, err := zip.OpenReader(caminho)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer z.Close()
+		for _, f := range z.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return nomes, ignorados, err
+			}
+			err = membro(f.Name, r)
+			r.Close()
+			if err != nil {
+				return nomes, ignorados, err
+			}
+		}
+		return nomes, ignorados, nil
+	}
+
+	arquivo, err := os.Open(caminho)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer arquivo.Close()
+	gz, err := gzip.NewReader(arquivo)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gz.Close()
+
+	if ext == ".gz" {
+		nome := gz.Name
+		if nome == "" {
+			nome = path.Base(strings.Replace(caminho, "\\", "/", -1))
+			nome = nome[:len(nome)-len(ext)]
+		}
+		return nomes, ignorados, membro(nome, gz)
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nomes, ignorados, nil
+		}
+		if err != nil {
+			return nomes, ignorados, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := membro(h.Name, tr); err != nil {
+			return nomes, ignorados, err
+		}
+	}
+}
+
+// nomeUnico acrescenta " (n)" quando o pacote tem dois membros com o mesmo
+// nome em pastas diferentes.
+func nomeUnico(nome string, usados map[string]bool) string {
+	base, ext := nome, ""
+	if i := strings.Index(strings.ToLower(nome), ".xls"); i >= 0 {
+		base, ext = nome[:i], nome[i:]
+	}
+	for n := 2; usados[strings.ToLower(nome)]; n++ {
+		nome = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	usados[strings.ToLower(nome)] = true
+	return nome
+}
+
+// anotarMembro guarda o status gravado no ledger para um membro de pacote.
+func anotarMembro(r registroLedger) {
+	pacotesMu.Lock()
+	defer pacotesMu.Unlock()
+	if p, ok := pacotes[r.Pacote]; ok {
+		p.membros[nomeSemExtensao(strings.ToLower(r.Arquivo))] = r.Status
+	}
+}
+
+// concluirMembro é chamado quando o processamento de um membro termina; o
+// último move o pacote e grava o resultado dele.
+func concluirMembro(t tarefa) {
+	pacotesMu.Lock()
+	p, ok := pacotes[t.Pacote]
+	if ok {
+		p.restantes--
+		if p.restantes > 0 {
+			ok = false
+		} else {
+			delete(pacotes, t.Pacote)
+		}
+	}
+	pacotesMu.Unlock()
+	if !ok {
+		return
+	}
+	defer wg.Done()
+
+	status, dir := eventoSucesso, config.Configuracao.Diretorios.PlanilhasImportadas
+	var motivos []string
+	sort.Strings(p.nomes)
+	for _, nome := range p.nomes {
+		s := p.membros[nomeSemExtensao(strings.ToLower(nome))]
+		if s != eventoSucesso && s != eventoDuplicado {
+			status, dir = eventoFalha, config.Configuracao.Diretorios.PlanilhasComErro
+		}
+		if s == "" {
+			s = "sem registro"
+		}
+		motivos = append(motivos, fmt.Sprintf("%s: %s", nome, s))
+	}
+	motivos = append(motivos, p.ignorados...)
+
+	moverPacote(p.tarefa, dir)
+	os.RemoveAll(naPasta(config.Configuracao.Diretorios.Extracao, p.pasta))
+	registrarLedger(registroLedger{Arquivo: p.tarefa.Arquivo, Pasta: p.tarefa.Pasta, Status: status, Motivos: motivos})
+}
+
+func moverPacote(t tarefa, dir string) {
+	erro := os.Rename(fmt.Sprintf("%s\\%s", t.origem(), t.Arquivo), fmt.Sprintf("%s\\%s", criarPasta(dir, t.Pasta), t.Arquivo))
+	if erro != nil {
+		fmt.Println(erro.Error())
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// usarPacotes aponta os diretórios de entrada, saída, extração e ledger para
+// um diretório temporário.
+func usarPacotes(t *testing.T) string {
+	dir := t.TempDir()
+	d := &config.Configuracao.Diretorios
+	d.PlanilhasAImportar = filepath.Join(dir, "entrada")
+	d.PlanilhasImportadas = filepath.Join(dir, "importadas")
+	d.PlanilhasComErro = filepath.Join(dir, "erro")
+	d.Extracao = filepath.Join(dir, "extracao")
+	d.Log = dir
+	config.Configuracao.Entrada.LimiteMembro = tamanho{1 << 20}
+	config.Configuracao.Entrada.LimitePacote = tamanho{1 << 20}
+	t.Cleanup(func() {
+		config.Configuracao.Diretorios = diretorios{}
+		config.Configuracao.Entrada = entradaconfig{}
+		pacotesMu.Lock()
+		pacotes = make(map[string]*pacoteEmAndamento)
+		pacotesMu.Unlock()
+	})
+	return dir
+}
+
+type membroTeste struct {
+	nome     string
+	conteudo string
+}
+
+// gravarPacote cria o arquivo compactado com os membros, no formato da
+// extensão; .gz grava só o primeiro, com o nome no cabeçalho.
+func gravarPacote(t *testing.T, caminho string, membros ...membroTeste) {
+	var buf bytes.Buffer
+	switch extensaoPacote(caminho) {
+	case ".zip":
+		z := zip.NewWriter(&buf)
+		for _, m := range membros {
+			w, _ := z.Create(m.nome)
+			w.Write([]byte(m.conteudo))
+		}
+		z.Close()
+	case ".gz":
+		gz := gzip.NewWriter(&buf)
+		gz.Name = membros[0].nome
+		gz.Write([]byte(membros[0].conteudo))
+		gz.Close()
+	default:
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, m := range membros {
+			tw.WriteHeader(&tar.Header{Name: m.nome, Mode: 0644, Size: int64(len(m.conteudo)), Typeflag: tar.TypeReg})
+			tw.Write([]byte(m.conteudo))
+		}
+		tw.Close()
+		gz.Close()
+	}
+	if err := ioutil.WriteFile(caminho, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtrairPacote(t *testing.T) {
+	membros := []membroTeste{
+		{"norte/vendas.xlsx", "norte"},
+		{"sul/vendas.xlsx", "sul"},
+		{"leiame.txt", "texto"},
+		{"norte/~$vendas.xlsx", "trava"},
+	}
+	casos := []struct {
+		arquivo   string
+		nomes     []string
+		conteudos []string
+		ignorados int
+	}{
+		{"pacote.zip", []string{"vendas.xlsx", "vendas (2).xlsx"}, []string{"norte", "sul"}, 2},
+		{"pacote.tar.gz", []string{"vendas.xlsx", "vendas (2).xlsx"}, []string{"norte", "sul"}, 2},
+		{"pacote.tgz", []string{"vendas.xlsx", "vendas (2).xlsx"}, []string{"norte", "sul"}, 2},
+		{"vendas.xlsx.gz", []string{"vendas.xlsx"}, []string{"norte"}, 0},
+	}
+	for _, c := range casos {
+		dir := usarPacotes(t)
+		caminho := filepath.Join(dir, c.arquivo)
+		gravarPacote(t, caminho, membros...)
+
+		nomes, ignorados, err := extrairPacote(caminho, extensaoPacote(c.arquivo), dir)
+		if err != nil {
+			t.Fatalf("%s: %v", c.arquivo, err)
+		}
+		if !reflect.DeepEqual(nomes, c.nomes) || len(ignorados) != c.ignorados {
+			t.Errorf("%s: nomes %q ignorados %q, esperado %q e %d ignorado(s)", c.arquivo, nomes, ignorados, c.nomes, c.ignorados)
+			continue
+		}
+		for i, nome := range nomes {
+			b, err := ioutil.ReadFile(fmt.Sprintf("%s\\%s", dir, nome))
+			if err != nil || string(b) != c.conteudos[i] {
+				t.Errorf("%s: %s = %q (%v), esperado %q", c.arquivo, nome, b, err, c.conteudos[i])
+			}
+		}
+	}
+}
+
+func TestExtrairPacoteGzSemNome(t *testing.T) {
+	dir := usarPacotes(t)
+	caminho := filepath.Join(dir, "vendas.xlsx.gz")
+	gravarPacote(t, caminho, membroTeste{"", "conteudo"})
+
+	nomes, _, err := extrairPacote(caminho, ".gz", dir)
+	if err != nil || !reflect.DeepEqual(nomes, []string{"vendas.xlsx"}) {
+		t.Errorf("nomes %q (%v), esperado o nome do arquivo sem .gz", nomes, err)
+	}
+}
+
+func TestExtrairPacoteLimites(t *testing.T) {
+	casos := []struct {
+		membro, pacote int64
+		erro           string
+	}{
+		{9, 100, "entrada.limitemembro"},
+		{100, 15, "entrada.limitepacote"},
+		{10, 20, ""},
+	}
+	for _, c := range casos {
+		for _, arquivo := range []string{"pacote.zip", "pacote.tar.gz"} {
+			dir := usarPacotes(t)
+			config.Configuracao.Entrada.LimiteMembro = tamanho{c.membro}
+			config.Configuracao.Entrada.LimitePacote = tamanho{c.pacote}
+			caminho := filepath.Join(dir, arquivo)
+			gravarPacote(t, caminho, membroTeste{"a.xlsx", "0123456789"}, membroTeste{"b.xlsx", "0123456789"})
+
+			_, _, err := extrairPacote(caminho, extensaoPacote(arquivo), dir)
+			if c.erro == "" && err != nil {
+				t.Errorf("%s membro %d pacote %d: erro inesperado %v", arquivo, c.membro, c.pacote, err)
+			}
+			if c.erro != "" && (err == nil || !strings.Contains(err.Error(), c.erro)) {
+				t.Errorf("%s membro %d pacote %d: erro %v, esperado %s", arquivo, c.membro, c.pacote, err, c.erro)
+			}
+		}
+	}
+}
+
+func TestAbrirPacoteAcimaDoLimite(t *testing.T) {
+	usarPacotes(t)
+	config.Configuracao.Entrada.LimiteMembro = tamanho{5}
+	os.MkdirAll(config.Configuracao.Diretorios.PlanilhasAImportar, 0755)
+	os.MkdirAll(config.Configuracao.Diretorios.PlanilhasComErro, 0755)
+	os.MkdirAll(config.Configuracao.Diretorios.Extracao, 0755)
+	gravarPacote(t, fmt.Sprintf("%s\\pacote.zip", config.Configuracao.Diretorios.PlanilhasAImportar), membroTeste{"a.xlsx", "0123456789"})
+
+	abrirPacote(tarefa{Arquivo: "pacote.zip"})
+
+	if _, err := os.Stat(fmt.Sprintf("%s\\pacote.zip", config.Configuracao.Diretorios.PlanilhasComErro)); err != nil {
+		t.Errorf("pacote não foi para PlanilhasComErro: %v", err)
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s\\pacote", config.Configuracao.Diretorios.Extracao)); !os.IsNotExist(err) {
+		t.Errorf("extração parcial não foi removida: %v", err)
+	}
+	registros, err := lerLedger()
+	if err != nil || len(registros) != 1 {
+		t.Fatalf("ledger %+v (%v), esperado um registro", registros, err)
+	}
+	r := registros[0]
+	if r.Arquivo != "pacote.zip" || r.Status != eventoFalha || len(r.Motivos) == 0 || !strings.Contains(r.Motivos[0], "entrada.limitemembro") {
+		t.Errorf("registro %+v, esperado falha por entrada.limitemembro", r)
+	}
+	if len(pacotes) != 0 {
+		t.Errorf("pacote com falha ficou em andamento: %v", pacotes)
+	}
+}
+
+func TestNomeUnico(t *testing.T) {
+	usados := make(map[string]bool)
+	casos := []struct{ nome, esperado string }{
+		{"vendas.xlsx", "vendas.xlsx"},
+		{"Vendas.XLSX", "Vendas (2).XLSX"},
+		{"vendas.xlsx", "vendas (3).xlsx"},
+		{"vendas.xls", "vendas.xls"},
+		{"vendas (2).xlsx", "vendas (2) (2).xlsx"},
+		{"vendas.xlsx.bak.xlsx", "vendas.xlsx.bak.xlsx"},
+	}
+	for _, c := range casos {
+		if r := nomeUnico(c.nome, usados); r != c.esperado {
+			t.Errorf("nomeUnico(%q) = %q, esperado %q", c.nome, r, c.esperado)
+		}
+	}
+}
+
+// TestConcluirMembroMoveSoNoFim confere que o pacote fica em
+// PlanilhasAImportar até o último membro terminar e vai para o destino do pior
+// resultado entre eles.
+func TestConcluirMembroMoveSoNoFim(t *testing.T) {
+	casos := []struct {
+		segundo string
+		destino func() string
+		status  string
+	}{
+		{eventoSucesso, func() string { return config.Configuracao.Diretorios.PlanilhasImportadas }, eventoSucesso},
+		{eventoDuplicado, func() string { return config.Configuracao.Diretorios.PlanilhasImportadas }, eventoSucesso},
+		{eventoFalha, func() string { return config.Configuracao.Diretorios.PlanilhasComErro }, eventoFalha},
+		{"", func() string { return config.Configuracao.Diretorios.PlanilhasComErro }, eventoFalha},
+	}
+	for _, c := range casos {
+		usarPacotes(t)
+		d := config.Configuracao.Diretorios
+		for _, dir := range []string{d.PlanilhasAImportar, d.PlanilhasImportadas, d.PlanilhasComErro, d.Extracao} {
+			os.MkdirAll(dir, 0755)
+		}
+		origem := fmt.Sprintf("%s\\pacote.zip", d.PlanilhasAImportar)
+		ioutil.WriteFile(origem, []byte("zip"), 0644)
+		pacotes["pacote.zip"] = &pacoteEmAndamento{
+			tarefa:    tarefa{Arquivo: "pacote.zip"},
+			pasta:     "pacote",
+			membros:   map[string]string{"a": "", "b": ""},
+			nomes:     []string{"a.xlsx", "b.xlsx"},
+			restantes: 2,
+		}
+		wg.Add(1)
+
+		registrarLedger(registroLedger{Arquivo: "a.xlsx", Pasta: "pacote", Pacote: "pacote.zip", Status: eventoSucesso})
+		concluirMembro(tarefa{Arquivo: "a.xlsx", Pasta: "pacote", Pacote: "pacote.zip"})
+		if _, err := os.Stat(origem); err != nil {
+			t.Fatalf("%q: pacote movido antes do último membro: %v", c.segundo, err)
+		}
+
+		if c.segundo != "" {
+			registrarLedger(registroLedger{Arquivo: "b.xlsx", Pasta: "pacote", Pacote: "pacote.zip", Status: c.segundo})
+		}
+		concluirMembro(tarefa{Arquivo: "b.xlsx", Pasta: "pacote", Pacote: "pacote.zip"})
+		if _, err := os.Stat(fmt.Sprintf("%s\\pacote.zip", c.destino())); err != nil {
+			t.Errorf("%q: pacote não foi para o destino: %v", c.segundo, err)
+		}
+		registros, _ := lerLedger()
+		ultimo := registros[len(registros)-1]
+		if ultimo.Arquivo != "pacote.zip" || ultimo.Status != c.status {
+			t.Errorf("%q: último registro %+v, esperado pacote.zip com %s", c.segundo, ultimo, c.status)
+		}
+		if _, ok := pacotes["pacote.zip"]; ok {
+			t.Errorf("%q: pacote continua em andamento", c.segundo)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tealeg/xlsx"
+)
+
+// imprimirPrevia mostra como carregaPlan vai mapear cada aba de xlFile: origem
+// → Para, colunas descartadas, obrigatórias ausentes, colunas preenchidas com
+// valor padrão e as primeiras linhas da saída. Não grava nem move nada.
+// Devolve false quando a conversão falharia.
+func imprimirPrevia(w io.Writer, arq []string, xlFile *xlsx.File, filtroSheet string, linhas int) bool {
+	ok := true
+	fmt.Fprintf(w, "Arquivo: %s\nChave: %s\n", arq[1], arq[2])
+	if e, achou := email[arq[2]]; achou {
+		fmt.Fprintf(w, "Empresa (e-mail): %s\n", e[0])
+	}
+	if c := capturarNomeArquivo(arq[2], arq[1]); len(c.Colunas) > 0 {
+		var valores []string
+		for i, col := range c.Colunas {
+			valores = append(valores, fmt.Sprintf("%s=%q", col, c.Valores[i]))
+		}
+		fmt.Fprintf(w, "Capturado do nome: %s\n", strings.Join(valores, ", "))
+		if c.Periodo() == "" {
+			fmt.Fprintln(w, "Sem período no nome: o CSV mantém o nome do arquivo.")
+		}
+	}
+
+	for _, sheet := range xlFile.Sheets {
+		if filtroSheet != "" && !strings.EqualFold(sheet.Name, filtroSheet) {
+			continue
+		}
+		fmt.Fprintf(w, "\n== Aba %s ==\n", sheet.Name)
+		emp, achou := dic[arq[2]+"|"+strings.ToLower(sheet.Name)]
+		if !achou {
+			fmt.Fprintln(w, "Sem metadado para esta aba; ela seria ignorada.")
+			continue
+		}
+		fmt.Fprintf(w, "Empresa: %s  Agrupador: %s\n", emp[0], emp[1])
+		if _, temDic := est[emp[1]]; !temDic {
+			fmt.Fprintln(w, "O agrupador não tem dicionário; as colunas passam sem mapeamento.")
+		}
+
+		origem := cabecalhoDaAba(sheet)
+		m := mapearCabecalho(emp, sheet.Name, origem)
+
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "\nORIGEM\tPARA\tTIPO\tOBRIGATÓRIO")
+		for j, c := range origem {
+			if cab, mapeada := m.Mapeadas[j]; mapeada {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c, cab.Para, cab.Tipo, cab.Obrigatorio)
+			} else if !m.Excluir[j] {
+				fmt.Fprintf(tw, "%s\t%s\t\t\n", c, c)
+			}
+		}
+		tw.Flush()
+
+		var descartadas []string
+		for j, c := range origem {
+			if m.Excluir[j] {
+				descartadas = append(descartadas, c)
+			}
+		}
+		if len(descartadas) > 0 {
+			fmt.Fprintf(w, "\nColunas não mapeadas (descartadas): %s\n", strings.Join(descartadas, ", "))
+		}
+		if len(m.Faltantes) > 0 {
+			ok = false
+			fmt.Fprintln(w, "\nColunas obrigatórias ausentes:")
+			for _, cab := range m.Faltantes {
+				sugestoes := ""
+				if s := colunasParecidas(cab.De, origem, 3); len(s) > 0 {
+					sugestoes = fmt.Sprintf(" (parecidas: %s)", strings.Join(s, ", "))
+				}
+				fmt.Fprintf(w, "  %s → %s%s\n", cab.De, cab.Para, sugestoes)
+			}
+		}
+		if len(m.Complemento) > 0 {
+			fmt.Fprintln(w, "\nColunas preenchidas com valor padrão:")
+			for i, cab := range m.Complemento {
+				fmt.Fprintf(w, "  %s = %q\n", cab.Para, m.Padroes[i])
+			}
+		}
+
+		var erros bytes.Buffer
+		relatorio := novoRelatorioErro(arq[1])
+		plan := carregaPlan(log.New(&erros, "", 0), relatorio, arq, sheet)
+		if len(plan) == 0 {
+			if len(m.Faltantes) > 0 {
+				fmt.Fprintln(w, "\nA conversão desta aba seria interrompida.")
+			}
+			continue
+		}
+		if len(plan)-1 > linhas {
+			plan = plan[:linhas+1]
+		}
+		fmt.Fprintf(w, "\nPrimeiras %d linha(s) da saída:\n", len(plan)-1)
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, l := range plan {
+			fmt.Fprintln(tw, strings.Join(l, "\t"))
+		}
+		tw.Flush()
+
+		var problemas []string
+		for _, p := range relatorio.Problemas {
+			if p.Linha <= linhas+1 {
+				problemas = append(problemas, fmt.Sprintf("  linha %d [%s] %s", p.Linha, p.Coluna, p.Mensagem))
+			}
+		}
+		if len(problemas) > 0 {
+			fmt.Fprintf(w, "\nProblemas nessas linhas:\n%s\n", strings.Join(problemas, "\n"))
+		}
+	}
+	return ok
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/tealeg/xlsx"
+)
+
+func usarDicionarioChamados(t *testing.T) {
+	dic = map[string][]string{"chamados stef|plan1": {"stef", "chamados"}}
+	est = map[string][]*dicionario{"chamados": {
+		{Empresa: "stef", Sheet: "plan1", De: "id", Para: "Id", Tipo: "n", Obrigatorio: "s"},
+		{Empresa: "stef", Sheet: "plan1", De: "titulo", Para: "Titulo"},
+		{Empresa: "stef", Sheet: "plan1", De: "valor", Para: "Valor", Tipo: "n"},
+		{Empresa: "stef", Sheet: "plan1", De: "prioridade", Para: "Prioridade", Obrigatorio: "n"},
+	}}
+	t.Cleanup(func() { dic, est = nil, nil })
+}
+
+func planilhaDe(linhas ...[]string) *xlsx.File {
+	f := xlsx.NewFile()
+	sheet, _ := f.AddSheet("plan1")
+	for _, l := range linhas {
+		row := sheet.AddRow()
+		for _, v := range l {
+			row.AddCell().SetString(v)
+		}
+	}
+	return f
+}
+
+func TestImprimirPrevia(t *testing.T) {
+	usarDicionarioChamados(t)
+	f := planilhaDe(
+		[]string{"ID", "Titulo", "Solicitante"},
+		[]string{"1", "Impressora", "ana"},
+		[]string{"2", "Rede", "bia"},
+		[]string{"3", "VPN", "caio"},
+	)
+	var w bytes.Buffer
+	if !imprimirPrevia(&w, []string{"chamados", "chamados stef.xlsx", "chamados stef"}, f, "", 2) {
+		t.Fatalf("prévia com todas as obrigatórias deu falha:\n%s", w.String())
+	}
+	saida := w.String()
+	for _, esperado := range []string{
+		"Colunas não mapeadas (descartadas): Solicitante",
+		"Colunas preenchidas com valor padrão:\n  Valor = \"0\"",
+		"Primeiras 2 linha(s) da saída:",
+	} {
+		if !strings.Contains(saida, esperado) {
+			t.Errorf("prévia sem %q:\n%s", esperado, saida)
+		}
+	}
+	if strings.Contains(saida, "Prioridade") {
+		t.Errorf("coluna opcional sem padrão apareceu na prévia:\n%s", saida)
+	}
+	if !strings.Contains(saida, "Rede") || strings.Contains(saida, "VPN") {
+		t.Errorf("prévia não mostra só as 2 primeiras linhas:\n%s", saida)
+	}
+}
+
+func TestImprimirPreviaObrigatoriaAusente(t *testing.T) {
+	usarDicionarioChamados(t)
+	f := planilhaDe([]string{"Identificador", "Titulo"}, []string{"1", "Impressora"})
+	var w bytes.Buffer
+	if imprimirPrevia(&w, []string{"chamados", "chamados stef.xlsx", "chamados stef"}, f, "", 5) {
+		t.Fatalf("prévia sem a coluna id não deu falha:\n%s", w.String())
+	}
+	saida := w.String()
+	if !strings.Contains(saida, "Colunas obrigatórias ausentes:\n  id → Id") || !strings.Contains(saida, "interrompida") {
+		t.Errorf("prévia sem a obrigatória ausente:\n%s", saida)
+	}
+	if strings.Contains(saida, "Primeiras") {
+		t.Errorf("prévia mostrou linhas de uma aba que não seria convertida:\n%s", saida)
+	}
+}
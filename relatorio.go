@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tealeg/xlsx"
+)
+
+const maxProblemasPorPlanilha = 200
+
+// problemaPlanilha é uma linha do relatório de erro enviado ao cliente.
+// Linha 0 indica um problema de estrutura (arquivo ou cabeçalho).
+type problemaPlanilha struct {
+	Sheet     string
+	Linha     int
+	Coluna    string
+	Sugestoes []string
+	Mensagem  string
+}
+
+// relatorioErro acumula os problemas encontrados ao interpretar um arquivo.
+// Cada arquivo é interpretado por uma única goroutine, então não há trava.
+type relatorioErro struct {
+	Arquivo   string
+	Data      time.Time
+	Problemas []problemaPlanilha
+	Anexos    []string
+	porSheet  map[string]int
+	causa     string
+}
+
+func novoRelatorioErro(arquivo string) *relatorioErro {
+	return &relatorioErro{Arquivo: arquivo, Data: time.Now(), porSheet: make(map[string]int)}
+}
+
+func (r *relatorioErro) erroArquivo(mensagem string) {
+	r.Problemas = append(r.Problemas, problemaPlanilha{Mensagem: mensagem})
+}
+
+// erroTransitorio registra uma falha de leitura que deve passar sozinha
+// (arquivo em uso, rede), para o arquivo ser reprocessado com espera.
+func (r *relatorioErro) erroTransitorio(mensagem string) {
+	r.erroArquivo(mensagem)
+	if r.causa == "" {
+		r.causa = causaTransitoria
+	}
+}
+
+// causaFalha classifica a falha para o reprocessamento: metadado prevalece.
+func (r *relatorioErro) causaFalha() string {
+	if r.causa == "" {
+		return causaConteudo
+	}
+	return r.causa
+}
+
+// colunaFaltante registra uma coluna obrigatória ausente e sugere as colunas
+// mais parecidas existentes no cabeçalho da planilha.
+func (r *relatorioErro) colunaFaltante(sheet string, de string, cabecalho []string) {
+	r.Problemas = append(r.Problemas, problemaPlanilha{
+		Sheet:     sheet,
+		Coluna:    de,
+		Sugestoes: colunasParecidas(de, cabecalho, 3),
+		Mensagem:  "Coluna obrigatória não encontrada na planilha.",
+	})
+	r.causa = causaMetadado
+}
+
+func (r *relatorioErro) problemaLinha(sheet string, linha int, coluna string, mensagem string) {
+	if r.porSheet[sheet] >= maxProblemasPorPlanilha {
+		return
+	}
+	r.porSheet[sheet]++
+	if r.porSheet[sheet] == maxProblemasPorPlanilha {
+		mensagem = fmt.Sprintf("%s (limite de %d problemas por planilha atingido)", mensagem, maxProblemasPorPlanilha)
+	}
+	r.Problemas = append(r.Problemas, problemaPlanilha{Sheet: sheet, Linha: linha, Coluna: coluna, Mensagem: mensagem})
+}
+
+// juntar acrescenta os problemas de uma aba lida em paralelo (ver fluxo.go).
+func (r *relatorioErro) juntar(o *relatorioErro) {
+	r.Problemas = append(r.Problemas, o.Problemas...)
+	if o.causa == causaMetadado || r.causa == "" {
+		if o.causa != "" {
+			r.causa = o.causa
+		}
+	}
+}
+
+// motivos resume os problemas em até 10 linhas de texto.
+func (r *relatorioErro) motivos() []string {
+	var m []string
+	for i, p := range r.Problemas {
+		if i == 10 {
+			m = append(m, fmt.Sprintf("... e mais %d problema(s).", len(r.Problemas)-10))
+			break
+		}
+		texto := p.Mensagem
+		if p.Coluna != "" {
+			texto = fmt.Sprintf("[%s] %s", p.Coluna, texto)
+		}
+		if p.Sheet != "" {
+			texto = fmt.Sprintf("%s: %s", p.Sheet, texto)
+		}
+		m = append(m, texto)
+	}
+	return m
+}
+
+// Conclusao explica no relatório, pela causa da falha, o que impediu a
+// importação; problemas de linha são só avisos e não falham o arquivo.
+func (r *relatorioErro) Conclusao() []string {
+	var c []string
+	switch r.causaFalha() {
+	case causaMetadado:
+		c = append(c, "A importação foi interrompida porque colunas obrigatórias não foram encontradas na planilha.")
+	case causaTransitoria:
+		c = append(c, "O arquivo não pôde ser lido ou gravado no momento. Uma nova tentativa será feita automaticamente.")
+	default:
+		c = append(c, "O arquivo não pôde ser importado pelos problemas de arquivo ou de estrutura abaixo.")
+	}
+	for _, p := range r.Problemas {
+		if p.Linha > 0 {
+			c = append(c, "Os problemas com número de linha são avisos sobre os valores e, sozinhos, não impedem a importação.")
+			break
+		}
+	}
+	return c
+}
+
+// gravar gera <nome>_erro.html e <nome>_erro.xlsx no diretório informado e guarda
+// os caminhos em Anexos para a notificação.
+func (r *relatorioErro) gravar(dir string, nome string) error {
+	sort.SliceStable(r.Problemas, func(i, j int) bool {
+		if r.Problemas[i].Sheet != r.Problemas[j].Sheet {
+			return r.Problemas[i].Sheet < r.Problemas[j].Sheet
+		}
+		if r.Problemas[i].Linha != r.Problemas[j].Linha {
+			return r.Problemas[i].Linha < r.Problemas[j].Linha
+		}
+		return r.Problemas[i].Coluna < r.Problemas[j].Coluna
+	})
+
+	nomeHTML := fmt.Sprintf("%s\\%s_erro.html", dir, nome)
+	f, err := os.Create(nomeHTML)
+	if err != nil {
+		return err
+	}
+	err = modeloRelatorio.Execute(f, r)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	r.Anexos = append(r.Anexos, nomeHTML)
+
+	xl := xlsx.NewFile()
+	sheet, err := xl.AddSheet("Erros")
+	if err != nil {
+		return err
+	}
+	linha := sheet.AddRow()
+	for _, c := range []string{"Planilha", "Linha", "Coluna esperada", "Sugestões", "Problema"} {
+		linha.AddCell().SetString(c)
+	}
+	for _, p := range r.Problemas {
+		linha = sheet.AddRow()
+		linha.AddCell().SetString(p.Sheet)
+		if p.Linha > 0 {
+			linha.AddCell().SetInt(p.Linha)
+		} else {
+			linha.AddCell().SetString("")
+		}
+		linha.AddCell().SetString(p.Coluna)
+		linha.AddCell().SetString(strings.Join(p.Sugestoes, ", "))
+		linha.AddCell().SetString(p.Mensagem)
+	}
+	nomeXLSX := fmt.Sprintf("%s\\%s_erro.xlsx", dir, nome)
+	if err := xl.Save(nomeXLSX); err != nil {
+		return err
+	}
+	r.Anexos = append(r.Anexos, nomeXLSX)
+	return nil
+}
+
+var modeloRelatorio = template.Must(template.New("relatorio").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Erros - {{.Arquivo}}</title>
+<style>
+body { font-family: Arial, sans-serif; font-size: 13px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #999; padding: 4px 8px; text-align: left; }
+th { background: #ddd; }
+</style></head>
+<body>
+<h2>Planilha {{.Arquivo}}</h2>
+<p>Processada em {{.Data.Format "02/01/2006 15:04"}}.</p>
+{{range .Conclusao}}<p>{{.}}</p>
+{{end}}
+<table>
+<tr><th>Planilha</th><th>Linha</th><th>Coluna esperada</th><th>Colunas parecidas encontradas</th><th>Problema</th></tr>
+{{range .Problemas}}<tr><td>{{.Sheet}}</td><td>{{if .Linha}}{{.Linha}}{{end}}</td><td>{{.Coluna}}</td><td>{{range $i, $s := .Sugestoes}}{{if $i}}, {{end}}{{$s}}{{end}}</td><td>{{.Mensagem}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+// colunasParecidas devolve até n colunas do cabeçalho com similaridade mínima de 0,5.
+func colunasParecidas(de string, cabecalho []string, n int) []string {
+	type candidato struct {
+		nome  string
+		score float64
+	}
+	var candidatos []candidato
+	for _, c := range cabecalho {
+		if strings.TrimSpace(c) == "" {
+			continue
+		}
+		if s := similaridade(de, c); s >= 0.5 {
+			candidatos = append(candidatos, candidato{c, s})
+		}
+	}
+	sort.SliceStable(candidatos, func(i, j int) bool { return candidatos[i].score > candidatos[j].score })
+	var nomes []string
+	for i := 0; i < len(candidatos) && i < n; i++ {
+		nomes = append(nomes, candidatos[i].nome)
+	}
+	return nomes
+}
+
+// similaridade entre 0 e 1 baseada na distância de Levenshtein, sem diferenciar
+// maiúsculas nem espaços nas pontas. Conter o outro nome vale pelo menos 0,7.
+func similaridade(a string, b string) float64 {
+	a = strings.ToLower(strings.TrimSpace(a))
+	b = strings.ToLower(strings.TrimSpace(b))
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	maior := len(ra)
+	if len(rb) > maior {
+		maior = len(rb)
+	}
+	s := 1 - float64(levenshtein(ra, rb))/float64(maior)
+	if (strings.Contains(a, b) || strings.Contains(b, a)) && s < 0.7 {
+		s = 0.7
+	}
+	return s
+}
+
+func levenshtein(a []rune, b []rune) int {
+	anterior := make([]int, len(b)+1)
+	atual := make([]int, len(b)+1)
+	for j := range anterior {
+		anterior[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		atual[0] = i
+		for j := 1; j <= len(b); j++ {
+			custo := 1
+			if a[i-1] == b[j-1] {
+				custo = 0
+			}
+			atual[j] = min3(anterior[j]+1, atual[j-1]+1, anterior[j-1]+custo)
+		}
+		anterior, atual = atual, anterior
+	}
+	return anterior[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func ehNumero(valor string) bool {
+	valor = strings.TrimSpace(valor)
+	if valor == "" {
+		return true
+	}
+	_, err := strconv.ParseFloat(strings.Replace(valor, ",", ".", 1), 64)
+	return err == nil
+}
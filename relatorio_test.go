@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tealeg/xlsx"
+)
+
+func TestRelatorioGravar(t *testing.T) {
+	r := novoRelatorioErro("chamados <jan>.xlsx")
+	r.problemaLinha("plan1", 7, "valor", `Valor não numérico: "<b>".`)
+	r.colunaFaltante("plan1", "id", []string{"Identificador", "ID Chamado", "Titulo"})
+	r.problemaLinha("plan1", 3, "titulo", "Valor obrigatório em branco.")
+
+	if err := r.gravar(filepath.Join(t.TempDir(), "erros"), "chamados jan"); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Anexos) != 2 {
+		t.Fatalf("anexos = %v", r.Anexos)
+	}
+
+	dat, err := ioutil.ReadFile(r.Anexos[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(dat)
+	for _, esperado := range []string{
+		"Planilha chamados &lt;jan&gt;.xlsx",
+		"colunas obrigatórias não foram encontradas",
+		"não impedem a importação",
+		"&#34;&lt;b&gt;&#34;",
+		"<td>Identificador, ID Chamado</td>",
+	} {
+		if !strings.Contains(html, esperado) {
+			t.Errorf("HTML sem %q:\n%s", esperado, html)
+		}
+	}
+	if strings.Contains(html, "impediram a importação") {
+		t.Error("HTML diz que avisos de linha impediram a importação")
+	}
+
+	xl, err := xlsx.OpenFile(r.Anexos[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var linhas []string
+	for _, row := range xl.Sheets[0].Rows {
+		var valores []string
+		for _, c := range row.Cells {
+			valores = append(valores, c.Value)
+		}
+		linhas = append(linhas, strings.Join(valores, "|"))
+	}
+	esperado := []string{
+		"Planilha|Linha|Coluna esperada|Sugestões|Problema",
+		"plan1||id|Identificador, ID Chamado|Coluna obrigatória não encontrada na planilha.",
+		"plan1|3|titulo||Valor obrigatório em branco.",
+		`plan1|7|valor||Valor não numérico: "<b>".`,
+	}
+	if strings.Join(linhas, "\n") != strings.Join(esperado, "\n") {
+		t.Errorf("xlsx:\n%s\nesperado:\n%s", strings.Join(linhas, "\n"), strings.Join(esperado, "\n"))
+	}
+}
+
+func TestRelatorioConclusaoPorCausa(t *testing.T) {
+	transitorio := novoRelatorioErro("a.xlsx")
+	transitorio.erroTransitorio("arquivo em uso")
+	invalido := novoRelatorioErro("b.xlsx")
+	invalido.erroArquivo("O arquivo não esta no formato xlsx.")
+	casos := map[*relatorioErro]string{transitorio: "nova tentativa", invalido: "problemas de arquivo ou de estrutura"}
+	for r, esperado := range casos {
+		c := r.Conclusao()
+		if len(c) != 1 || !strings.Contains(c[0], esperado) {
+			t.Errorf("%s: conclusão %v, esperado %q", r.Arquivo, c, esperado)
+		}
+	}
+}
+
+func TestColunasParecidas(t *testing.T) {
+	cabecalho := []string{"Titulo", "", "ID Chamado", "Identificador", "Data de abertura", "Id"}
+	casos := []struct {
+		de       string
+		n        int
+		esperado string
+	}{
+		{"id", 3, "Id,ID Chamado,Identificador"},
+		{"id", 1, "Id"},
+		{"título", 3, "Titulo"},
+		{"data abertura", 3, "Data de abertura"},
+		{"valor", 3, ""},
+	}
+	for _, c := range casos {
+		if r := strings.Join(colunasParecidas(c.de, cabecalho, c.n), ","); r != c.esperado {
+			t.Errorf("colunasParecidas(%q, %d) = %q, esperado %q", c.de, c.n, r, c.esperado)
+		}
+	}
+}
+
+func TestSimilaridade(t *testing.T) {
+	casos := []struct {
+		a, b     string
+		min, max float64
+	}{
+		{"Valor", " valor ", 1, 1},
+		{"", "valor", 0, 0},
+		{"valor", "valr", 0.8, 0.8},
+		{"id", "id chamado", 0.7, 0.7},
+		{"abc", "xyz", 0, 0},
+		{"empresa", "empressa", 0.87, 0.89},
+	}
+	for _, c := range casos {
+		if s := similaridade(c.a, c.b); s < c.min || s > c.max {
+			t.Errorf("similaridade(%q, %q) = %.3f, esperado entre %.2f e %.2f", c.a, c.b, s, c.min, c.max)
+		}
+		if s, r := similaridade(c.a, c.b), similaridade(c.b, c.a); s != r {
+			t.Errorf("similaridade não simétrica para %q e %q: %.3f e %.3f", c.a, c.b, s, r)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Causa da falha, gravada no ledger para decidir o reprocessamento automático.
+const (
+	causaMetadado    = "metadado"    // obrigatória ausente: tenta de novo quando o metadado mudar
+	causaTransitoria = "transitoria" // erro de E/S ao abrir: tenta de novo com espera crescente
+	causaConteudo    = "conteudo"    // arquivo inválido: só volta com replay
+)
+
+// reprocessamentoconfig controla o ciclo de vida das planilhas com erro: as
+// falhas de metadado (e as sem metadado) voltam para PlanilhasAImportar quando
+// o metadado muda, as transitórias depois de espera, 2*espera, 4*espera...
+// Com tentativas falhas seguidas, o arquivo vai para PlanilhasQuarentena.
+type reprocessamentoconfig struct {
+	Ativo      bool    `json:"ativo"`
+	Tentativas int     `json:"tentativas"`
+	Espera     duracao `json:"espera"`
+}
+
+// assinaturaMetadado identifica as fontes carregadas (nome, tamanho e data),
+// para recarregar o metadado entre as passadas do modo contínuo.
+var assinaturaMetadado string
+
+func assinaturaFontes(fontes []string) string {
+	var partes []string
+	for _, fonte := range fontes {
+		if info, err := os.Stat(fonte); err == nil {
+			partes = append(partes, fmt.Sprintf("%s|%d|%d", fonte, info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	return strings.Join(partes, ";")
+}
+
+// recarregarMetadadoSeAlterado relê o metadado quando alguma fonte mudou. Um
+// erro aqui não derruba o modo contínuo: fica valendo o metadado anterior.
+func recarregarMetadadoSeAlterado() {
+	if assinaturaFontes(fontesMetadado()) == assinaturaMetadado {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("Erro ao recarregar o metadado - ", r)
+		}
+	}()
+	fmt.Println("Metadado alterado, recarregando.")
+	carregarMetadado()
+}
+
+// historicoFalhas agrupa, por pasta\arquivo (minúsculo e sem extensão), as falhas e
+// cargas sem metadado desde o último sucesso ou quarentena.
+func historicoFalhas() (map[string][]registroLedger, error) {
+	registros, err := lerLedger()
+	if err != nil {
+		return nil, err
+	}
+	historico := make(map[string][]registroLedger)
+	for _, r := range registros {
+		nome := strings.ToLower(caminhoNaPasta(r.Pasta, nomeSemExtensao(strings.ToLower(r.Arquivo))))
+		switch r.Status {
+		case eventoFalha, eventoSemMetadado:
+			historico[nome] = append(historico[nome], r)
+			if r.Status == eventoSemMetadado && r.Empresa != "" {
+				// O arquivo sem metadado é movido com o prefixo "empresa_".
+				historico[strings.ToLower(caminhoNaPasta(r.Pasta, r.Empresa+"_"+nomeSemExtensao(strings.ToLower(r.Arquivo))))] = historico[nome]
+			}
+		case eventoSucesso, eventoQuarentena:
+			delete(historico, nome)
+		}
+	}
+	return historico, nil
+}
+
+// reprocessarPendentes é chamado no início de cada passada, com a fila vazia.
+func reprocessarPendentes() {
+	rp := config.Configuracao.Reprocessamento
+	if !rp.Ativo {
+		return
+	}
+	recarregarMetadadoSeAlterado()
+	historico, err := historicoFalhas()
+	if err != nil {
+		fmt.Println("Erro ao ler o ledger - ", err.Error())
+		return
+	}
+
+	for _, dir := range []string{config.Configuracao.Diretorios.PlanilhasComErro, config.Configuracao.Diretorios.PlanilhasSemMetaDado} {
+		arquivos, err := planilhasParaReprocessar(dir, "*")
+		if err != nil {
+			fmt.Println(err.Error())
+			continue
+		}
+		for _, arquivo := range arquivos {
+			pasta, nome := separarPasta(arquivo)
+			tentativas := historico[strings.ToLower(caminhoNaPasta(pasta, nomeSemExtensao(strings.ToLower(nome))))]
+			if len(tentativas) == 0 {
+				continue
+			}
+			ultima := tentativas[len(tentativas)-1]
+			if len(tentativas) >= rp.Tentativas {
+				colocarEmQuarentena(dir, pasta, nome, tentativas)
+				continue
+			}
+			if !deveReprocessar(ultima, len(tentativas), rp) {
+				continue
+			}
+			destino := nome
+			if ultima.Status == eventoSemMetadado && ultima.Empresa != "" && strings.HasPrefix(strings.ToLower(nome), strings.ToLower(ultima.Empresa)+"_") {
+				destino = nome[len(ultima.Empresa)+1:]
+			}
+			if ultima.Status == eventoSemMetadado {
+				if info, _ := buscarNomeArquivoDaEmpresa(strings.ToLower(destino), empresaDaPasta(pasta)); dicArquivo[info[2]] == "" {
+					continue
+				}
+			}
+			erro := os.Rename(fmt.Sprintf("%s\\%s", dir, arquivo), fmt.Sprintf("%s\\%s", criarPasta(config.Configuracao.Diretorios.PlanilhasAImportar, pasta), destino))
+			if erro != nil {
+				fmt.Println(erro.Error())
+				continue
+			}
+			fmt.Println(fmt.Sprintf("Reprocessando (tentativa %d): %s", len(tentativas)+1, caminhoNaPasta(pasta, destino)))
+		}
+	}
+}
+
+func deveReprocessar(ultima registroLedger, tentativas int, rp reprocessamentoconfig) bool {
+	switch {
+	case ultima.Status == eventoSemMetadado || ultima.Causa == causaMetadado:
+		return ultima.Metadado != versaoMetadadoAtual
+	case ultima.Causa == causaTransitoria:
+		return time.Since(ultima.Data) >= rp.Espera.Duration<<uint(tentativas-1)
+	}
+	return false
+}
+
+// colocarEmQuarentena move o arquivo para PlanilhasQuarentena e grava no
+// ledger o histórico das tentativas. replay -quarentena o devolve.
+func colocarEmQuarentena(dir string, pasta string, arquivo string, tentativas []registroLedger) {
+	fmt.Println("Quarentena: ", fmt.Sprintf("%s\\%s", naPasta(dir, pasta), arquivo))
+	erro := os.Rename(fmt.Sprintf("%s\\%s", naPasta(dir, pasta), arquivo), fmt.Sprintf("%s\\%s", criarPasta(config.Configuracao.Diretorios.PlanilhasQuarentena, pasta), arquivo))
+	if erro != nil {
+		fmt.Println(erro.Error())
+		return
+	}
+	motivos := []string{fmt.Sprintf("%d tentativa(s) sem sucesso:", len(tentativas))}
+	for i, t := range tentativas {
+		causa := t.Causa
+		if t.Status == eventoSemMetadado {
+			causa = eventoSemMetadado
+		}
+		motivos = append(motivos, fmt.Sprintf("%d. %s [%s] %s", i+1, t.Data.Format("02/01/2006 15:04"), causa, strings.Join(t.Motivos, "; ")))
+	}
+	ultima := tentativas[len(tentativas)-1]
+	registrarLedger(registroLedger{Arquivo: nomeSemExtensao(strings.ToLower(ultima.Arquivo)), Pasta: pasta, Chave: ultima.Chave, Empresa: ultima.Empresa, Status: eventoQuarentena, Motivos: motivos})
+	notificar(notificacao{Evento: eventoQuarentena, Chave: ultima.Chave, Empresa: ultima.Empresa, Arquivo: arquivo, Motivos: motivos})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resumoconfig ativa o modo resumo: em vez de um e-mail por falha, os eventos
+// são acumulados por empresa e enviados uma vez a cada Janela para cada
+// destinatário. Destinatarios recebe também os arquivos sem metadado.
+type resumoconfig struct {
+	Ativo         bool     `json:"ativo"`
+	Janela        duracao  `json:"janela"`
+	Destinatarios []string `json:"destinatarios"`
+}
+
+type itemResumo struct {
+	Data     time.Time `json:"data"`
+	Evento   string    `json:"evento"`
+	Empresa  string    `json:"empresa"`
+	Chave    string    `json:"chave"`
+	Arquivo  string    `json:"arquivo"`
+	Motivos  []string  `json:"motivos,omitempty"`
+	Provavel string    `json:"provavel,omitempty"`
+	Score    float64   `json:"score,omitempty"`
+}
+
+// resumoPendente é mantido em Log\resumo_pendente.json para sobreviver entre
+// execuções. Itens são os eventos da janela atual; Destinatarios, os itens de
+// envios que falharam, que voltam na próxima janela só para quem não os recebeu.
+type resumoPendente struct {
+	Inicio        time.Time               `json:"inicio"`
+	Itens         []itemResumo            `json:"itens"`
+	Destinatarios map[string][]itemResumo `json:"destinatarios,omitempty"`
+}
+
+type notificadorResumo struct {
+	cfg      resumoconfig
+	smtp     *notificadorSMTP
+	janela   time.Duration
+	arquivo  string
+	mu       sync.Mutex
+	pendente resumoPendente
+}
+
+var resumoDiario *notificadorResumo
+
+func novoNotificadorResumo(cfg resumoconfig, smtp *notificadorSMTP) *notificadorResumo {
+	r := &notificadorResumo{
+		cfg:     cfg,
+		smtp:    smtp,
+		janela:  cfg.Janela.Duration,
+		arquivo: fmt.Sprintf("%s\\resumo_pendente.json", config.Configuracao.Diretorios.Log),
+	}
+	if r.janela <= 0 {
+		r.janela = 24 * time.Hour
+	}
+	if dat, err := ioutil.ReadFile(r.arquivo); err == nil {
+		if err := json.Unmarshal(dat, &r.pendente); err != nil {
+			fmt.Println("Erro ao ler o resumo pendente - ", err.Error())
+		}
+	}
+	if r.pendente.Inicio.IsZero() {
+		r.pendente.Inicio = time.Now()
+	}
+	return r
+}
+
+func (r *notificadorResumo) Notificar(n notificacao) error {
+	if n.Evento == eventoResumo {
+		return nil
+	}
+	item := itemResumo{Data: time.Now(), Evento: n.Evento, Empresa: n.Empresa, Chave: n.Chave, Arquivo: n.Arquivo, Motivos: n.Motivos}
+	if n.Evento == eventoSemMetadado {
+		item.Provavel, _, item.Score = adivinharEmpresa(n.Arquivo)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pendente.Itens = append(r.pendente.Itens, item)
+	return r.salvar()
+}
+
+func (r *notificadorResumo) salvar() error {
+	dat, err := json.MarshalIndent(r.pendente, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.arquivo, dat, 0644)
+}
+
+// enviarSeVencido envia os resumos quando a janela terminou. Os itens de um
+// destinatário com falha de envio continuam pendentes só para ele.
+func (r *notificadorResumo) enviarSeVencido() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.pendente.Inicio) < r.janela {
+		return
+	}
+
+	porDestinatario := make(map[string][]itemResumo)
+	for d, itens := range r.pendente.Destinatarios {
+		porDestinatario[d] = append(porDestinatario[d], itens...)
+	}
+	for _, item := range r.pendente.Itens {
+		dest := append(destinatariosEmail(item.Chave), r.cfg.Destinatarios...)
+		for _, d := range unicos(dest) {
+			porDestinatario[d] = append(porDestinatario[d], item)
+		}
+	}
+
+	restantes := make(map[string][]itemResumo)
+	for dest, itens := range porDestinatario {
+		corpo, err := montarResumo(r.pendente.Inicio, itens)
+		if err == nil {
+			err = r.smtp.enviarMensagem(r.smtp.novaMensagem([]string{dest}, fmt.Sprintf("%s - resumo", config.Configuracao.Email.Titulo), corpo, nil), nil)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Erro ao enviar o resumo para %s - %s", dest, err.Error()))
+			restantes[dest] = itens
+		}
+	}
+
+	r.pendente = resumoPendente{Inicio: time.Now()}
+	if len(restantes) > 0 {
+		r.pendente.Destinatarios = restantes
+	}
+	if err := r.salvar(); err != nil {
+		fmt.Println("Erro ao gravar o resumo pendente - ", err.Error())
+	}
+}
+
+type resumoEmpresa struct {
+	Empresa     string
+	Importadas  int
+	ComErro     int
+	SemMetadado int
+	Itens       []itemResumo
+}
+
+func montarResumo(inicio time.Time, itens []itemResumo) (string, error) {
+	empresas := make(map[string]*resumoEmpresa)
+	var semMetadado []itemResumo
+	for _, item := range itens {
+		if item.Evento == eventoSemMetadado && item.Empresa == "" {
+			semMetadado = append(semMetadado, item)
+			continue
+		}
+		e, ok := empresas[item.Empresa]
+		if !ok {
+			e = &resumoEmpresa{Empresa: item.Empresa}
+			empresas[item.Empresa] = e
+		}
+		switch item.Evento {
+		case eventoSucesso:
+			e.Importadas++
+		case eventoFalha:
+			e.ComErro++
+		case eventoSemMetadado:
+			e.SemMetadado++
+		}
+		e.Itens = append(e.Itens, item)
+	}
+	var lista []*resumoEmpresa
+	for _, e := range empresas {
+		lista = append(lista, e)
+	}
+	sort.Slice(lista, func(i, j int) bool { return lista[i].Empresa < lista[j].Empresa })
+
+	var b bytes.Buffer
+	err := modeloResumo.Execute(&b, struct {
+		Inicio      time.Time
+		Fim         time.Time
+		Empresas    []*resumoEmpresa
+		SemMetadado []itemResumo
+	}{inicio, time.Now(), lista, semMetadado})
+	return b.String(), err
+}
+
+var modeloResumo = template.Must(template.New("resumo").Parse(`<html><body style="font-family: Arial, sans-serif; font-size: 13px;">
+<p>Resumo de {{.Inicio.Format "02/01/2006 15:04"}} a {{.Fim.Format "02/01/2006 15:04"}}.</p>
+{{range .Empresas}}<h3>{{if .Empresa}}{{.Empresa}}{{else}}(sem empresa){{end}}</h3>
+<p>Importadas: {{.Importadas}} &nbsp; Com erro: {{.ComErro}} &nbsp; Sem metadado: {{.SemMetadado}}</p>
+<ul>{{range .Itens}}<li>{{.Arquivo}} - {{.Evento}}{{range .Motivos}}<br>&nbsp;&nbsp;{{.}}{{end}}</li>{{end}}</ul>
+{{end}}{{if .SemMetadado}}<h3>Arquivos sem metadado</h3>
+<table border="1" cellpadding="4" style="border-collapse: collapse;">
+<tr><th>Arquivo</th><th>Empresa provável</th><th>Similaridade</th></tr>
+{{range .SemMetadado}}<tr><td>{{.Arquivo}}</td><td>{{.Provavel}}</td><td>{{printf "%.0f%%" .Score}}</td></tr>
+{{end}}</table>{{end}}
+</body></html>
+`))
+
+// adivinharEmpresa compara o nome do arquivo com as chaves de dicArquivo e devolve
+// a empresa da chave mais parecida, a própria chave e a similaridade em %.
+func adivinharEmpresa(nome string) (string, string, float64) {
+	nome = nomeSemExtensao(nome)
+	melhor, melhorScore := "", 0.0
+	for k := range dicArquivo {
+		if s := similaridade(nome, k); s > melhorScore || (s == melhorScore && k < melhor) {
+			melhor, melhorScore = k, s
+		}
+	}
+	if melhor == "" {
+		return "", "", 0
+	}
+	empresa := ""
+	if emp, ok := dic[dicArquivo[melhor]]; ok {
+		empresa = emp[0]
+	}
+	return empresa, melhor, melhorScore * 100
+}
+
+func unicos(lista []string) []string {
+	vistos := make(map[string]bool)
+	var r []string
+	for _, v := range lista {
+		if v != "" && !vistos[v] {
+			vistos[v] = true
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+func nomeSemExtensao(nome string) string {
+	for _, ext := range []string{".xlsx", ".xlsm", ".xls"} {
+		if strings.HasSuffix(nome, ext) {
+			return strings.TrimSuffix(nome, ext)
+		}
+	}
+	return nome
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// smtpFalso registra os destinatários de cada envio e falha para os de falhar.
+type smtpFalso struct {
+	enviados map[string]int
+	falhar   map[string]bool
+}
+
+func (f *smtpFalso) notificador() *notificadorSMTP {
+	return &notificadorSMTP{tentativas: 1, intervalo: time.Millisecond, enviar: func(m ...*gomail.Message) error {
+		for _, msg := range m {
+			for _, d := range msg.GetHeader("To") {
+				if f.falhar[d] {
+					return errors.New("caixa cheia")
+				}
+				f.enviados[d]++
+			}
+		}
+		return nil
+	}}
+}
+
+func TestResumoPendentePorDestinatario(t *testing.T) {
+	config.Configuracao.Diretorios.Log = t.TempDir()
+	email = map[string][]string{"chamados": {"stef", "a@stef.com", "b@stef.com", ""}}
+	defer func() {
+		config.Configuracao.Diretorios.Log = ""
+		email = nil
+	}()
+
+	f := &smtpFalso{enviados: make(map[string]int), falhar: map[string]bool{"b@stef.com": true}}
+	r := novoNotificadorResumo(resumoconfig{Janela: duracao{time.Hour}, Destinatarios: []string{"ops@stef.com"}}, f.notificador())
+	r.Notificar(notificacao{Evento: eventoFalha, Empresa: "stef", Chave: "chamados", Arquivo: "chamados jan.xlsx"})
+
+	r.pendente.Inicio = time.Now().Add(-2 * time.Hour)
+	r.enviarSeVencido()
+	if f.enviados["a@stef.com"] != 1 || f.enviados["ops@stef.com"] != 1 || f.enviados["b@stef.com"] != 0 {
+		t.Fatalf("primeira janela: envios %v", f.enviados)
+	}
+	var pendentes []string
+	for d := range r.pendente.Destinatarios {
+		pendentes = append(pendentes, d)
+	}
+	if strings.Join(pendentes, ",") != "b@stef.com" || len(r.pendente.Itens) != 0 {
+		t.Fatalf("pendente depois da falha = %+v", r.pendente)
+	}
+
+	// O pendente sobrevive entre execuções.
+	r = novoNotificadorResumo(resumoconfig{Janela: duracao{time.Hour}, Destinatarios: []string{"ops@stef.com"}}, f.notificador())
+	r.Notificar(notificacao{Evento: eventoSucesso, Empresa: "stef", Chave: "outro", Arquivo: "outro.xlsx"})
+	delete(f.falhar, "b@stef.com")
+	r.pendente.Inicio = time.Now().Add(-2 * time.Hour)
+	r.enviarSeVencido()
+
+	// Só b recebe de novo o item da primeira janela; ops recebe o novo item.
+	esperado := map[string]int{"a@stef.com": 1, "b@stef.com": 1, "ops@stef.com": 2}
+	for d, n := range esperado {
+		if f.enviados[d] != n {
+			t.Errorf("segunda janela: envios %v, esperado %v", f.enviados, esperado)
+			break
+		}
+	}
+	if len(r.pendente.Destinatarios) != 0 || len(r.pendente.Itens) != 0 {
+		t.Errorf("pendente depois de tudo enviado = %+v", r.pendente)
+	}
+}
+
+func TestResumoAntesDaJanela(t *testing.T) {
+	config.Configuracao.Diretorios.Log = t.TempDir()
+	defer func() { config.Configuracao.Diretorios.Log = "" }()
+
+	f := &smtpFalso{enviados: make(map[string]int)}
+	r := novoNotificadorResumo(resumoconfig{Janela: duracao{time.Hour}, Destinatarios: []string{"ops@stef.com"}}, f.notificador())
+	r.Notificar(notificacao{Evento: eventoFalha, Arquivo: "a.xlsx"})
+	r.enviarSeVencido()
+	if len(f.enviados) != 0 || len(r.pendente.Itens) != 1 {
+		t.Errorf("envio antes do fim da janela: %v, pendente %+v", f.enviados, r.pendente)
+	}
+}
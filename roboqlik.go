@@ -4,10 +4,11 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"runtime/pprof"
 	"strings"
 	"sync"
@@ -43,43 +44,7 @@ type dicionario struct {
 	Tipo        string `json:"tipo,omitempty"`
 	Obrigatorio string `json:"obrigatorio,omitempty"`
 	Empresa     string `json:"empresa,omitempty"`
-}
-
-type Config struct {
-	Configuracao configuracao `json:"configuracao"`
-}
-type configuracao struct {
-	Criardiretorio   string      `json:"criardiretorio"`
-	ExecucaoContinua string      `json:"execucaocontinua"`
-	TempoDeExecucao  int         `json:"tempodeexecucao"`
-	EnviarEmail      string      `json:"enviaremail"`
-	Diretorios       diretorios  `json:"diretorios"`
-	Metadados        metadados   `json:"metadados"`
-	Email            emailconfig `json:"email"`
-}
-
-type diretorios struct {
-	CSVGerados           string `json:"csvgerados"`
-	PlanilhasImportadas  string `json:"planilhasimportadas"`
-	PlanilhasAImportar   string `json:"planilhasaimportar"`
-	PlanilhasComErro     string `json:"planilhascomerro"`
-	PlanilhasSemMetaDado string `json:"planilhasSemMetaDado"`
-	Log                  string `json:"log"`
-}
-
-type metadados struct {
-	Diretorio   string `json:"diretorio"`
-	NomeArquivo string `json:"nomearquivo"`
-}
-
-type emailconfig struct {
-	NomeRemetente string `json:"nomeremetende"`
-	Titulo        string `json:"titulo"`
-	Mensagem      string `json:"mensagem"`
-	Servidor      string `json:"servidor"`
-	Porta         int    `json:"porta"`
-	ContaEmail    string `json:"contaemail"`
-	Senha         string `json:"senha"`
+	Chave       string `json:"chave,omitempty"`
 }
 
 var (
@@ -87,17 +52,43 @@ var (
 	est        map[string][]*dicionario
 	email      map[string][]string
 	wg         sync.WaitGroup
-	tasks      chan string
+	tasks      chan tarefa
 	config     Config
 	dicArquivo map[string]string
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 var memprofile = flag.String("memprofile", "", "write memory profile to this file")
+var encryptSecret = flag.Bool("encrypt-secret", false, "read a secret from stdin and print it as an enc: config value")
+var secretKey = flag.String("secret-key", "roboqlik.key", "key file for enc: config values")
+var checkConfig = flag.Bool("check-config", false, "validate the configuration and print each effective value with its source")
+var configFile = flag.String("config", "config.json", "configuration file")
+var profile = flag.String("profile", "", "configuration profile from \"perfis\" (default ROBOQLIK_PERFIL or \"perfil\")")
+var sets listaFlag
+
+func init() {
+	flag.Var(&sets, "set", "override a configuration value, e.g. -set email.porta=25 (repeatable)")
+	flag.Usage = func() {
+		usoComandos(os.Stderr)
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "\n"+textoPrecedencia)
+	}
+}
 
 func main() {
 	flag.Parse()
 
+	if *encryptSecret {
+		cifrarSegredoEntrada(*secretKey)
+		return
+	}
+	if *checkConfig {
+		if !verificarConfiguracao(opcoesDaLinhaDeComando()) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if validaVersao() {
 
 		//profile de cpu
@@ -110,13 +101,7 @@ func main() {
 			defer pprof.StopCPUProfile()
 		}
 
-		tasks = make(chan string, 50)
-
-		carregarConfiguracao()
-		criarFilaProcessamento(4)
-
-		dic, est, email, dicArquivo = carregaDicionario()
-		carregarArquivoNaFilaWalk()
+		codigo := executarComando(flag.Args())
 
 		// profile de memoria
 		if *memprofile != "" {
@@ -127,6 +112,10 @@ func main() {
 			pprof.WriteHeapProfile(fm)
 			fm.Close()
 		}
+		if codigo != 0 {
+			pprof.StopCPUProfile()
+			os.Exit(codigo)
+		}
 	}
 }
 
@@ -141,63 +130,542 @@ func validaVersao() bool {
 	}
 }
 
-func carregarConfiguracao() {
-	dat, err := ioutil.ReadFile("config.json")
+// geraArquivoCSV move a planilha que não gerou CSV: para PlanilhasComErro
+// (nomesheet vazio), com o log e o relatório de erro, ou para
+// PlanilhasSemMetaDado. Os CSVs das planilhas importadas são gravados por
+// gravarAbaCSV e concluirImportacao.
+func geraArquivoCSV(logger *log.Logger, relatorio *relatorioErro, nome string, arq string, nomesheet string, nomeEmpresa string, t tarefa) {
+	//fmt.Println(fmt.Sprintf(" CSV - sheet=%s / nome=%s / nomeEmpresa=%s:", nomesheet, nome, nomeEmpresa))
+	if nomesheet == "" {
+		fmt.Println("ERRO: ", fmt.Sprintf("%s\\%s", t.origem(), nome))
+		os.Rename(fmt.Sprintf("%s\\%s.xlsx", t.origem(), nome), fmt.Sprintf("%s\\%s.xlsx", criarPasta(config.Configuracao.Diretorios.PlanilhasComErro, t.Pasta), nome))
+		os.Rename(fmt.Sprintf("%s\\%s.xlsm", t.origem(), nome), fmt.Sprintf("%s\\%s.xlsm", naPasta(config.Configuracao.Diretorios.PlanilhasComErro, t.Pasta), nome))
+		os.Rename(fmt.Sprintf("%s\\%s.xls", t.origem(), nome), fmt.Sprintf("%s\\%s.xls", naPasta(config.Configuracao.Diretorios.PlanilhasComErro, t.Pasta), nome))
+		os.Rename(fmt.Sprintf("%s\\%s", t.origem(), nome), fmt.Sprintf("%s\\%s", naPasta(config.Configuracao.Diretorios.PlanilhasSemMetaDado, t.Pasta), nome))
+		os.Rename(fmt.Sprintf("%s\\%s.log", naPasta(config.Configuracao.Diretorios.Log, t.Pasta), nome), fmt.Sprintf("%s\\%s_%s.log", naPasta(config.Configuracao.Diretorios.PlanilhasComErro, t.Pasta), nome, nomesheet))
+		if err := relatorio.gravar(naPasta(config.Configuracao.Diretorios.PlanilhasComErro, t.Pasta), nome); err != nil {
+			fmt.Println("Erro ao gerar o relatório de erro - ", err.Error())
+		}
+		aux := strings.Split(arq, "|")
+		registrarLedger(registroLedger{Arquivo: nome, Chave: aux[0], Pasta: t.Pasta, Pacote: t.Pacote, Status: eventoFalha, Motivos: relatorio.motivos(), Causa: relatorio.causaFalha()})
+		if relatorio.causaFalha() == causaTransitoria && config.Configuracao.Reprocessamento.Ativo {
+			// Será reprocessado sozinho; a quarentena notifica se não passar.
+			return
+		}
+		notificar(notificacao{
+			Evento:   eventoFalha,
+			Chave:    aux[0],
+			Arquivo:  nome,
+			Mensagem: config.Configuracao.Email.Mensagem,
+			Motivos:  relatorio.motivos(),
+			Anexos:   append([]string{fmt.Sprintf("%s\\%s_%s.log", naPasta(config.Configuracao.Diretorios.PlanilhasComErro, t.Pasta), nome, nomesheet)}, relatorio.Anexos...),
+		})
+	} else {
+		if nomeEmpresa != "" {
+			nomeEmpresa = nomeEmpresa + "_"
+		}
+		fmt.Println("SemMetaDado: ", fmt.Sprintf("%s\\%s%s", t.origem(), nomeEmpresa, nome))
+		os.Rename(fmt.Sprintf("%s\\%s.xlsx", t.origem(), nome), fmt.Sprintf("%s\\%s%s.xlsx", criarPasta(config.Configuracao.Diretorios.PlanilhasSemMetaDado, t.Pasta), nomeEmpresa, nome))
+		os.Rename(fmt.Sprintf("%s\\%s.xlsm", t.origem(), nome), fmt.Sprintf("%s\\%s%s.xlsm", naPasta(config.Configuracao.Diretorios.PlanilhasSemMetaDado, t.Pasta), nomeEmpresa, nome))
+		os.Rename(fmt.Sprintf("%s\\%s.xls", t.origem(), nome), fmt.Sprintf("%s\\%s%s.xls", naPasta(config.Configuracao.Diretorios.PlanilhasSemMetaDado, t.Pasta), nomeEmpresa, nome))
+		os.Rename(fmt.Sprintf("%s\\%s", t.origem(), nome), fmt.Sprintf("%s\\%s%s", naPasta(config.Configuracao.Diretorios.PlanilhasSemMetaDado, t.Pasta), nomeEmpresa, nome))
+		os.Remove(fmt.Sprintf("%s\\%s.log", naPasta(config.Configuracao.Diretorios.Log, t.Pasta), nome))
+		aux := strings.Split(arq, "|")
+		registrarLedger(registroLedger{Arquivo: nome, Chave: aux[0], Empresa: strings.TrimSuffix(nomeEmpresa, "_"), Pasta: t.Pasta, Pacote: t.Pacote, Status: eventoSemMetadado})
+		notificar(notificacao{Evento: eventoSemMetadado, Chave: aux[0], Empresa: strings.TrimSuffix(nomeEmpresa, "_"), Arquivo: nome})
+	}
+}
+
+// moverImportado move o arquivo (nome sem extensão) para PlanilhasImportadas
+// e apaga o log dele.
+func moverImportado(nome string, t tarefa) {
+	fmt.Println("sem erro: ", fmt.Sprintf("%s\\%s", naPasta(config.Configuracao.Diretorios.PlanilhasImportadas, t.Pasta), nome))
+	os.Rename(fmt.Sprintf("%s\\%s.xlsx", t.origem(), nome), fmt.Sprintf("%s\\%s.xlsx", criarPasta(config.Configuracao.Diretorios.PlanilhasImportadas, t.Pasta), nome))
+	os.Rename(fmt.Sprintf("%s\\%s.xlsm", t.origem(), nome), fmt.Sprintf("%s\\%s.xlsm", naPasta(config.Configuracao.Diretorios.PlanilhasImportadas, t.Pasta), nome))
+	os.Rename(fmt.Sprintf("%s\\%s.xls", t.origem(), nome), fmt.Sprintf("%s\\%s.xls", naPasta(config.Configuracao.Diretorios.PlanilhasImportadas, t.Pasta), nome))
+	os.Rename(fmt.Sprintf("%s\\%s", t.origem(), nome), fmt.Sprintf("%s\\%s", naPasta(config.Configuracao.Diretorios.PlanilhasSemMetaDado, t.Pasta), nome))
+	os.Remove(fmt.Sprintf("%s\\%s.log", naPasta(config.Configuracao.Diretorios.Log, t.Pasta), nome))
+}
+
+// gravarAbaCSV grava a aba convertida em <csv>.parcial, que concluirImportacao
+// troca pelo CSV definitivo quando todas as abas do arquivo foram gravadas. O
+// índice do delta também só é gravado lá.
+func gravarAbaCSV(nome string, plan [][]string, arq string, nomesheet string, nomeAgrupador string, nomeEmpresa string) (*saidaAba, error) {
+	s := &saidaAba{nome: strings.TrimSuffix(nomesheet, "_"), emp: []string{nomeEmpresa, nomeAgrupador}}
+	s.csv = destinoCSV(strings.Split(arq, "|")[0], nome, nomeAgrupador, nomesheet)
+	delta, err := aplicarDelta(plan, strings.Split(arq, "|")[0], nomeAgrupador, nomeEmpresa, nomesheet, len(capturarNomeArquivo(strings.Split(arq, "|")[0], nome).Colunas))
 	if err != nil {
-		fmt.Println("Erro ao carregar a configuração.")
-		panic(err.Error())
+		fmt.Println("Erro no delta, carga completa - ", err.Error())
+	} else if delta != nil {
+		plan = delta.Plan
+		s.delta = delta
+		s.csv = delta.destino(s.csv)
 	}
-	err = json.Unmarshal(dat, &config)
+
+	file, err := os.Create(s.csv + ".parcial")
 	if err != nil {
-		fmt.Println("Erro ao carregar a configuração.")
-		panic(err.Error())
+		return nil, err
+	}
+	err = escreverCSV(file, plan, nomeAgrupador, nomeEmpresa, nomesheet)
+	if errC := file.Close(); err == nil {
+		err = errC
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	s.temp = file.Name()
+	return s, nil
+}
+
+// concluirImportacao troca os CSVs temporários das abas pelos definitivos,
+// grava os índices (delta e duplicidade) e as exclusões, move a planilha para
+// PlanilhasImportadas e registra o sucesso no ledger. Se algum CSV não puder
+// ser gravado, apaga os já trocados e manda a planilha para PlanilhasComErro.
+// Devolve se a planilha foi importada.
+func concluirImportacao(logger *log.Logger, relatorio *relatorioErro, nome string, arq []string, t tarefa, saidas []*saidaAba, abas []abaLedger, imp impressaoArquivo) bool {
+	var csvs []string
+	for _, s := range saidas {
+		if s.temp == "" {
+			continue
+		}
+		os.Remove(s.csv)
+		if err := os.Rename(s.temp, s.csv); err != nil {
+			logger.Println(fmt.Sprintf("[plan: %s] - Erro ao gravar o CSV [%s]. %s", s.nome, s.csv, err.Error()))
+			relatorio.erroTransitorio("Não foi possível gravar o CSV. Uma nova tentativa será feita automaticamente.")
+			for _, c := range csvs {
+				os.Remove(c)
+			}
+			descartarSaidas(saidas)
+			geraArquivoCSV(logger, relatorio, nome, arq[2], "", "", t)
+			return false
+		}
+		s.temp = ""
+		csvs = append(csvs, s.csv)
+	}
+
+	for _, s := range saidas {
+		if s.delta == nil {
+			continue
+		}
+		indicesMu.Lock()
+		err := s.delta.concluir()
+		indicesMu.Unlock()
+		if err != nil {
+			fmt.Println("Erro ao gravar o índice do delta - ", err.Error())
+		}
+		d := s.delta
+		fmt.Println(fmt.Sprintf("Delta %s: %d nova(s), %d alterada(s), %d inalterada(s), %d excluída(s)", s.csv, d.Novas, d.Alteradas, d.Inalteradas, len(d.Exclusoes)))
+		if config.Configuracao.Delta.Exclusoes && len(d.Exclusoes) > 0 {
+			if _, err := gravarExclusoes(s.csv, &d.resultadoDelta, s.emp[1], s.nome+"_"); err != nil {
+				fmt.Println("Erro ao gravar as exclusões - ", err.Error())
+			}
+		}
+	}
+
+	for _, s := range saidas {
+		if s.chaves != nil {
+			if err := s.chaves.gravar(); err != nil {
+				fmt.Println("Erro ao gravar o índice de chaves - ", err.Error())
+			}
+		}
+	}
+
+	moverImportado(nome, t)
+	captura := capturarNomeArquivo(arq[2], arq[1])
+	r := registroLedger{Data: time.Now(), Arquivo: arq[1], Chave: arq[2], Pasta: t.Pasta, Pacote: t.Pacote, Status: eventoSucesso, CSVs: csvs, Captura: captura.Mapa(), Periodo: captura.Periodo(), Abas: abas, Hash: imp.Arquivo, HashConteudo: imp.Conteudo}
+	registrarLedger(r)
+	if imp.Arquivo != "" || imp.Conteudo != "" {
+		confirmarImpressao(imp, r)
+	}
+	notificar(notificacao{Evento: eventoSucesso, Chave: arq[2], Arquivo: arq[1]})
+	return true
+}
+
+// escreverCSV grava a planilha convertida no dialeto configurado para a saída.
+func escreverCSV(out io.Writer, plan [][]string, nomeAgrupador string, nomeEmpresa string, nomesheet string) error {
+	dialeto := dialetoDaSaida(nomeAgrupador, nomesheet)
+	if *dialeto.SomenteCabecalho {
+		plan = plan[:1]
+	}
+	numericas := colunasNumericas(plan[0], nomeAgrupador, nomeEmpresa, nomesheet)
+
+	w := novoEscritorCSV(out, dialeto)
+	for _, linha := range plan[1:] {
+		formatarNumericas(linha, numericas, dialeto.SeparadorDecimal)
+	}
+	w.WriteAll(plan)
+	return w.Error()
+}
+
+func interpretarPlanilha(arq []string, t tarefa, cpu int) {
+
+	defer wg.Done()
+
+	auxname := strings.Split(arq[1], ".xls")
+	nomeLog := fmt.Sprintf("%s\\%s.log", criarPasta(config.Configuracao.Diretorios.Log, t.Pasta), auxname[0])
+	file, err := os.Create(nomeLog)
+	if err != nil {
+		fmt.Println("create log: ", err.Error())
+		return
+	}
+	defer file.Close()
+
+	logger := log.New(file, "", log.Ldate+log.Ltime)
+	logger.SetOutput(file)
+	relatorio := novoRelatorioErro(arq[1])
+
+	erroarq := false
+	var xlFile *xlsx.File
+	var imp impressaoArquivo
+
+	nomeArq := fmt.Sprintf("%s\\%s", t.origem(), arq[1])
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("log interp...")
+
+			logger.Println(fmt.Sprintf("Erro ao abrir o arquivo [%s]. O Arquivo não esta no formato correto. %s", nomeArq, r))
+			relatorio.erroArquivo(fmt.Sprintf("O arquivo não esta no formato correto. %s", r))
+			file.Close()
+			geraArquivoCSV(logger, relatorio, strings.ToLower(auxname[0]), arq[2], "", "", t)
+
+		}
+	}()
+
+	if strings.Contains(arq[1], ".xls") {
+		if info, errS := os.Stat(nomeArq); errS == nil {
+			if arquivoGrande(info) {
+				interpretarEmFluxo(logger, relatorio, file, nomeLog, arq, t)
+				return
+			}
+			defer memoriaEmUso.liberar(memoriaEmUso.reservar(info.Size() * fatorOpenFile))
+		}
+		xlFile, err = xlsx.OpenFile(nomeArq)
+		if _, leitura := err.(*os.PathError); leitura {
+			logger.Println(fmt.Sprintf("Erro ao ler o arquivo [%s]. %s", nomeArq, err.Error()))
+			relatorio.erroTransitorio("Não foi possível ler o arquivo. Uma nova tentativa será feita automaticamente.")
+			erroarq = true
+		} else if err != nil {
+			logger.Println(fmt.Sprintf("Erro ao abrir o arquivo [%s]. \n O Arquivo não esta no formato correto. %s", nomeArq, err.Error()))
+			relatorio.erroArquivo("O arquivo não esta no formato xlsx. Salve a planilha no formato xlsx e envie novamente.")
+			erroarq = true
+		}
+	} else {
+		erroarq = true
+	}
+
+	importado := false
+	if !erroarq && config.Configuracao.Entrada.Duplicados {
+		imp = impressaoDe(nomeArq, empresaDaChave(arq[2]), xlFile)
+		if original, tipo := reservarImpressao(t, imp); original != nil {
+			file.Close()
+			os.Remove(nomeLog)
+			moverDuplicado(t, imp, original, tipo)
+			return
+		}
+		defer func() {
+			if !importado {
+				liberarImpressao(imp)
+			}
+		}()
+	}
+
+	var auxPlan map[string][][]string
+	auxPlan = make(map[string][][]string)
+	chaves := make(map[string]*chavesPendentes)
+	var agrup string
+	var auxemp string
+	var abas []abaLedger
+	if !erroarq {
+		for _, sheet := range xlFile.Sheets {
+			plan := carregaPlan(logger, relatorio, arq, sheet)
+			emp, ok := dic[arq[2]+"|"+strings.ToLower(sheet.Name)]
+			agrup = ""
+			auxemp = ""
+			if ok {
+				agrup = emp[1]
+				auxemp = emp[0]
+				if len(plan) > 0 {
+					origem := cabecalhoDaAba(sheet)
+					abas = append(abas, abaLedger{Sheet: sheet.Name, Origem: origem, Saida: mapearCabecalho(emp, sheet.Name, origem).cabecalho()})
+					plan, chaves[auxemp+"|"+agrup+"|"+sheet.Name+"_"] = deduplicarAba(logger, relatorio, plan, arq, sheet.Name, emp, t)
+				}
+			}
+			auxPlan[auxemp+"|"+agrup+"|"+sheet.Name+"_"] = plan
+
+		}
+	}
+	auxemp = empresaDaChave(arq[2])
+
+	auxFile, errF := file.Stat()
+	if errF != nil {
+	}
+
+	nome := strings.ToLower(strings.Replace(strings.Replace(strings.Replace(arq[1], ".xlsx", "", -1), ".xlsm", "", -1), ".xls", "", -1))
+	if auxFile.Size() > 0 {
+		auxname := strings.Split(arq[1], ".xls")
+		geraArquivoCSV(logger, relatorio, strings.ToLower(auxname[0]), arq[2], "", auxemp, t)
+	} else {
+		var saidas []*saidaAba
+		gravado := true
+		for k, v := range auxPlan {
+			if len(v) > 0 {
+				aux := strings.Split(k, "|")
+				s, err := gravarAbaCSV(nome, v, arq[2], aux[2], aux[1], aux[0])
+				if err != nil {
+					logger.Println(fmt.Sprintf("[plan: %s] - Erro ao gravar o CSV. %s", strings.TrimSuffix(aux[2], "_"), err.Error()))
+					relatorio.erroTransitorio("Não foi possível gravar o CSV. Uma nova tentativa será feita automaticamente.")
+					gravado = false
+					break
+				}
+				s.chaves = chaves[k]
+				saidas = append(saidas, s)
+			}
+		}
+		switch {
+		case !gravado:
+			descartarSaidas(saidas)
+			geraArquivoCSV(logger, relatorio, nome, arq[2], "", auxemp, t)
+		case len(saidas) > 0:
+			importado = concluirImportacao(logger, relatorio, nome, arq, t, saidas, abas, imp)
+		default:
+			geraArquivoCSV(logger, relatorio, nome, arq[2], "_", auxemp, t)
+		}
+	}
+}
+
+func carregaPlan(logger *log.Logger, relatorio *relatorioErro, arq []string, sheet *xlsx.Sheet) [][]string {
+	var plan [][]string
+	var m *mapeamentoCabecalho
+	captura := capturarNomeArquivo(arq[2], arq[1])
+
+	emp, ok := dic[arq[2]+"|"+strings.ToLower(sheet.Name)]
+	if ok {
+		for i, row := range sheet.Rows {
+			if i == 0 { //trata o cabeçalho
+				var origem []string
+				for _, cels := range row.Cells {
+					origem = append(origem, cels.Value)
+				}
+				m = mapearCabecalho(emp, sheet.Name, origem)
+				for _, cab := range m.Faltantes {
+					logger.Println(fmt.Sprintf("[plan: %s] - A coluna [%s] é obrigatório e não se encontra na planilha. Verifique o dicionário de dados deste arquivo.\n\r", sheet.Name, cab.De))
+					relatorio.colunaFaltante(sheet.Name, cab.De, origem)
+				}
+				if len(m.Faltantes) > 0 {
+					return [][]string{}
+				}
+				plan = append(plan, append(m.cabecalho(), captura.Colunas...))
+				continue
+			}
+
+			var valores []string
+			for _, cels := range row.Cells {
+				valores = append(valores, cels.Value)
+			}
+			validarLinha(relatorio, sheet.Name, i+1, valores, m.Mapeadas)
+			plan = append(plan, m.linha(valores, emp[0], captura))
+		}
+	}
+	return plan
+}
+
+// cabecalhoDaAba devolve a primeira linha da aba, como está na planilha.
+func cabecalhoDaAba(sheet *xlsx.Sheet) []string {
+	var origem []string
+	if len(sheet.Rows) > 0 {
+		for _, cels := range sheet.Rows[0].Cells {
+			origem = append(origem, cels.Value)
+		}
+	}
+	return origem
+}
+
+// mapeamentoCabecalho é o cabeçalho de uma aba resolvido pelo dicionário do
+// agrupador: colunas mapeadas, colunas descartadas, obrigatórias ausentes e
+// colunas acrescentadas com valor padrão.
+type mapeamentoCabecalho struct {
+	Origem      []string
+	Mapeadas    map[int]*dicionario
+	Excluir     map[int]bool
+	Faltantes   []*dicionario
+	Complemento []*dicionario
+	Padroes     []string
+}
+
+// mapearCabecalho resolve o cabeçalho origem da aba para a empresa/agrupador
+// emp (valor de dic). Quando o agrupador não tem dicionário as colunas passam
+// como estão.
+func mapearCabecalho(emp []string, nomeSheet string, origem []string) *mapeamentoCabecalho {
+	m := &mapeamentoCabecalho{Origem: origem, Mapeadas: make(map[int]*dicionario), Excluir: make(map[int]bool)}
+	agr := emp[1]
+	cabecalho := "|"
+	cabecalhoDe := "|"
+
+	if ag, ok := est[agr]; ok {
+		for j, conteudo := range origem {
+			achei := false
+			for _, cab := range ag {
+				if cab.De == strings.ToLower(conteudo) && !strings.Contains(cabecalho, "|"+cab.Para+"|") && cab.Sheet == strings.ToLower(nomeSheet) && cab.Empresa == emp[0] {
+					cabecalho = cabecalho + cab.Para + "|"
+					m.Mapeadas[j] = cab
+					achei = true
+					break
+				}
+			}
+			if !achei {
+				m.Excluir[j] = true
+			}
+		}
+	}
+
+	for _, cab := range est[agr] {
+		if emp[0] != cab.Empresa || strings.Contains(cabecalho, "|"+cab.Para+"|") {
+			continue
+		}
+		if cab.Obrigatorio == "s" {
+			if !strings.Contains(cabecalhoDe, "|"+cab.De+"|") {
+				cabecalhoDe = cabecalhoDe + cab.De + "|"
+				m.Faltantes = append(m.Faltantes, cab)
+			}
+		} else if cab.Obrigatorio != "o" && cab.Obrigatorio != "n" {
+			aux := ""
+			if cab.Tipo == "n" {
+				aux = "0"
+			}
+			m.Complemento = append(m.Complemento, cab)
+			m.Padroes = append(m.Padroes, aux)
+		}
+	}
+	return m
+}
+
+// cabecalho devolve o cabeçalho de saída: colunas mantidas (com o Para quando
+// mapeadas), as colunas com valor padrão e idempresa.
+func (m *mapeamentoCabecalho) cabecalho() []string {
+	var linha []string
+	for j, conteudo := range m.Origem {
+		if m.Excluir[j] {
+			continue
+		}
+		if cab, ok := m.Mapeadas[j]; ok {
+			conteudo = cab.Para
+		}
+		linha = append(linha, conteudo)
+	}
+	for _, cab := range m.Complemento {
+		linha = append(linha, cab.Para)
 	}
+	return append(linha, "idempresa")
+}
 
-	if config.Configuracao.Criardiretorio == "S" {
-		os.MkdirAll(config.Configuracao.Diretorios.CSVGerados, os.ModeType)
-		os.MkdirAll(config.Configuracao.Diretorios.Log, os.ModeType)
-		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasAImportar, os.ModeType)
-		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasComErro, os.ModeType)
-		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasImportadas, os.ModeType)
-		os.MkdirAll(config.Configuracao.Diretorios.PlanilhasSemMetaDado, os.ModeType)
+// linha monta a linha de saída a partir dos valores da planilha, na ordem de
+// cabecalho(), seguida das colunas capturadas do nome do arquivo. Linhas mais
+// curtas que o cabeçalho (em branco ou terminadas na última célula preenchida)
+// são completadas com valores vazios.
+func (m *mapeamentoCabecalho) linha(valores []string, empresa string, captura capturaNome) []string {
+	for len(valores) < len(m.Origem) {
+		valores = append(valores, "")
+	}
+	var linha []string
+	for j, valor := range valores {
+		if !m.Excluir[j] {
+			linha = append(linha, valor)
+		}
 	}
+	linha = append(linha, m.Padroes...)
+	linha = append(linha, empresa)
+	return append(linha, captura.Valores...)
 }
 
+// validarLinha registra no relatório os valores obrigatórios em branco e os
+// valores não numéricos em colunas do tipo "n".
+func validarLinha(relatorio *relatorioErro, sheet string, numLinha int, valores []string, mapeadas map[int]*dicionario) {
+	for j, cab := range mapeadas {
+		valor := ""
+		if j < len(valores) {
+			valor = valores[j]
+		}
+		if cab.Obrigatorio == "s" && strings.TrimSpace(valor) == "" {
+			relatorio.problemaLinha(sheet, numLinha, cab.De, "Valor obrigatório em branco.")
+		} else if cab.Tipo == "n" && !ehNumero(valor) {
+			relatorio.problemaLinha(sheet, numLinha, cab.De, fmt.Sprintf("Valor não numérico: %q.", valor))
+		}
+	}
+}
+
+// criarFilaProcessamento inicia os workers de tasks. Quem enfileira faz
+// wg.Add(1) antes de enviar, para o wg.Wait do fim da passada esperar também
+// as tarefas ainda no canal: só então o metadado pode ser recarregado.
 func criarFilaProcessamento(numCPU int) {
 	for i := 0; i < numCPU; i++ {
 		go func(cpu int) {
-			for arq := range tasks {
-				wg.Add(1)
-				fmt.Println("cpu: ", cpu, " ", arq)
-				cmd := exec.Command("ls")
-				//cmd := exec.Command(".\\roboexcel.exe ", fmt.Sprintf("-nomearquivo=%q", arq))
-				fmt.Println(cmd.Path, cmd.Args)
-				err := cmd.Start() //err := cmd.Run()
-				err = cmd.Wait()
-				if err != nil {
+			for t := range tasks {
+				info, ambiguos := buscarNomeArquivoDaEmpresa(strings.ToLower(t.Arquivo), empresaDaPasta(t.Pasta))
+				if ambiguos != nil {
+					moverAmbiguo(t, ambiguos)
+					wg.Done()
+				} else if motivo, ok := motivoRetencao(info[2], empresaDaPasta(t.Pasta)); ok {
+					reterArquivo(t, info[2], motivo)
+					wg.Done()
+				} else {
+					interpretarPlanilha(info, t, cpu)
+				}
+				if t.Pacote != "" {
+					concluirMembro(t)
 				}
-				//fmt.Println("Erro ao esperar a Interpretação do arquivo - ", err.Error())
-				/*
-					//err := cmd.Start()
-					if err != nil {
-						fmt.Println("Erro ao Interpretar o arquivo - ", err.Error())
-					} else {
-						err = cmd.Wait()
-						if err != nil {
-							fmt.Println("Erro ao esperar a Interpretação do arquivo - ", err.Error())
-						}
-						wg.Done()
-					}
-				*/
-				wg.Done()
 			}
 		}(i)
 	}
 }
 
+// buscarNomeArquivo devolve [chave|aba, arquivo, chave] do padrão mais
+// específico do metadado que casa com o nome; sem correspondência devolve o
+// próprio nome nas três posições. Quando os melhores candidatos empatam, o
+// segundo retorno traz os candidatos e o arquivo não deve ser processado.
+func buscarNomeArquivo(arq string) ([]string, []padraoArquivo) {
+	return buscarNomeArquivoDaEmpresa(arq, "")
+}
+
+// buscarNomeArquivoDaEmpresa é buscarNomeArquivo considerando só as entradas
+// da empresa informada (a pasta do arquivo, com entrada.empresapelapasta).
+func buscarNomeArquivoDaEmpresa(arq string, empresa string) ([]string, []padraoArquivo) {
+	arq = strings.Replace(arq, "–", "-", -1)
+	if strings.Contains(arq, ".xls") {
+		aux := strings.TrimSpace(strings.Replace(strings.Replace(strings.Replace(arq, ".xlsx", "", -1), ".xlsm", "", -1), ".xls", "", -1))
+
+		candidatos := candidatosArquivo(aux)
+		if empresa != "" {
+			candidatos = daEmpresa(candidatos, empresa)
+		}
+		if ambiguos := empatados(candidatos); ambiguos != nil {
+			return []string{arq, arq, arq}, ambiguos
+		}
+		if len(candidatos) > 0 {
+			return []string{dicArquivo[candidatos[0].Chave], arq, candidatos[0].Chave}, nil
+		}
+	}
+	return []string{arq, arq, arq}, nil
+}
+
+// moverAmbiguo separa em PlanilhasAmbiguas um arquivo que casa com mais de
+// uma entrada do metadado, em vez de processá-lo com uma delas ao acaso.
+func moverAmbiguo(t tarefa, candidatos []padraoArquivo) {
+	motivos := append([]string{"O nome do arquivo corresponde a mais de uma entrada do metadado:"}, descreverCandidatos(candidatos)...)
+	fmt.Println("Ambiguo: ", fmt.Sprintf("%s\\%s", t.origem(), t.Arquivo))
+	erro := os.Rename(fmt.Sprintf("%s\\%s", t.origem(), t.Arquivo), fmt.Sprintf("%s\\%s", criarPasta(config.Configuracao.Diretorios.PlanilhasAmbiguas, t.Pasta), t.Arquivo))
+	if erro != nil {
+		fmt.Println(erro.Error())
+	}
+	registrarLedger(registroLedger{Arquivo: t.Arquivo, Pasta: t.Pasta, Pacote: t.Pacote, Status: eventoAmbiguo, Motivos: motivos})
+	notificar(notificacao{Evento: eventoAmbiguo, Arquivo: t.Arquivo, Motivos: motivos})
+}
+
 func carregarArquivoNaFilaWalk() {
 	dirname := config.Configuracao.Diretorios.PlanilhasAImportar + "\\"
 	for {
+		reprocessarPendentes()
 
 		walker := fs.Walk(dirname)
 		for walker.Step() {
@@ -206,26 +674,62 @@ func carregarArquivoNaFilaWalk() {
 				continue
 			}
 			info := walker.Stat()
-			if !info.IsDir() {
-				if fmt.Sprintf(".\\%s", walker.Path()) != fmt.Sprintf("%s\\%s", config.Configuracao.Diretorios.PlanilhasAImportar, info.Name()) {
-					erro := os.Rename(fmt.Sprintf(".\\%s", walker.Path()), fmt.Sprintf("%s\\%s", config.Configuracao.Diretorios.PlanilhasAImportar, info.Name()))
-					if erro != nil {
-						fmt.Println(erro.Error())
-					}
+			rel, err := filepath.Rel(filepath.Clean(config.Configuracao.Diretorios.PlanilhasAImportar), walker.Path())
+			if err != nil {
+				fmt.Println(err.Error())
+				continue
+			}
+			if info.IsDir() {
+				if rel != "." && (ignoradoPorPadrao(info.Name()) || arquivoOculto(info)) {
+					walker.SkipDir()
+				}
+				continue
+			}
+			if !aceitarArquivo(walker.Path(), rel, info) {
+				continue
+			}
+			if config.Configuracao.Entrada.Recursiva {
+				pasta, nome := separarPasta(rel)
+				if extensaoPacote(nome) != "" {
+					abrirPacote(tarefa{Arquivo: nome, Pasta: pasta})
+					continue
+				}
+				fmt.Println(rel)
+				wg.Add(1)
+				tasks <- tarefa{Arquivo: nome, Pasta: pasta}
+				continue
+			}
+			if fmt.Sprintf(".\\%s", walker.Path()) != fmt.Sprintf("%s\\%s", config.Configuracao.Diretorios.PlanilhasAImportar, info.Name()) {
+				destino := fmt.Sprintf("%s\\%s", config.Configuracao.Diretorios.PlanilhasAImportar, info.Name())
+				if _, err := os.Stat(destino); err == nil {
+					fmt.Println(fmt.Sprintf("%s não foi movido: já existe %s. Use entrada.recursiva para manter as subpastas.", walker.Path(), destino))
+					continue
+				}
+				erro := os.Rename(fmt.Sprintf(".\\%s", walker.Path()), destino)
+				if erro != nil {
+					fmt.Println(erro.Error())
 				}
-				fmt.Println(info.Name())
-				tasks <- info.Name()
 			}
+			if extensaoPacote(info.Name()) != "" {
+				abrirPacote(tarefa{Arquivo: info.Name()})
+				continue
+			}
+			fmt.Println(info.Name())
+			wg.Add(1)
+			tasks <- tarefa{Arquivo: info.Name()}
 		}
-		if config.Configuracao.ExecucaoContinua != "S" {
+		encerrarObservacao()
+		if !config.Configuracao.ExecucaoContinua {
 			wg.Wait()
+			notificarResumo()
 			break
 		}
 
-		fmt.Println(fmt.Sprintf("---   %d segundos...   ---", config.Configuracao.TempoDeExecucao))
-		time.Sleep(time.Duration(config.Configuracao.TempoDeExecucao) * time.Second)
+		fmt.Println(fmt.Sprintf("---   %s...   ---", config.Configuracao.TempoDeExecucao))
+		time.Sleep(config.Configuracao.TempoDeExecucao.Duration)
 
 		wg.Wait()
+		notificarResumo()
 	}
 	close(tasks)
 }
@@ -247,29 +751,99 @@ func carregarArquivoNaFila() {
 		}
 		for _, file := range files {
 			if file.Mode().IsRegular() {
-				tasks <- file.Name()
+				wg.Add(1)
+				tasks <- tarefa{Arquivo: file.Name()}
 			}
 		}
-		if config.Configuracao.ExecucaoContinua != "S" {
+		if !config.Configuracao.ExecucaoContinua {
 			break
 		}
-		time.Sleep(time.Duration(config.Configuracao.TempoDeExecucao) * time.Second)
-		fmt.Println(fmt.Sprintf("---   %d segundos...   ---", config.Configuracao.TempoDeExecucao))
+		time.Sleep(config.Configuracao.TempoDeExecucao.Duration)
+		fmt.Println(fmt.Sprintf("---   %s...   ---", config.Configuracao.TempoDeExecucao))
 	}
 	close(tasks)
 	wg.Wait()
 }
 
-func carregaDicionario() (map[string][]string, map[string][]*dicionario, map[string][]string, map[string]string) {
-	excelFileName := config.Configuracao.Metadados.Diretorio + "\\" + config.Configuracao.Metadados.NomeArquivo
-	//fmt.Println("metadado: ", excelFileName)
+// carregaDicionario lê uma fonte de metadado: pasta de trabalho xlsx ou
+// documento .json/.yaml (ver documentoMetadado). Um erro em qualquer linha
+// invalida só esta fonte.
+func carregaDicionario(caminho string) (m *metadado, err error) {
+	//fmt.Println("metadado: ", caminho)
+	defer func() {
+		if r := recover(); r != nil {
+			m = nil
+			err = fmt.Errorf("%v", r)
+		}
+	}()
 
+	doc, err := lerDocumentoMetadado(caminho)
+	if err != nil {
+		return nil, err
+	}
+	return montarMetadado(caminho, doc)
+}
+
+// lerMetadadoXlsx lê as duas abas da pasta de trabalho (estrutura e e-mails)
+// como estão, sem normalizar. A primeira linha de cada aba é o cabeçalho.
+func lerMetadadoXlsx(excelFileName string) (*documentoMetadado, error) {
 	xlFile, err := xlsx.OpenFile(excelFileName)
 	if err != nil {
-		fmt.Println(fmt.Sprintf("Erro ao abrir o arquivo [%s]. \n Salvar o arquivo no formato xlsx, provavelmente o arquivo esta no formato antigo xls. \n%s", excelFileName, err.Error()))
-		panic(err.Error())
+		return nil, fmt.Errorf("Erro ao abrir o arquivo [%s]. Salvar o arquivo no formato xlsx, provavelmente o arquivo esta no formato antigo xls. %s", excelFileName, err.Error())
+	}
+	if len(xlFile.Sheets) < 2 {
+		return nil, fmt.Errorf("o arquivo [%s] deve ter as abas de estrutura e de e-mails", excelFileName)
 	}
 
+	doc := &documentoMetadado{Versao: versaoMetadado}
+	celula := func(row *xlsx.Row, j int) string {
+		if j < len(row.Cells) {
+			return row.Cells[j].Value
+		}
+		return ""
+	}
+
+	sheet := xlFile.Sheets[0]
+	for i, row := range sheet.Rows {
+		if len(row.Cells) > 0 && i > 0 {
+			doc.Estrutura = append(doc.Estrutura, linhaEstrutura{
+				Empresa:     celula(row, 0),
+				Agrupador:   celula(row, 1),
+				NomeArquivo: celula(row, 2),
+				Sheet:       celula(row, 3),
+				Caminho:     celula(row, 4),
+				De:          celula(row, 5),
+				Para:        celula(row, 6),
+				Obrigatorio: celula(row, 7),
+				Tipo:        celula(row, 8),
+				Padrao:      celula(row, 9),
+				Chave:       celula(row, 10),
+				local:       fmt.Sprintf("aba %s, linha %d", sheet.Name, i+1),
+			})
+		}
+	}
+
+	sheet = xlFile.Sheets[1]
+	for i, row := range sheet.Rows {
+		if len(row.Cells) > 0 && i > 0 {
+			doc.Emails = append(doc.Emails, linhaEmail{
+				Empresa:     celula(row, 0),
+				NomeArquivo: celula(row, 1),
+				Email1:      celula(row, 2),
+				Email2:      celula(row, 3),
+				Email3:      celula(row, 4),
+				Webhook:     celula(row, 5),
+				Formato:     celula(row, 6),
+			})
+		}
+	}
+	return doc, nil
+}
+
+// montarMetadado normaliza as linhas do documento para o formato usado no
+// processamento (chaves em minúsculas; Para, webhook e expressões re: como
+// estão).
+func montarMetadado(origem string, doc *documentoMetadado) (*metadado, error) {
 	var empresa map[string][]string
 	empresa = make(map[string][]string)
 
@@ -282,118 +856,92 @@ func carregaDicionario() (map[string][]string, map[string][]*dicionario, map[str
 	var dicArq map[string]string
 	dicArq = make(map[string]string)
 
-	var (
-		emp string
-		agr string
-		//cam     string
-		plan    string
-		nomeArq string
-		de      string
-		para    string
-		obr     string
-		tipo    string
-		email1  string
-		email2  string
-		email3  string
-	)
-
-	sheet := xlFile.Sheets[0]
-	for i, row := range sheet.Rows {
-		if len(row.Cells) > 0 && i > 0 {
-
-			emp = strings.ToLower(row.Cells[0].Value)
-			agr = strings.ToLower(row.Cells[1].Value)
-			nomeArq = strings.ToLower(row.Cells[2].Value)
-			plan = strings.ToLower(row.Cells[3].Value)
-			//cam = strings.ToLower(row.Cells[4].Value)
-			de = strings.ToLower(row.Cells[5].Value)
-			para = row.Cells[6].Value
-			obr = strings.ToLower(row.Cells[7].Value)
-
-			if len(row.Cells) > 8 {
-				tipo = strings.ToLower(row.Cells[8].Value)
-			}
-			arq1 := strings.Split(nomeArq, "*")
-			arq1_0 := strings.Replace(arq1[0], "–", "-", -1)
-			if strings.TrimSpace(arq1[0]) != "" {
-				ind := fmt.Sprintf("%s|%s", arq1_0, plan)
-				_, ok := dicArq[arq1_0]
-				if !ok {
-					dicArq[arq1_0] = ind
-				}
-				_, ok = empresa[ind]
-				if !ok {
-					empdic := []string{emp, agr, plan}
-					empresa[ind] = append(empresa[ind], empdic...)
-				}
-
-				agdic := dicionario{plan, de, para, tipo, obr, emp}
-				agrupador[agr] = append(agrupador[agr], &agdic)
-			}
-
+	var padroes []padraoArquivo
+	padroesVistos := make(map[string]bool)
+
+	for i, row := range doc.Estrutura {
+		emp := strings.ToLower(row.Empresa)
+		agr := strings.ToLower(row.Agrupador)
+		plan := strings.ToLower(row.Sheet)
+		de := strings.ToLower(row.De)
+		para := row.Para
+		obr := strings.ToLower(row.Obrigatorio)
+		tipo := strings.ToLower(row.Tipo)
+		captura := strings.TrimSpace(row.Padrao)
+		chave := strings.ToLower(strings.TrimSpace(row.Chave))
+
+		local := row.local
+		if local == "" {
+			local = fmt.Sprintf("estrutura[%d]", i)
 		}
-	}
-
-	sheet = xlFile.Sheets[1]
-	for i, row := range sheet.Rows {
-		if len(row.Cells) > 0 && i > 0 {
-
-			emp = strings.ToLower(row.Cells[0].Value)
-			nomeArq = strings.ToLower(row.Cells[1].Value)
-			if len(row.Cells) > 2 {
-				email1 = strings.ToLower(row.Cells[2].Value)
-			}
-			if len(row.Cells) > 3 {
-				email2 = strings.ToLower(row.Cells[3].Value)
+		padrao, err := novoPadraoArquivo(row.NomeArquivo)
+		if err == nil && captura != "" {
+			padrao.captura, err = compilarCaptura(captura)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", local, err.Error())
+		}
+		if strings.TrimSpace(padrao.Chave) != "" {
+			ind := fmt.Sprintf("%s|%s", padrao.Chave, plan)
+			_, ok := dicArq[padrao.Chave]
+			if !ok {
+				dicArq[padrao.Chave] = ind
 			}
-			if len(row.Cells) > 4 {
-				email3 = strings.ToLower(row.Cells[4].Value)
+			if !padroesVistos[padrao.Texto] {
+				padroesVistos[padrao.Texto] = true
+				padroes = append(padroes, padrao)
+			} else if padrao.captura != nil {
+				for k := range padroes {
+					if padroes[k].Texto == padrao.Texto && padroes[k].captura == nil {
+						padroes[k].captura = padrao.captura
+					}
+				}
 			}
-
-			_, ok := email[nomeArq]
+			_, ok = empresa[ind]
 			if !ok {
-				empdic := []string{emp, email1, email2, email3}
-				email[nomeArq] = append(email[nomeArq], empdic...)
+				empdic := []string{emp, agr, plan}
+				empresa[ind] = append(empresa[ind], empdic...)
 			}
-		}
-	}
 
-	jsonString, err := json.MarshalIndent(agrupador, "", "\t")
-	if err != nil {
-		jsonString = []byte("erro: " + err.Error())
-	}
-	err = ioutil.WriteFile(config.Configuracao.Metadados.Diretorio+"\\DicionarioAgrupador.json", jsonString, os.ModeType)
-	if err != nil {
-		panic(err.Error())
+			agdic := dicionario{plan, de, para, tipo, obr, emp, chave}
+			agrupador[agr] = append(agrupador[agr], &agdic)
+		}
 	}
 
-	jsonString, err = json.MarshalIndent(empresa, "", "\t")
-	if err != nil {
-		jsonString = []byte("erro: " + err.Error())
-	}
-	err = ioutil.WriteFile(config.Configuracao.Metadados.Diretorio+"\\DicionarioEmpresa.json", jsonString, os.ModeType)
-	if err != nil {
-		panic(err.Error())
+	for _, row := range doc.Emails {
+		nomeArq := strings.ToLower(row.NomeArquivo)
+		_, ok := email[nomeArq]
+		if !ok {
+			empdic := []string{strings.ToLower(row.Empresa), strings.ToLower(row.Email1), strings.ToLower(row.Email2), strings.ToLower(row.Email3), strings.TrimSpace(row.Webhook), strings.ToLower(row.Formato)}
+			email[nomeArq] = append(email[nomeArq], empdic...)
+		}
 	}
 
-	jsonString, err = json.MarshalIndent(dicArq, "", "\t")
-	if err != nil {
-		jsonString = []byte("erro: " + err.Error())
-	}
-	err = ioutil.WriteFile(config.Configuracao.Metadados.Diretorio+"\\DicionarioArquivo.json", jsonString, os.ModeType)
-	if err != nil {
-		panic(err.Error())
-	}
+	return &metadado{Origem: origem, Empresa: empresa, Agrupador: agrupador, Email: email, Arquivo: dicArq, Padroes: padroes}, nil
+}
 
-	jsonString, err = json.MarshalIndent(email, "", "\t")
-	if err != nil {
-		jsonString = []byte("erro: " + err.Error())
+// exportarDicionario grava o metadado carregado em DicionarioAgrupador.json,
+// DicionarioEmpresa.json, DicionarioArquivo.json e DicionarioEmail.json.
+func exportarDicionario(dir string) error {
+	arquivos := []struct {
+		nome  string
+		dados interface{}
+	}{
+		{"DicionarioAgrupador.json", est},
+		{"DicionarioEmpresa.json", dic},
+		{"DicionarioArquivo.json", dicArquivo},
+		{"DicionarioEmail.json", email},
 	}
-	err = ioutil.WriteFile(config.Configuracao.Metadados.Diretorio+"\\DicionarioEmail.json", jsonString, os.ModeType)
-	if err != nil {
-		panic(err.Error())
+	for _, a := range arquivos {
+		jsonString, err := json.MarshalIndent(a.dados, "", "\t")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(dir+"\\"+a.nome, jsonString, 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Println(dir + "\\" + a.nome)
 	}
-
-	return empresa, agrupador, email, dicArq
-
+	return nil
 }
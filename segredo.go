@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Os campos da configuração marcados com a tag segredo:"sim" (a senha do
+// e-mail e as URLs dos webhooks, que costumam levar tokens) podem referenciar
+// segredos:
+//
+//	${ENV:NOME}    variável de ambiente NOME
+//	file:caminho   conteúdo do arquivo (sem a quebra de linha final)
+//	enc:base64     valor cifrado com AES-GCM pela chave de -secret-key
+//
+// Os demais textos (diretórios, padrões, modelos) ficam como estão. Os valores
+// resolvidos nunca são impressos; mensagens de erro citam apenas o campo.
+const tamanhoChave = 32
+
+// resolverSegredos resolve os segredos de cfg, menos os das seções em
+// ignorar (caminhos como "configuracao.email"), que ficam como estão.
+func resolverSegredos(cfg interface{}, arquivoChave string, ignorar ...string) error {
+	return percorrerSegredos(reflect.ValueOf(cfg).Elem(), "", ignorar, false, func(s string) (string, error) {
+		return resolverSegredo(s, arquivoChave)
+	})
+}
+
+// secoesInativas são as seções da configuração de recursos desligados, cujos
+// segredos não precisam existir na máquina.
+func secoesInativas(c *Config) []string {
+	var s []string
+	if !c.Configuracao.EnviarEmail {
+		s = append(s, "configuracao.email")
+	}
+	return s
+}
+
+// mascararSegredos troca os segredos em texto puro de c por "****" (nas URLs
+// fica o endereço do servidor), para o -check-config. c não é alterado.
+func mascararSegredos(c configuracao) configuracao {
+	var copia configuracao
+	dat, err := json.Marshal(c)
+	if err == nil {
+		err = json.Unmarshal(dat, &copia)
+	}
+	if err != nil {
+		return configuracao{}
+	}
+	percorrerSegredos(reflect.ValueOf(&copia).Elem(), "", nil, false, func(s string) (string, error) {
+		if s == "" || ehReferenciaSegredo(s) {
+			return s, nil
+		}
+		if u, err := url.Parse(s); err == nil && u.Scheme != "" && u.Host != "" {
+			return u.Scheme + "://" + u.Host + "/****", nil
+		}
+		return "****", nil
+	})
+	return copia
+}
+
+func ehReferenciaSegredo(s string) bool {
+	return (strings.HasPrefix(s, "${ENV:") && strings.HasSuffix(s, "}")) || strings.HasPrefix(s, "file:") || strings.HasPrefix(s, "enc:")
+}
+
+// percorrerSegredos aplica f aos textos de v marcados como segredo.
+func percorrerSegredos(v reflect.Value, caminho string, ignorar []string, segredo bool, f func(string) (string, error)) error {
+	if contem(ignorar, caminho) {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			nome := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if nome == "" {
+				nome = strings.ToLower(t.Field(i).Name)
+			}
+			if err := percorrerSegredos(v.Field(i), juntarCaminho(caminho, nome), ignorar, t.Field(i).Tag.Get("segredo") == "sim", f); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := percorrerSegredos(v.Index(i), fmt.Sprintf("%s[%d]", caminho, i), ignorar, segredo, f); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			item := reflect.New(v.Type().Elem()).Elem()
+			item.Set(v.MapIndex(k))
+			if err := percorrerSegredos(item, juntarCaminho(caminho, fmt.Sprint(k.Interface())), ignorar, segredo, f); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, item)
+		}
+	case reflect.String:
+		if !segredo {
+			return nil
+		}
+		s, err := f(v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %s", caminho, err.Error())
+		}
+		v.SetString(s)
+	}
+	return nil
+}
+
+func juntarCaminho(caminho string, nome string) string {
+	if caminho == "" {
+		return nome
+	}
+	return caminho + "." + nome
+}
+
+func resolverSegredo(valor string, arquivoChave string) (string, error) {
+	switch {
+	case strings.HasPrefix(valor, "${ENV:") && strings.HasSuffix(valor, "}"):
+		nome := strings.TrimSuffix(strings.TrimPrefix(valor, "${ENV:"), "}")
+		s, ok := os.LookupEnv(nome)
+		if !ok {
+			return "", fmt.Errorf("variável de ambiente %s não definida", nome)
+		}
+		return s, nil
+	case strings.HasPrefix(valor, "file:"):
+		dat, err := ioutil.ReadFile(strings.TrimPrefix(valor, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("arquivo de segredo não encontrado (%s)", strings.TrimPrefix(valor, "file:"))
+		}
+		return strings.TrimRight(string(dat), "\r\n"), nil
+	case strings.HasPrefix(valor, "enc:"):
+		return decifrar(strings.TrimPrefix(valor, "enc:"), arquivoChave)
+	}
+	return valor, nil
+}
+
+func carregarChave(arquivoChave string, criar bool) ([]byte, error) {
+	chave, err := ioutil.ReadFile(arquivoChave)
+	if os.IsNotExist(err) && criar {
+		chave = make([]byte, tamanhoChave)
+		if _, err := io.ReadFull(rand.Reader, chave); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(arquivoChave, chave, 0600); err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(os.Stderr, "Chave criada em", arquivoChave, "- guarde este arquivo fora do repositório.")
+		return chave, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("chave de segredos %s não encontrada", arquivoChave)
+	}
+	if len(chave) != tamanhoChave {
+		return nil, fmt.Errorf("chave de segredos %s inválida", arquivoChave)
+	}
+	return chave, nil
+}
+
+func cifrar(valor string, arquivoChave string) (string, error) {
+	chave, err := carregarChave(arquivoChave, true)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := novoGCM(chave)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return "enc:" + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(valor), nil)), nil
+}
+
+func decifrar(valor string, arquivoChave string) (string, error) {
+	chave, err := carregarChave(arquivoChave, false)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := novoGCM(chave)
+	if err != nil {
+		return "", err
+	}
+	dat, err := base64.StdEncoding.DecodeString(valor)
+	if err != nil || len(dat) < gcm.NonceSize() {
+		return "", errors.New("valor cifrado inválido")
+	}
+	s, err := gcm.Open(nil, dat[:gcm.NonceSize()], dat[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("não foi possível decifrar o valor com a chave informada")
+	}
+	return string(s), nil
+}
+
+func novoGCM(chave []byte) (cipher.AEAD, error) {
+	bloco, err := aes.NewCipher(chave)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(bloco)
+}
+
+// cifrarSegredoEntrada lê o segredo da entrada padrão, para não ficar no
+// histórico do shell, e imprime o valor enc: para colar no config.json.
+func cifrarSegredoEntrada(arquivoChave string) {
+	fmt.Fprint(os.Stderr, "Valor a cifrar: ")
+	linha, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintln(os.Stderr, "Erro ao ler o valor:", err.Error())
+		os.Exit(1)
+	}
+	enc, err := cifrar(strings.TrimRight(linha, "\r\n"), arquivoChave)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erro ao cifrar:", err.Error())
+		os.Exit(1)
+	}
+	fmt.Println(enc)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolverSegredo(t *testing.T) {
+	dir := t.TempDir()
+	chave := filepath.Join(dir, "segredo.key")
+	enc, err := cifrar("senha cifrada", chave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arquivo := filepath.Join(dir, "senha.txt")
+	ioutil.WriteFile(arquivo, []byte("senha do arquivo\r\n"), 0600)
+	t.Setenv("ROBOQLIK_TESTE_SENHA", "senha do ambiente")
+
+	casos := []struct {
+		valor    string
+		esperado string
+	}{
+		{"texto puro", "texto puro"},
+		{"${ENV:ROBOQLIK_TESTE_SENHA}", "senha do ambiente"},
+		{"file:" + arquivo, "senha do arquivo"},
+		{enc, "senha cifrada"},
+	}
+	for _, c := range casos {
+		s, err := resolverSegredo(c.valor, chave)
+		if err != nil || s != c.esperado {
+			t.Errorf("resolverSegredo(%q) = %q, %v; esperado %q", c.valor, s, err, c.esperado)
+		}
+	}
+}
+
+func TestResolverSegredoErros(t *testing.T) {
+	dir := t.TempDir()
+	chave := filepath.Join(dir, "segredo.key")
+	enc, _ := cifrar("senha", chave)
+	outra := filepath.Join(dir, "outra.key")
+	cifrar("x", outra)
+
+	casos := []struct {
+		valor string
+		chave string
+		erro  string
+	}{
+		{"${ENV:ROBOQLIK_TESTE_NAO_DEFINIDA}", chave, "não definida"},
+		{"file:" + filepath.Join(dir, "nao existe.txt"), chave, "não encontrado"},
+		{enc, outra, "não foi possível decifrar"},
+		{enc, filepath.Join(dir, "sem chave.key"), "não encontrada"},
+		{"enc:não é base64", chave, "inválido"},
+	}
+	for _, c := range casos {
+		_, err := resolverSegredo(c.valor, c.chave)
+		if err == nil || !strings.Contains(err.Error(), c.erro) {
+			t.Errorf("resolverSegredo(%q) erro = %v, esperado %q", c.valor, err, c.erro)
+		}
+	}
+}
+
+func TestResolverSegredosSecoesInativas(t *testing.T) {
+	c := configPadrao()
+	c.Configuracao.Email.Senha = "${ENV:ROBOQLIK_TESTE_NAO_DEFINIDA}"
+	c.Configuracao.EnviarEmail = false
+	if err := resolverSegredos(&c, "", secoesInativas(&c)...); err != nil {
+		t.Errorf("segredo de e-mail desligado resolvido: %v", err)
+	}
+
+	c.Configuracao.EnviarEmail = true
+	err := resolverSegredos(&c, "", secoesInativas(&c)...)
+	if err == nil || !strings.Contains(err.Error(), "configuracao.email.senha") {
+		t.Errorf("erro = %v, esperado citar configuracao.email.senha", err)
+	}
+}
+
+func TestResolverSegredosSoCamposMarcados(t *testing.T) {
+	t.Setenv("ROBOQLIK_TESTE_SENHA", "senha do ambiente")
+	t.Setenv("ROBOQLIK_TESTE_TOKEN", "T0K3N")
+	c := configPadrao()
+	c.Configuracao.EnviarEmail = true
+	c.Configuracao.Email.Senha = "${ENV:ROBOQLIK_TESTE_SENHA}"
+	c.Configuracao.Diretorios.Log = "file:\\\\servidor\\log"
+	c.Configuracao.Webhook.Destinos = []destinoWebhook{{
+		URL:    "https://hooks.exemplo.com/${ENV:ROBOQLIK_TESTE_TOKEN}",
+		Modelo: `{"texto": "enc: {{json .Texto}} ${ENV:ROBOQLIK_TESTE_TOKEN}"}`,
+	}, {URL: "${ENV:ROBOQLIK_TESTE_TOKEN}"}}
+	if err := resolverSegredos(&c, ""); err != nil {
+		t.Fatal(err)
+	}
+	if c.Configuracao.Email.Senha != "senha do ambiente" || c.Configuracao.Webhook.Destinos[1].URL != "T0K3N" {
+		t.Errorf("segredos não resolvidos: senha %q, url %q", c.Configuracao.Email.Senha, c.Configuracao.Webhook.Destinos[1].URL)
+	}
+	if c.Configuracao.Diretorios.Log != "file:\\\\servidor\\log" || !strings.HasPrefix(c.Configuracao.Webhook.Destinos[0].Modelo, `{"texto": "enc:`) {
+		t.Errorf("campos que não são segredo alterados: log %q, modelo %q", c.Configuracao.Diretorios.Log, c.Configuracao.Webhook.Destinos[0].Modelo)
+	}
+}
+
+func TestMascararSegredos(t *testing.T) {
+	c := configPadrao()
+	c.Configuracao.Email.Senha = "a@m0R"
+	c.Configuracao.Webhook.Destinos = []destinoWebhook{
+		{URL: "https://hooks.slack.com/services/T000/B000/XXXX", Formato: "slack"},
+		{URL: "${ENV:ROBOQLIK_WEBHOOK}"},
+	}
+	exibir := mascararSegredos(c.Configuracao)
+	if exibir.Email.Senha != "****" {
+		t.Errorf("senha exibida como %q", exibir.Email.Senha)
+	}
+	if u := exibir.Webhook.Destinos[0].URL; u != "https://hooks.slack.com/****" {
+		t.Errorf("url exibida como %q", u)
+	}
+	if u := exibir.Webhook.Destinos[1].URL; u != "${ENV:ROBOQLIK_WEBHOOK}" {
+		t.Errorf("referência exibida como %q", u)
+	}
+	if c.Configuracao.Webhook.Destinos[0].URL != "https://hooks.slack.com/services/T000/B000/XXXX" || c.Configuracao.Email.Senha != "a@m0R" {
+		t.Error("mascararSegredos alterou a configuração original")
+	}
+	exibir.Email.Senha, exibir.Webhook.Destinos = c.Configuracao.Email.Senha, c.Configuracao.Webhook.Destinos
+	if !reflect.DeepEqual(exibir, c.Configuracao) {
+		t.Errorf("a cópia exibida difere além dos segredos:\n%+v\n%+v", exibir, c.Configuracao)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Precedência da configuração, da menor para a maior:
+//
+//  1. valores padrão (configPadrao)
+//  2. arquivo de configuração (-config, config.json por padrão)
+//  3. perfil escolhido em "perfis" (-profile, ROBOQLIK_PERFIL ou "perfil" no arquivo)
+//  4. variáveis de ambiente ROBOQLIK_<CAMINHO>, ex.: ROBOQLIK_EMAIL__PORTA=25
+//  5. -set caminho=valor, ex.: -set email.porta=25 (pode repetir)
+//
+// Os caminhos são relativos a "configuracao" e usam as chaves do JSON. Na
+// variável de ambiente o nome vai para minúsculas e as partes do caminho são
+// separadas por "__" (o ponto do -set), que não aparece nas chaves: assim
+// ROBOQLIK_SAIDAS__QLIK_NOVO__SOMENTECABECALHO é saidas.qlik_novo.somentecabecalho.
+const textoPrecedencia = `Precedência da configuração (da menor para a maior):
+  padrão < arquivo (-config) < perfil (-profile, ROBOQLIK_PERFIL ou "perfil")
+  < variáveis ROBOQLIK_<CAMINHO> com "__" entre as partes (ex.: ROBOQLIK_EMAIL__PORTA=25)
+  < -set caminho=valor (ex.: -set email.porta=25)`
+
+type opcoesConfig struct {
+	Arquivo string
+	Perfil  string
+	Sets    []string
+}
+
+// listaFlag acumula um flag que pode ser repetido.
+type listaFlag []string
+
+func (l *listaFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listaFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// aplicarPerfil mescla o perfil escolhido sobre a seção configuracao.
+func aplicarPerfil(cfg map[string]interface{}, perfis map[string]interface{}, perfil string, origens map[string]string) error {
+	p, ok := perfis[perfil].(map[string]interface{})
+	if !ok {
+		var nomes []string
+		for k := range perfis {
+			nomes = append(nomes, k)
+		}
+		sort.Strings(nomes)
+		return fmt.Errorf("perfil %q não encontrado (perfis: %s)", perfil, strings.Join(nomes, ", "))
+	}
+	mesclar(cfg, p, "", "perfil "+perfil, origens)
+	return nil
+}
+
+func mesclar(destino map[string]interface{}, origem map[string]interface{}, caminho string, nome string, origens map[string]string) {
+	for k, v := range origem {
+		c := juntarCaminho(caminho, k)
+		if sub, ok := v.(map[string]interface{}); ok {
+			if d, ok := destino[k].(map[string]interface{}); ok {
+				mesclar(d, sub, c, nome, origens)
+				continue
+			}
+		}
+		destino[k] = v
+		marcarOrigens(v, c, nome, origens)
+	}
+}
+
+func marcarOrigens(v interface{}, caminho string, nome string, origens map[string]string) {
+	if m, ok := v.(map[string]interface{}); ok {
+		for k, item := range m {
+			marcarOrigens(item, juntarCaminho(caminho, k), nome, origens)
+		}
+		return
+	}
+	origens[caminho] = nome
+}
+
+// aplicarAmbiente aplica as variáveis ROBOQLIK_*; o nome após o prefixo,
+// dividido em "__", é o caminho (um "_" simples faz parte da chave). Variáveis que não correspondem a um caminho
+// da configuração (ex.: as usadas em ${ENV:...}) são ignoradas.
+func aplicarAmbiente(cfg map[string]interface{}, origens map[string]string) error {
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "ROBOQLIK_") {
+			continue
+		}
+		kv := strings.SplitN(e, "=", 2)
+		nome := strings.ToLower(strings.TrimPrefix(kv[0], "ROBOQLIK_"))
+		if nome == "perfil" {
+			continue
+		}
+		partes := strings.Split(nome, "__")
+		t, ok := tipoDoCaminho(reflect.TypeOf(configuracao{}), partes)
+		if !ok {
+			continue
+		}
+		v, err := converterValor(t, kv[1])
+		if err != nil {
+			return fmt.Errorf("%s: %s", kv[0], err.Error())
+		}
+		definirCaminho(cfg, partes, v)
+		origens[strings.Join(partes, ".")] = "env " + kv[0]
+	}
+	return nil
+}
+
+func aplicarSets(cfg map[string]interface{}, sets []string, origens map[string]string) error {
+	for _, s := range sets {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("-set %s: use caminho=valor", s)
+		}
+		partes := strings.Split(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(kv[0])), "configuracao."), ".")
+		t, ok := tipoDoCaminho(reflect.TypeOf(configuracao{}), partes)
+		if !ok {
+			return fmt.Errorf("-set %s: caminho desconhecido", kv[0])
+		}
+		v, err := converterValor(t, kv[1])
+		if err != nil {
+			return fmt.Errorf("-set %s: %s", kv[0], err.Error())
+		}
+		definirCaminho(cfg, partes, v)
+		origens[strings.Join(partes, ".")] = "-set"
+	}
+	return nil
+}
+
+func tipoDoCaminho(t reflect.Type, partes []string) (reflect.Type, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if len(partes) == 0 {
+		return t, true
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == reflect.TypeOf(duracao{}) {
+			return nil, false
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if strings.Split(t.Field(i).Tag.Get("json"), ",")[0] == partes[0] {
+				return tipoDoCaminho(t.Field(i).Type, partes[1:])
+			}
+		}
+	case reflect.Map:
+		return tipoDoCaminho(t.Elem(), partes[1:])
+	}
+	return nil, false
+}
+
+// converterValor interpreta o texto de uma variável ou -set conforme o tipo do campo.
+func converterValor(t reflect.Type, s string) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(duracao{}) {
+		if _, err := time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("duração inválida %q", s)
+		}
+		return s, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return s, nil
+	case reflect.Bool:
+		switch strings.ToUpper(strings.TrimSpace(s)) {
+		case "S", "SIM", "TRUE", "1":
+			return true, nil
+		case "N", "NAO", "NÃO", "FALSE", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("booleano inválido %q", s)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("número inválido %q", s)
+		}
+		return n, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "[") {
+			var lista []interface{}
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					lista = append(lista, item)
+				}
+			}
+			return lista, nil
+		}
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, fmt.Errorf("JSON inválido: %s", err.Error())
+	}
+	return v, nil
+}
+
+func definirCaminho(m map[string]interface{}, partes []string, v interface{}) {
+	for _, p := range partes[:len(partes)-1] {
+		sub, ok := m[p].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			m[p] = sub
+		}
+		m = sub
+	}
+	m[partes[len(partes)-1]] = v
+}
+
+// linhaConfig é um valor efetivo com a camada de onde veio, para -check-config.
+type linhaConfig struct {
+	Caminho string
+	Valor   string
+	Origem  string
+}
+
+func linhasConfig(c configuracao, origens map[string]string) []linhaConfig {
+	var linhas []linhaConfig
+	achatar(reflect.ValueOf(c), "", &linhas)
+	for i := range linhas {
+		linhas[i].Origem = origemDe(linhas[i].Caminho, origens)
+	}
+	return linhas
+}
+
+func achatar(v reflect.Value, caminho string, linhas *[]linhaConfig) {
+	if v.Kind() == reflect.Struct && v.Type() != reflect.TypeOf(duracao{}) {
+		for i := 0; i < v.NumField(); i++ {
+			nome := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			if nome == "" || nome == "-" {
+				continue
+			}
+			achatar(v.Field(i), juntarCaminho(caminho, nome), linhas)
+		}
+		return
+	}
+	if v.Kind() == reflect.Map && v.Len() > 0 {
+		chaves := v.MapKeys()
+		sort.Slice(chaves, func(i, j int) bool { return chaves[i].String() < chaves[j].String() })
+		for _, k := range chaves {
+			achatar(v.MapIndex(k), juntarCaminho(caminho, k.String()), linhas)
+		}
+		return
+	}
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v.Interface())
+	*linhas = append(*linhas, linhaConfig{Caminho: caminho, Valor: strings.TrimSpace(b.String())})
+}
+
+// origemDe procura a camada do caminho ou do ancestral mais próximo.
+func origemDe(caminho string, origens map[string]string) string {
+	for c := caminho; c != ""; {
+		if o, ok := origens[c]; ok {
+			return o
+		}
+		i := strings.LastIndex(c, ".")
+		if i < 0 {
+			break
+		}
+		c = c[:i]
+	}
+	return "padrão"
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAplicarAmbiente(t *testing.T) {
+	t.Setenv("ROBOQLIK_EMAIL__PORTA", "25")
+	t.Setenv("ROBOQLIK_SAIDAS__QLIK_NOVO__SOMENTECABECALHO", "S")
+	t.Setenv("ROBOQLIK_TEMPODEEXECUCAO", "5m")
+	t.Setenv("ROBOQLIK_SMTP_SENHA", "segredo")
+	t.Setenv("ROBOQLIK_EMAIL_PORTA", "26")
+
+	cfg := map[string]interface{}{"email": map[string]interface{}{"porta": 587.0}}
+	origens := make(map[string]string)
+	if err := aplicarAmbiente(cfg, origens); err != nil {
+		t.Fatal(err)
+	}
+	if porta := cfg["email"].(map[string]interface{})["porta"]; porta != 25 {
+		t.Errorf("email.porta = %v", porta)
+	}
+	saida, _ := cfg["saidas"].(map[string]interface{})["qlik_novo"].(map[string]interface{})
+	if saida["somentecabecalho"] != true {
+		t.Errorf("saidas = %v", cfg["saidas"])
+	}
+	if cfg["tempodeexecucao"] != "5m" {
+		t.Errorf("tempodeexecucao = %v", cfg["tempodeexecucao"])
+	}
+	if origens["saidas.qlik_novo.somentecabecalho"] != "env ROBOQLIK_SAIDAS__QLIK_NOVO__SOMENTECABECALHO" {
+		t.Errorf("origens = %v", origens)
+	}
+	// Com "_" simples o nome é uma chave só, que não existe: a variável é ignorada.
+	if _, ok := cfg["smtp_senha"]; ok {
+		t.Error("ROBOQLIK_SMTP_SENHA aplicada à configuração")
+	}
+}
+
+func TestAplicarAmbienteValorInvalido(t *testing.T) {
+	t.Setenv("ROBOQLIK_EMAIL__PORTA", "vinte")
+	if err := aplicarAmbiente(make(map[string]interface{}), make(map[string]string)); err == nil {
+		t.Error("porta não numérica aceita")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tealeg/xlsx"
+)
+
+// sugestaoColuna é uma linha de metadado proposta para uma coluna da amostra,
+// com a confiança (0 a 100) e o mapeamento existente que a originou.
+type sugestaoColuna struct {
+	linhaEstrutura
+	Confianca float64
+	Base      string
+}
+
+// sugerirMapeamento lê o cabeçalho de cada aba da amostra e procura, nos
+// mapeamentos do agrupador em est (de todas as empresas), o De ou Para mais
+// parecido. Cada Para é usado uma vez por aba, começando pelos pares mais
+// parecidos; abaixo de minimo (%) o Para fica em branco para revisão.
+func sugerirMapeamento(xlFile *xlsx.File, agrupador string, empresa string, nomeArquivo string, minimo float64) []sugestaoColuna {
+	// Quantas empresas usam cada De→Para, para desempatar.
+	usos := make(map[string]int)
+	for _, d := range est[agrupador] {
+		usos[d.De+"|"+d.Para]++
+	}
+
+	type par struct {
+		coluna int
+		cab    *dicionario
+		score  float64
+	}
+
+	var sugestoes []sugestaoColuna
+	for _, sheet := range xlFile.Sheets {
+		origem := cabecalhoDaAba(sheet)
+		var pares []par
+		for j, c := range origem {
+			if strings.TrimSpace(c) == "" {
+				continue
+			}
+			for _, d := range est[agrupador] {
+				s := similaridade(c, d.De)
+				if sp := similaridade(c, d.Para); sp > s {
+					s = sp
+				}
+				if s > 0 {
+					pares = append(pares, par{j, d, s})
+				}
+			}
+		}
+		sort.SliceStable(pares, func(a, b int) bool {
+			if pares[a].score != pares[b].score {
+				return pares[a].score > pares[b].score
+			}
+			return usos[pares[a].cab.De+"|"+pares[a].cab.Para] > usos[pares[b].cab.De+"|"+pares[b].cab.Para]
+		})
+
+		escolhido := make(map[int]par)
+		melhor := make(map[int]par)
+		usados := make(map[string]bool)
+		for _, p := range pares {
+			if _, ok := melhor[p.coluna]; !ok {
+				melhor[p.coluna] = p
+			}
+			if _, ok := escolhido[p.coluna]; ok || usados[strings.ToLower(p.cab.Para)] || p.score*100 < minimo {
+				continue
+			}
+			escolhido[p.coluna] = p
+			usados[strings.ToLower(p.cab.Para)] = true
+		}
+
+		for j, c := range origem {
+			if strings.TrimSpace(c) == "" {
+				continue
+			}
+			s := sugestaoColuna{linhaEstrutura: linhaEstrutura{Empresa: empresa, Agrupador: agrupador, NomeArquivo: nomeArquivo, Sheet: strings.ToLower(sheet.Name), De: strings.ToLower(strings.TrimSpace(c))}}
+			if p, ok := escolhido[j]; ok {
+				s.Para, s.Tipo, s.Obrigatorio, s.Chave = p.cab.Para, p.cab.Tipo, p.cab.Obrigatorio, p.cab.Chave
+				s.Confianca = p.score * 100
+				s.Base = fmt.Sprintf("%s: %s → %s", p.cab.Empresa, p.cab.De, p.cab.Para)
+			} else if p, ok := melhor[j]; ok {
+				s.Confianca = p.score * 100
+				s.Base = fmt.Sprintf("parecida com %s: %s → %s", p.cab.Empresa, p.cab.De, p.cab.Para)
+			}
+			sugestoes = append(sugestoes, s)
+		}
+	}
+	return sugestoes
+}
+
+func imprimirSugestoes(w io.Writer, sugestoes []sugestaoColuna) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHEET\tDE\tPARA\tTIPO\tCONFIANÇA\tBASE")
+	revisar := 0
+	for _, s := range sugestoes {
+		if s.Para == "" {
+			revisar++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.0f%%\t%s\n", s.Sheet, s.De, s.Para, s.Tipo, s.Confianca, s.Base)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d coluna(s), %d sem sugestão.\n", len(sugestoes), revisar)
+}
+
+// gravarSugestoes grava o fragmento na ordem das colunas da aba Estrutura,
+// mais Confianca e Base, que devem ser apagadas ao colar no metadado. A aba
+// Email vai vazia para o fragmento também abrir como metadado.
+func gravarSugestoes(sugestoes []sugestaoColuna, caminho string) error {
+	file := xlsx.NewFile()
+	sheet, err := file.AddSheet("Estrutura")
+	if err != nil {
+		return err
+	}
+	adicionarLinha(sheet, append(append([]string{}, cabecalhoEstrutura...), "Confianca", "Base"))
+	for _, s := range sugestoes {
+		adicionarLinha(sheet, []string{s.Empresa, s.Agrupador, s.NomeArquivo, s.Sheet, s.Caminho, s.De, s.Para, s.Obrigatorio, s.Tipo, s.Padrao, s.Chave, fmt.Sprintf("%.0f", s.Confianca), s.Base})
+	}
+	emails, err := file.AddSheet("Email")
+	if err != nil {
+		return err
+	}
+	adicionarLinha(emails, cabecalhoEmail)
+	return file.Save(caminho)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func usarMapeamentosChamados(t *testing.T) {
+	est = map[string][]*dicionario{"chamados": {
+		{Empresa: "stef", Sheet: "plan1", De: "id do chamado", Para: "IdChamado", Tipo: "n", Obrigatorio: "s", Chave: "s"},
+		{Empresa: "stef", Sheet: "plan1", De: "titulo", Para: "Titulo"},
+		{Empresa: "abb", Sheet: "plan1", De: "abertura", Para: "DataAbertura", Tipo: "d"},
+	}}
+	t.Cleanup(func() { est = nil })
+}
+
+func TestSugerirMapeamento(t *testing.T) {
+	usarMapeamentosChamados(t)
+	amostra := planilhaDe([]string{"ID do Chamado", "Título", "Data de Abertura", "Observação"})
+	var obtido [][]string
+	for _, s := range sugerirMapeamento(amostra, "chamados", "novaempresa", "chamados nova", 60) {
+		obtido = append(obtido, []string{s.De, s.Para, s.Tipo, s.Obrigatorio, s.Chave})
+	}
+	esperado := [][]string{
+		{"id do chamado", "IdChamado", "n", "s", "s"},
+		{"título", "Titulo", "", "", ""},
+		{"data de abertura", "DataAbertura", "d", "", ""},
+		{"observação", "", "", "", ""},
+	}
+	if !reflect.DeepEqual(obtido, esperado) {
+		t.Errorf("sugestões = %q, esperado %q", obtido, esperado)
+	}
+}
+
+// TestGravarSugestoesCarregaComoMetadado confere que o fragmento gravado é lido
+// de volta como metadado, com as linhas sugeridas.
+func TestGravarSugestoesCarregaComoMetadado(t *testing.T) {
+	usarMapeamentosChamados(t)
+	amostra := planilhaDe([]string{"ID do Chamado", "Título", "Observação"})
+	sugestoes := sugerirMapeamento(amostra, "chamados", "nova", "chamados nova", 60)
+	caminho := filepath.Join(t.TempDir(), "sugestao.xlsx")
+	if err := gravarSugestoes(sugestoes, caminho); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := lerMetadadoXlsx(caminho)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := montarMetadado(caminho, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emp := m.Empresa["chamados nova|plan1"]; !reflect.DeepEqual(emp, []string{"nova", "chamados", "plan1"}) {
+		t.Errorf("DicionarioEmpresa = %v", m.Empresa)
+	}
+	var linhas []dicionario
+	for _, d := range m.Agrupador["chamados"] {
+		linhas = append(linhas, *d)
+	}
+	esperado := []dicionario{
+		{Sheet: "plan1", De: "id do chamado", Para: "IdChamado", Tipo: "n", Obrigatorio: "s", Empresa: "nova", Chave: "s"},
+		{Sheet: "plan1", De: "título", Para: "Titulo", Empresa: "nova"},
+		{Sheet: "plan1", De: "observação", Empresa: "nova"},
+	}
+	if !reflect.DeepEqual(linhas, esperado) {
+		t.Errorf("DicionarioAgrupador = %+v, esperado %+v", linhas, esperado)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type webhookconfig struct {
+	Tentativas int              `json:"tentativas"`
+	Intervalo  duracao          `json:"intervalo"`
+	Destinos   []destinoWebhook `json:"destinos"`
+}
+
+// destinoWebhook é um endpoint global. Formato json (padrão), slack ou teams;
+// Modelo, se informado, é um text/template que substitui o formato; a função
+// json escapa um valor para dentro do JSON, ex.: {"text": {{json .Texto}}}.
+// Eventos vazio recebe todos os eventos.
+type destinoWebhook struct {
+	URL     string   `json:"url" segredo:"sim"`
+	Formato string   `json:"formato"`
+	Modelo  string   `json:"modelo"`
+	Eventos []string `json:"eventos"`
+}
+
+type notificadorWebhook struct {
+	cfg        webhookconfig
+	tentativas int
+	intervalo  time.Duration
+	cliente    *http.Client
+	fila       *filaEntrega
+	mu         sync.Mutex
+}
+
+func novoNotificadorWebhook(cfg webhookconfig) *notificadorWebhook {
+	n := &notificadorWebhook{cfg: cfg, tentativas: cfg.Tentativas, intervalo: cfg.Intervalo.Duration}
+	if n.tentativas <= 0 {
+		n.tentativas = 3
+	}
+	if n.intervalo <= 0 {
+		n.intervalo = 2 * time.Second
+	}
+	n.cliente = &http.Client{Timeout: 15 * time.Second}
+	n.fila = novaFilaEntrega(1000)
+	return n
+}
+
+// Notificar põe na fila de envio o payload para os destinos globais inscritos
+// no evento e para o webhook da empresa cadastrado na aba de e-mails do
+// metadado (colunas 6 e 7). O que não puder ser entregue vai para naoEntregue.
+func (w *notificadorWebhook) Notificar(n notificacao) error {
+	destinos := []destinoWebhook{}
+	for _, d := range w.cfg.Destinos {
+		if len(d.Eventos) == 0 || contem(d.Eventos, n.Evento) {
+			destinos = append(destinos, d)
+		}
+	}
+	if e, ok := email[n.Chave]; ok && len(e) > 4 && e[4] != "" && n.Evento != eventoResumo {
+		formato := ""
+		if len(e) > 5 {
+			formato = e[5]
+		}
+		destinos = append(destinos, destinoWebhook{URL: e[4], Formato: formato})
+	}
+
+	var falhas []string
+	for _, d := range destinos {
+		url := d.URL
+		payload, err := montarPayload(d, n)
+		if err != nil {
+			falhas = append(falhas, err.Error())
+			w.naoEntregue(url, payload, err)
+			continue
+		}
+		w.fila.adicionar(entrega{
+			enviar: func(parar <-chan struct{}) error { return w.enviar(url, payload, parar) },
+			falhou: func(err error) {
+				fmt.Println(fmt.Sprintf("Erro ao notificar [%s] %s - webhook: %s", n.Evento, n.Arquivo, err.Error()))
+				w.naoEntregue(url, payload, err)
+			},
+		})
+	}
+	if len(falhas) > 0 {
+		return fmt.Errorf("webhook: %s", strings.Join(falhas, "; "))
+	}
+	return nil
+}
+
+func (w *notificadorWebhook) encerrar() {
+	w.fila.encerrar()
+}
+
+func (w *notificadorWebhook) enviar(url string, payload []byte, parar <-chan struct{}) error {
+	var err error
+	espera := w.intervalo
+	for t := 1; t <= w.tentativas; t++ {
+		var resp *http.Response
+		resp, err = w.cliente.Post(url, "application/json", bytes.NewReader(payload))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("%s respondeu %s", url, resp.Status)
+		}
+		if t < w.tentativas && !esperar(espera, parar) {
+			break
+		}
+		espera *= 2
+	}
+	return err
+}
+
+// naoEntregue guarda o payload em Log\webhook_naoentregue.json (uma linha por
+// envio) para reenvio manual quando o endpoint voltar.
+func (w *notificadorWebhook) naoEntregue(url string, payload []byte, erro error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, err := os.OpenFile(fmt.Sprintf("%s\\webhook_naoentregue.json", config.Configuracao.Diretorios.Log), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Println("Erro ao gravar webhook não entregue - ", err.Error())
+		return
+	}
+	defer f.Close()
+	var corpo interface{} = json.RawMessage(payload)
+	if !json.Valid(payload) {
+		corpo = string(payload)
+	}
+	linha, _ := json.Marshal(struct {
+		Data    time.Time   `json:"data"`
+		URL     string      `json:"url"`
+		Erro    string      `json:"erro"`
+		Payload interface{} `json:"payload"`
+	}{time.Now(), url, erro.Error(), corpo})
+	f.Write(append(linha, '\n'))
+}
+
+var titulosEvento = map[string]string{
+	eventoSucesso:     "Planilha importada",
+	eventoFalha:       "Planilha com erro",
+	eventoSemMetadado: "Planilha sem metadado",
+	eventoAmbiguo:     "Planilha com metadado ambíguo",
+	eventoRetido:      "Planilha retida (metadado com erro)",
+	eventoQuarentena:  "Planilha em quarentena",
+	eventoDuplicado:   "Planilha duplicada",
+	eventoResumo:      "Resumo da execução",
+}
+
+// modelosFormato são os modelos dos formatos slack e teams.
+var modelosFormato = map[string]string{
+	"slack": `{"text": {{json .Texto}}}`,
+	"teams": `{"@type": "MessageCard", "@context": "https://schema.org/extensions", "summary": {{json .Titulo}}, "title": {{json .Titulo}}, "text": {{json .Texto}}}`,
+}
+
+// funcoesModelo são as funções disponíveis no Modelo dos destinos.
+var funcoesModelo = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func montarPayload(d destinoWebhook, n notificacao) ([]byte, error) {
+	titulo := titulosEvento[n.Evento]
+	texto := n.Mensagem
+	if n.Arquivo != "" {
+		texto = fmt.Sprintf("%s: %s. %s", titulo, n.Arquivo, n.Mensagem)
+	}
+	if n.Empresa != "" {
+		texto = fmt.Sprintf("[%s] %s", n.Empresa, texto)
+	}
+
+	modelo := d.Modelo
+	if modelo == "" {
+		modelo = modelosFormato[strings.ToLower(d.Formato)]
+	}
+	if modelo != "" {
+		t, err := template.New("webhook").Funcs(funcoesModelo).Parse(modelo)
+		if err != nil {
+			return nil, err
+		}
+		var b bytes.Buffer
+		err = t.Execute(&b, struct {
+			notificacao
+			Titulo string
+			Texto  string
+		}{n, titulo, texto})
+		if err == nil && !json.Valid(b.Bytes()) {
+			err = fmt.Errorf("o modelo do webhook não gerou um JSON válido; use {{json .Campo}} para os valores")
+		}
+		return b.Bytes(), err
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"evento":   n.Evento,
+		"empresa":  n.Empresa,
+		"arquivo":  n.Arquivo,
+		"mensagem": n.Mensagem,
+		"data":     time.Now(),
+	})
+}
+
+func contem(lista []string, valor string) bool {
+	for _, v := range lista {
+		if strings.EqualFold(v, valor) {
+			return true
+		}
+	}
+	return false
+}
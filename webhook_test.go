@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMontarPayloadEscapaValores(t *testing.T) {
+	n := notificacao{Evento: eventoFalha, Empresa: "stef", Arquivo: `chamados "jan" c:\temp.xlsx`, Mensagem: "linha\nquebrada"}
+	destinos := []destinoWebhook{
+		{Formato: "json"},
+		{Formato: "slack"},
+		{Formato: "Teams"},
+		{Modelo: `{"arquivo": {{json .Arquivo}}, "texto": {{json .Texto}}, "motivos": {{json .Motivos}}}`},
+	}
+	for _, d := range destinos {
+		payload, err := montarPayload(d, n)
+		if err != nil {
+			t.Errorf("%+v: %v", d, err)
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(payload, &m); err != nil {
+			t.Errorf("%+v: payload inválido %s: %v", d, payload, err)
+			continue
+		}
+		if !strings.Contains(string(payload), `\"jan\"`) {
+			t.Errorf("%+v: payload sem o nome do arquivo: %s", d, payload)
+		}
+	}
+}
+
+func TestMontarPayloadModeloSemEscape(t *testing.T) {
+	_, err := montarPayload(destinoWebhook{Modelo: `{"arquivo": "{{.Arquivo}}"}`}, notificacao{Arquivo: `a"b`})
+	if err == nil {
+		t.Error("modelo que gera JSON inválido não deu erro")
+	}
+}
+
+func TestNotificadorWebhookNaoTravaQuemNotifica(t *testing.T) {
+	var mu sync.Mutex
+	var recebidos []string
+	liberar := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-liberar
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		recebidos = append(recebidos, string(b))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	w := novoNotificadorWebhook(webhookconfig{Tentativas: 1, Destinos: []destinoWebhook{{URL: srv.URL, Formato: "slack"}}})
+	inicio := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := w.Notificar(notificacao{Evento: eventoSucesso, Arquivo: "a.xlsx"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(inicio); d > time.Second {
+		t.Errorf("Notificar esperou a entrega: %s", d)
+	}
+	close(liberar)
+	w.encerrar()
+	if len(recebidos) != 3 {
+		t.Errorf("%d entrega(s), esperado 3", len(recebidos))
+	}
+}
+
+func TestNotificadorWebhookNaoEntregue(t *testing.T) {
+	config.Configuracao.Diretorios.Log = t.TempDir()
+	defer func() { config.Configuracao.Diretorios.Log = "" }()
+	tentativas := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tentativas++
+		http.Error(w, "fora do ar", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	w := novoNotificadorWebhook(webhookconfig{Tentativas: 3, Intervalo: duracao{time.Hour}, Destinos: []destinoWebhook{{URL: srv.URL}}})
+	w.Notificar(notificacao{Evento: eventoFalha, Arquivo: "a.xlsx"})
+	// O encerramento interrompe a espera de uma hora entre as tentativas.
+	inicio := time.Now()
+	w.encerrar()
+	if d := time.Since(inicio); d > 10*time.Second {
+		t.Errorf("encerrar esperou o intervalo entre tentativas: %s", d)
+	}
+	if tentativas != 1 {
+		t.Errorf("%d tentativa(s) depois de encerrar, esperado 1", tentativas)
+	}
+
+	dat, err := ioutil.ReadFile(config.Configuracao.Diretorios.Log + "\\webhook_naoentregue.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var linha struct {
+		URL     string
+		Erro    string
+		Payload map[string]interface{}
+	}
+	if err := json.Unmarshal(dat, &linha); err != nil {
+		t.Fatalf("linha não entregue inválida %s: %v", dat, err)
+	}
+	if linha.URL != srv.URL || !strings.Contains(linha.Erro, "503") || linha.Payload["arquivo"] != "a.xlsx" {
+		t.Errorf("não entregue = %+v", linha)
+	}
+}
+
+func TestNotificadorWebhookEventos(t *testing.T) {
+	var mu sync.Mutex
+	var eventos []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&m)
+		mu.Lock()
+		eventos = append(eventos, m["evento"].(string))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	w := novoNotificadorWebhook(webhookconfig{Destinos: []destinoWebhook{{URL: srv.URL, Eventos: []string{"Quarentena", "duplicado"}}}})
+	for _, e := range []string{eventoSucesso, eventoQuarentena, eventoFalha, eventoDuplicado} {
+		w.Notificar(notificacao{Evento: e})
+	}
+	w.encerrar()
+	if strings.Join(eventos, ",") != "quarentena,duplicado" {
+		t.Errorf("eventos entregues = %v", eventos)
+	}
+}